@@ -0,0 +1,200 @@
+/*
+Copyright © 2024 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hooksie1/gophemeral/secrets"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var apiKeyCmd = &cobra.Command{
+	Use:   "apikey",
+	Short: "Manage API keys",
+}
+
+var apiKeyCreateCmd = &cobra.Command{
+	Use:          "create",
+	Short:        "Create an API key, the key is only shown once",
+	RunE:         apiKeyCreate,
+	SilenceUsage: true,
+}
+
+var apiKeyListCmd = &cobra.Command{
+	Use:          "list",
+	Short:        "List API keys",
+	RunE:         apiKeyList,
+	SilenceUsage: true,
+}
+
+var apiKeyRevokeCmd = &cobra.Command{
+	Use:          "revoke <id>",
+	Short:        "Revoke an API key",
+	Args:         cobra.ExactArgs(1),
+	RunE:         apiKeyRevoke,
+	SilenceUsage: true,
+}
+
+func init() {
+	adminCmd.AddCommand(apiKeyCmd)
+	apiKeyCmd.AddCommand(apiKeyCreateCmd)
+	apiKeyCmd.AddCommand(apiKeyListCmd)
+	apiKeyCmd.AddCommand(apiKeyRevokeCmd)
+
+	apiKeyCreateCmd.Flags().String("name", "", "Name of the key")
+	viper.BindPFlag("apikey_name", apiKeyCreateCmd.Flags().Lookup("name"))
+	apiKeyCreateCmd.Flags().StringSlice("scope", []string{secrets.ScopeCreate}, fmt.Sprintf("Scopes of the key: %s", strings.Join(secrets.Scopes, ", ")))
+	viper.BindPFlag("apikey_scopes", apiKeyCreateCmd.Flags().Lookup("scope"))
+	apiKeyCreateCmd.Flags().Int("rate-limit", 0, "Requests per minute, 0 is unlimited")
+	viper.BindPFlag("apikey_rate_limit", apiKeyCreateCmd.Flags().Lookup("rate-limit"))
+	apiKeyCreateCmd.Flags().Int("daily-quota", 0, "Requests per day, 0 is unlimited")
+	viper.BindPFlag("apikey_daily_quota", apiKeyCreateCmd.Flags().Lookup("daily-quota"))
+	apiKeyCreateCmd.Flags().String("profile", "", "Policy profile used for secrets created with the key")
+	viper.BindPFlag("apikey_profile", apiKeyCreateCmd.Flags().Lookup("profile"))
+	apiKeyCreateCmd.Flags().String("tenant", "", "Tenant of the key")
+	viper.BindPFlag("apikey_tenant", apiKeyCreateCmd.Flags().Lookup("tenant"))
+}
+
+func adminAPIKeyStore() (*secrets.NATSAPIKeyStore, func(), error) {
+	nc, err := newNatsConnection("gophemeral-admin")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store, err := apiKeyStore(nc)
+	if err != nil {
+		nc.Close()
+		return nil, nil, err
+	}
+
+	return store, nc.Close, nil
+}
+
+func apiKeyCreate(cmd *cobra.Command, args []string) error {
+	store, done, err := adminAPIKeyStore()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	key, token, err := secrets.NewAPIKey(secrets.APIKey{
+		Name:       viper.GetString("apikey_name"),
+		Scopes:     viper.GetStringSlice("apikey_scopes"),
+		RateLimit:  viper.GetInt("apikey_rate_limit"),
+		DailyQuota: viper.GetInt("apikey_daily_quota"),
+		Profile:    viper.GetString("apikey_profile"),
+		Tenant:     viper.GetString("apikey_tenant"),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := store.Create(key); err != nil {
+		return err
+	}
+
+	fmt.Printf("ID: %s\n", key.ID)
+	fmt.Printf("Key: %s\n", token)
+	fmt.Println("Store the key now, it can't be shown again.")
+
+	return nil
+}
+
+func apiKeyList(cmd *cobra.Command, args []string) error {
+	store, done, err := adminAPIKeyStore()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	keys, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSCOPES\tRATE LIMIT\tDAILY QUOTA\tPROFILE\tTENANT\tCREATED\tREVOKED")
+	for _, v := range keys {
+		revoked := "-"
+		if v.Revoked != nil {
+			revoked = v.Revoked.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			v.ID,
+			orDash(v.Name),
+			strings.Join(v.Scopes, ","),
+			limit(v.RateLimit),
+			limit(v.DailyQuota),
+			orDash(v.Profile),
+			orDash(v.Tenant),
+			v.Created.Format(time.RFC3339),
+			revoked,
+		)
+	}
+
+	return w.Flush()
+}
+
+func apiKeyRevoke(cmd *cobra.Command, args []string) error {
+	store, done, err := adminAPIKeyStore()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	key, err := store.Get(args[0])
+	if err != nil {
+		return err
+	}
+
+	if key.Revoked != nil {
+		return fmt.Errorf("api key %s was already revoked", key.ID)
+	}
+
+	now := time.Now().UTC()
+	key.Revoked = &now
+	if err := store.Put(key); err != nil {
+		return err
+	}
+
+	fmt.Printf("revoked api key %s\n", key.ID)
+
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+func limit(v int) string {
+	if v == 0 {
+		return "unlimited"
+	}
+
+	return fmt.Sprint(v)
+}
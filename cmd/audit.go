@@ -0,0 +1,147 @@
+/*
+Copyright © 2024 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/hooksie1/gophemeral/secrets"
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Work with the audit log",
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:          "verify",
+	Short:        "Verify the hash chain of the audit log",
+	RunE:         auditVerify,
+	SilenceUsage: true,
+}
+
+var auditExportCmd = &cobra.Command{
+	Use:          "export",
+	Short:        "Export the audit log as JSON lines",
+	RunE:         auditExport,
+	SilenceUsage: true,
+}
+
+func init() {
+	adminCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+	auditCmd.AddCommand(auditExportCmd)
+	auditExportCmd.Flags().StringP("output", "o", "", "File to write the export to (default stdout)")
+	viper.BindPFlag("audit_output", auditExportCmd.Flags().Lookup("output"))
+}
+
+// auditStore connects to the audit log
+func auditStore() (*secrets.NATSAuditStore, *nats.Conn, error) {
+	nc, err := newNatsConnection("gophemeral-admin")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, nil, err
+	}
+
+	store, err := secrets.NewNATSAuditStore(js, viper.GetString("audit_stream"), viper.GetString("audit_subject"))
+	if err != nil {
+		nc.Close()
+		return nil, nil, err
+	}
+
+	return store, nc, nil
+}
+
+func auditVerify(cmd *cobra.Command, args []string) error {
+	store, nc, err := auditStore()
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	// the head is read first so entries appended while reading don't fail the check
+	head, err := store.Head()
+	if err != nil {
+		return err
+	}
+
+	entries, err := store.Entries()
+	if err != nil {
+		return err
+	}
+
+	if i := slices.IndexFunc(entries, func(e secrets.AuditEntry) bool { return e.Seq > head.Seq }); i >= 0 && head.Seq > 0 {
+		entries = entries[:i]
+	}
+
+	if err := secrets.VerifyAudit(entries, head, viper.GetString("audit_key")); err != nil {
+		return fmt.Errorf("audit log verification failed: %w", err)
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("audit log is empty")
+		return nil
+	}
+
+	fmt.Printf("verified %d entries (%d to %d)\n", len(entries), entries[0].Seq, entries[len(entries)-1].Seq)
+
+	return nil
+}
+
+func auditExport(cmd *cobra.Command, args []string) error {
+	store, nc, err := auditStore()
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	entries, err := store.Entries()
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if viper.GetString("audit_output") != "" {
+		f, err := os.Create(viper.GetString("audit_output"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	for _, v := range entries {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
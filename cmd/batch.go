@@ -0,0 +1,222 @@
+/*
+Copyright © 2024 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hooksie1/gophemeral/secrets"
+	"github.com/hooksie1/gophemeral/service"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/ksuid"
+	"github.com/spf13/viper"
+)
+
+// storeCSV creates a secret for every row of the CSV file and writes the rows back out
+// with the ID, password and share link of each secret.
+func storeCSV(nc *nats.Conn) error {
+	f, err := os.Open(viper.GetString("from_csv"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return err
+	}
+
+	if len(rows) < 2 {
+		return fmt.Errorf("%s has no rows", viper.GetString("from_csv"))
+	}
+
+	out := io.Writer(os.Stdout)
+	if viper.GetString("store_output") != "" {
+		file, err := os.OpenFile(viper.GetString("store_output"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	return storeRows(rows, out, func(requests []service.TextViews) ([]secrets.BatchResult, error) {
+		return storeBatch(nc, requests)
+	})
+}
+
+// storeRows creates the secrets for the rows after the header in batches of
+// secrets.MaxBatchSize and writes the rows to out. Columns that are missing or empty
+// use the flag values. The text column is left out of the output so it never holds
+// the plaintext. Rows are written as each batch is created so the secrets of earlier
+// batches aren't lost if a later one fails.
+func storeRows(rows [][]string, out io.Writer, store func([]service.TextViews) ([]secrets.BatchResult, error)) error {
+	header := rows[0]
+	columns := map[string]int{}
+	for i, v := range header {
+		columns[strings.ToLower(strings.TrimSpace(v))] = i
+	}
+
+	var requests []service.TextViews
+	for i, row := range rows[1:] {
+		req, err := csvRequest(columns, row)
+		if err != nil {
+			return fmt.Errorf("row %d: %w", i+2, err)
+		}
+		requests = append(requests, req)
+	}
+
+	text, hasText := columns["text"]
+	output := func(row []string) []string {
+		if !hasText || text >= len(row) {
+			return slices.Clone(row)
+		}
+		return slices.Delete(slices.Clone(row), text, text+1)
+	}
+
+	w := csv.NewWriter(out)
+	w.Write(append(output(header), "id", "password", "link", "error"))
+
+	failed := 0
+	for start := 0; start < len(requests); start += secrets.MaxBatchSize {
+		end := min(start+secrets.MaxBatchSize, len(requests))
+		results, err := store(requests[start:end])
+		if err != nil {
+			w.Flush()
+			return fmt.Errorf("rows %d to %d: %w", start+2, end+1, err)
+		}
+
+		for i, r := range results {
+			link := ""
+			if r.ID != "" {
+				link = fmt.Sprintf("%s/s/%s", strings.TrimSuffix(viper.GetString("base_url"), "/"), r.ID)
+			} else {
+				failed++
+			}
+			w.Write(append(output(rows[start+i+1]), r.ID, r.Password, link, r.Error))
+		}
+
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d secrets failed", failed, len(requests))
+	}
+
+	return nil
+}
+
+// csvRequest builds the request for a row from the flags and the row's columns
+func csvRequest(columns map[string]int, row []string) (service.TextViews, error) {
+	req, err := storeRequest()
+	if err != nil {
+		return req, err
+	}
+
+	value := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	if v := value("recipient"); v != "" {
+		req.Recipients = []string{v}
+	}
+
+	if v := value("text"); v != "" {
+		req.Text = v
+		req.Generate = nil
+	}
+
+	if v := value("views"); v != "" {
+		req.Views, err = strconv.Atoi(v)
+		if err != nil {
+			return req, fmt.Errorf("bad views %s", v)
+		}
+	}
+
+	if v := value("ttl"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			return req, fmt.Errorf("bad ttl %s", v)
+		}
+		req.TTL = int(ttl.Seconds())
+	}
+
+	if v := value("description"); v != "" {
+		req.Description = v
+	}
+
+	if req.Text == "" && req.Generate == nil && len(req.Fields) == 0 {
+		return req, fmt.Errorf("no text, add a text column or use --generate")
+	}
+
+	return req, nil
+}
+
+// storeBatch creates the secrets in one batch request
+func storeBatch(nc *nats.Conn, requests []service.TextViews) ([]secrets.BatchResult, error) {
+	data, err := json.Marshal(service.Batch{Secrets: requests})
+	if err != nil {
+		return nil, err
+	}
+
+	msg := newRequest(viper.GetString("batch_subject"))
+	msg.Header.Set(secrets.IdempotencyHeader, ksuid.New().String())
+	msg.Data = data
+
+	// every batch has its own idempotency key so a timed out batch can be retried
+	var resp *nats.Msg
+	for attempt := 0; ; attempt++ {
+		resp, err = nc.RequestMsg(msg, 30*time.Second)
+		if err == nil || !errors.Is(err, nats.ErrTimeout) || attempt >= viper.GetInt("store_retries") {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+
+	var results service.BatchResults
+	if err := json.Unmarshal(resp.Data, &results); err != nil {
+		return nil, err
+	}
+
+	if len(results.Results) != len(requests) {
+		return nil, fmt.Errorf("expected %d results but got %d", len(requests), len(results.Results))
+	}
+
+	return results.Results, nil
+}
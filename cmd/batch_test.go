@@ -0,0 +1,200 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hooksie1/gophemeral/secrets"
+	"github.com/hooksie1/gophemeral/service"
+	"github.com/spf13/viper"
+)
+
+// setFlags sets viper values for the test and resets them afterwards
+func setFlags(t *testing.T, values map[string]any) {
+	t.Cleanup(viper.Reset)
+	for k, v := range values {
+		viper.Set(k, v)
+	}
+}
+
+func TestCSVRequest(t *testing.T) {
+	columns := map[string]int{"email": 0, "recipient": 1, "text": 2, "views": 3, "ttl": 4, "description": 5}
+
+	tt := []struct {
+		name     string
+		flags    map[string]any
+		row      []string
+		expected service.TextViews
+		err      bool
+	}{
+		{
+			name:     "columns",
+			flags:    map[string]any{"views": 1},
+			row:      []string{"a@example.com", "alice", "hunter2", "3", "1h", "vpn"},
+			expected: service.TextViews{Text: "hunter2", Views: 3, TTL: 3600, Description: "vpn", Recipients: []string{"alice"}},
+		},
+		{
+			name:     "flag defaults",
+			flags:    map[string]any{"views": 2, "text": "default", "description": "flag"},
+			row:      []string{"a@example.com", "", "", "", "", ""},
+			expected: service.TextViews{Text: "default", Views: 2, Description: "flag"},
+		},
+		{
+			name:     "text overrides generate",
+			flags:    map[string]any{"views": 1, "generate": true},
+			row:      []string{"a@example.com", "", " hunter2 ", "", "", ""},
+			expected: service.TextViews{Text: "hunter2", Views: 1},
+		},
+		{
+			name:     "short row",
+			flags:    map[string]any{"views": 1, "generate": true, "generate_length": 12},
+			row:      []string{"a@example.com"},
+			expected: service.TextViews{Views: 1, Generate: &secrets.GeneratePolicy{Length: 12}},
+		},
+		{name: "bad views", row: []string{"", "", "hunter2", "many", "", ""}, err: true},
+		{name: "bad ttl", row: []string{"", "", "hunter2", "", "tomorrow", ""}, err: true},
+		{name: "no text", row: []string{"", "", "", "", "", ""}, err: true},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			setFlags(t, v.flags)
+
+			req, err := csvRequest(columns, v.row)
+			if v.err {
+				if err == nil {
+					t.Errorf("expected an error but got %+v", req)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, _ := json.Marshal(req)
+			expected, _ := json.Marshal(v.expected)
+			if string(got) != string(expected) {
+				t.Errorf("expected %s but got %s", expected, got)
+			}
+		})
+	}
+}
+
+// fakeBatches records the size of every batch and returns a result for each secret
+type fakeBatches struct {
+	sizes  []int
+	fail   map[int]bool
+	broken int
+}
+
+func (f *fakeBatches) store(requests []service.TextViews) ([]secrets.BatchResult, error) {
+	f.sizes = append(f.sizes, len(requests))
+	if len(f.sizes) == f.broken {
+		return nil, errors.New("timeout")
+	}
+
+	var results []secrets.BatchResult
+	for i, v := range requests {
+		if f.fail[len(results)] {
+			results = append(results, secrets.BatchResult{Index: i, Error: "bad secret"})
+			continue
+		}
+		results = append(results, secrets.BatchResult{Index: i, ID: "id-" + v.Recipients[0], Password: "pw-" + v.Recipients[0]})
+	}
+
+	return results, nil
+}
+
+// csvRows returns a header and n rows with a recipient and text column
+func csvRows(n int) [][]string {
+	rows := [][]string{{"recipient", "Text", "note"}}
+	for i := range n {
+		rows = append(rows, []string{fmt.Sprint(i), fmt.Sprintf("secret-%d", i), "n"})
+	}
+
+	return rows
+}
+
+func TestStoreRows(t *testing.T) {
+	setFlags(t, map[string]any{"views": 1, "base_url": "https://gophemeral.example.com/"})
+
+	batches := &fakeBatches{}
+	var out bytes.Buffer
+	if err := storeRows(csvRows(250), &out, batches.store); err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(batches.sizes) != fmt.Sprint([]int{secrets.MaxBatchSize, secrets.MaxBatchSize, 50}) {
+		t.Errorf("expected batches of %d but got %v", secrets.MaxBatchSize, batches.sizes)
+	}
+
+	if strings.Contains(out.String(), "secret-") {
+		t.Errorf("expected the text column to be left out of the output")
+	}
+
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 251 {
+		t.Fatalf("expected a header and 250 rows but got %d", len(rows))
+	}
+
+	if got := strings.Join(rows[0], ","); got != "recipient,note,id,password,link,error" {
+		t.Errorf("unexpected header %s", got)
+	}
+
+	if got := strings.Join(rows[150], ","); got != "149,n,id-149,pw-149,https://gophemeral.example.com/s/id-149," {
+		t.Errorf("unexpected row %s", got)
+	}
+}
+
+func TestStoreRowsErrors(t *testing.T) {
+	setFlags(t, map[string]any{"views": 1})
+
+	rows := csvRows(3)
+	rows[2][1] = ""
+	if err := storeRows(rows, &bytes.Buffer{}, (&fakeBatches{}).store); err == nil || !strings.Contains(err.Error(), "row 3") {
+		t.Errorf("expected a row without text to fail but got %v", err)
+	}
+
+	var out bytes.Buffer
+	failing := &fakeBatches{fail: map[int]bool{1: true}}
+	if err := storeRows(csvRows(3), &out, failing.store); err == nil || !strings.Contains(err.Error(), "1 of 3") {
+		t.Errorf("expected a failed secret to be reported but got %v", err)
+	}
+	if !strings.Contains(out.String(), "1,n,,,,bad secret") {
+		t.Errorf("expected the failed row to hold the error but got %s", out.String())
+	}
+
+	out.Reset()
+	broken := &fakeBatches{broken: 2}
+	if err := storeRows(csvRows(150), &out, broken.store); err == nil || !strings.Contains(err.Error(), "rows 102 to 151") {
+		t.Errorf("expected the second batch to fail but got %v", err)
+	}
+	if rows, _ := csv.NewReader(&out).ReadAll(); len(rows) != 101 {
+		t.Errorf("expected the first batch to be written before the failure but got %d rows", len(rows))
+	}
+}
@@ -17,6 +17,13 @@ limitations under the License.
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/hooksie1/gophemeral/secrets"
+	"github.com/hooksie1/gophemeral/tracing"
+	"github.com/nats-io/nats.go"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -32,8 +39,58 @@ func init() {
 	natsFlags(clientCmd)
 	clientCmd.PersistentFlags().Bool("json", false, "Print response in JSON")
 	viper.BindPFlag("json", clientCmd.PersistentFlags().Lookup("json"))
+	clientCmd.PersistentFlags().String("api-key", "", "API key sent with requests")
+	viper.BindPFlag("api_key", clientCmd.PersistentFlags().Lookup("api-key"))
 }
 
 func bindClientCmdFlags(cmd *cobra.Command, args []string) {
 	bindNatsFlags(cmd)
 }
+
+// newRequest returns a request to the service. A new trace is started for it unless
+// $TRACEPARENT is set, then the request joins that trace.
+func newRequest(subject string) *nats.Msg {
+	msg := nats.NewMsg(subject)
+	msg.Header.Set("User-Agent", userAgent())
+	if viper.GetString("api_key") != "" {
+		msg.Header.Set(secrets.AuthorizationHeader, "Bearer "+viper.GetString("api_key"))
+	}
+
+	sc, ok := tracing.ParseTraceParent(os.Getenv("TRACEPARENT"))
+	if !ok {
+		sc = tracing.NewRoot()
+	}
+	msg.Header.Set(tracing.TraceParentHeader, sc.TraceParent())
+
+	return msg
+}
+
+// friendlyErrors are the messages shown for error codes returned by the service
+var friendlyErrors = map[secrets.ErrorCode]string{
+	secrets.CodeNotFound:    "secret not found, it may have expired or already been viewed",
+	secrets.CodeBadPassword: "incorrect password or passphrase",
+	secrets.CodeRateLimited: "too many requests, try again later",
+	secrets.CodeUnavailable: "the service is unavailable, try again later",
+	secrets.CodeInternal:    "the service had an internal error",
+}
+
+// responseError returns an error if the service responded with one
+func responseError(resp *nats.Msg) error {
+	if resp.Header.Get("Nats-Service-Error-Code") == "" {
+		return nil
+	}
+
+	var env secrets.ErrorEnvelope
+	if err := json.Unmarshal(resp.Data, &env); err != nil || env.Error.Code == "" {
+		if desc := resp.Header.Get("Nats-Service-Error"); desc != "" {
+			return errors.New(desc)
+		}
+		return errors.New(string(resp.Data))
+	}
+
+	if msg, ok := friendlyErrors[env.Error.Code]; ok {
+		return errors.New(msg)
+	}
+
+	return errors.New(env.Error.Message)
+}
@@ -17,18 +17,43 @@ limitations under the License.
 package cmd
 
 import (
+	"os"
+	"path/filepath"
+
+	"github.com/hooksie1/gophemeral/rest"
 	"github.com/spf13/cobra"
 	"github.com/spf13/cobra/doc"
 )
 
 var docsCmd = &cobra.Command{
 	Use:   "docs",
-	Short: "Generate cli documentation",
-	RunE: func(cmd *cobra.Command, args []string) error {
-		return doc.GenMarkdownTree(rootCmd, "./docs")
-	},
+	Short: "Generate cli and API documentation",
+	RunE:  docs,
 }
 
 func init() {
 	rootCmd.AddCommand(docsCmd)
+	docsCmd.Flags().String("openapi", "./docs/openapi.json", "File the OpenAPI document of the REST API is written to")
+}
+
+func docs(cmd *cobra.Command, args []string) error {
+	if err := doc.GenMarkdownTree(rootCmd, "./docs"); err != nil {
+		return err
+	}
+
+	path, err := cmd.Flags().GetString("openapi")
+	if err != nil {
+		return err
+	}
+
+	data, err := rest.OpenAPI()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
 }
@@ -17,6 +17,10 @@ limitations under the License.
 package cmd
 
 import (
+	"time"
+
+	"github.com/hooksie1/gophemeral/rest"
+	"github.com/hooksie1/gophemeral/secrets"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -49,10 +53,150 @@ func natsFlags(cmd *cobra.Command) {
 func bindServiceFlags(cmd *cobra.Command) {
 	viper.BindPFlag("port", cmd.Flags().Lookup("port"))
 	viper.BindPFlag("max_characters", cmd.Flags().Lookup("max-characters"))
+	viper.BindPFlag("events", cmd.Flags().Lookup("events"))
+	viper.BindPFlag("events_prefix", cmd.Flags().Lookup("events-prefix"))
+	viper.BindPFlag("events_stream", cmd.Flags().Lookup("events-stream"))
+	viper.BindPFlag("sweep_interval", cmd.Flags().Lookup("sweep-interval"))
+	viper.BindPFlag("webhook_url", cmd.Flags().Lookup("webhook-url"))
+	viper.BindPFlag("webhook_signing_key", cmd.Flags().Lookup("webhook-signing-key"))
+	viper.BindPFlag("webhook_allow_creator", cmd.Flags().Lookup("webhook-allow-creator"))
+	viper.BindPFlag("webhook_allow_private", cmd.Flags().Lookup("webhook-allow-private"))
+	viper.BindPFlag("webhook_events", cmd.Flags().Lookup("webhook-events"))
+	viper.BindPFlag("webhook_retries", cmd.Flags().Lookup("webhook-retries"))
+	viper.BindPFlag("audit", cmd.Flags().Lookup("audit"))
+	viper.BindPFlag("syslog_address", cmd.Flags().Lookup("syslog-address"))
+	viper.BindPFlag("syslog_network", cmd.Flags().Lookup("syslog-network"))
+	viper.BindPFlag("syslog_format", cmd.Flags().Lookup("syslog-format"))
+	viper.BindPFlag("syslog_ca", cmd.Flags().Lookup("syslog-ca"))
+	viper.BindPFlag("id_scheme", cmd.Flags().Lookup("id-scheme"))
+	viper.BindPFlag("id_length", cmd.Flags().Lookup("id-length"))
+	viper.BindPFlag("idempotency_window", cmd.Flags().Lookup("idempotency-window"))
+	viper.BindPFlag("idempotency_bucket", cmd.Flags().Lookup("idempotency-bucket"))
+	viper.BindPFlag("policy_hook_subject", cmd.Flags().Lookup("policy-hook-subject"))
+	viper.BindPFlag("policy_hook_timeout", cmd.Flags().Lookup("policy-hook-timeout"))
+	viper.BindPFlag("policy_hook_fail_open", cmd.Flags().Lookup("policy-hook-fail-open"))
+	viper.BindPFlag("shutdown_timeout", cmd.Flags().Lookup("shutdown-timeout"))
+	viper.BindPFlag("metrics", cmd.Flags().Lookup("metrics"))
+	viper.BindPFlag("metrics_port", cmd.Flags().Lookup("metrics-port"))
+	viper.BindPFlag("metrics_host", cmd.Flags().Lookup("metrics-host"))
+	viper.BindPFlag("api_keys", cmd.Flags().Lookup("api-keys"))
+	viper.BindPFlag("anonymous", cmd.Flags().Lookup("anonymous"))
+	viper.BindPFlag("trusted_proxies", cmd.Flags().Lookup("trusted-proxies"))
+	viper.BindPFlag("csp", cmd.Flags().Lookup("csp"))
+	viper.BindPFlag("hsts_max_age", cmd.Flags().Lookup("hsts-max-age"))
+	viper.BindPFlag("cors_origins", cmd.Flags().Lookup("cors-origins"))
+	viper.BindPFlag("cors_credentials", cmd.Flags().Lookup("cors-credentials"))
+	viper.BindPFlag("cors_max_age", cmd.Flags().Lookup("cors-max-age"))
+	viper.BindPFlag("tls_cert", cmd.Flags().Lookup("tls-cert"))
+	viper.BindPFlag("tls_key", cmd.Flags().Lookup("tls-key"))
+	viper.BindPFlag("tls_client_ca", cmd.Flags().Lookup("tls-client-ca"))
+	viper.BindPFlag("tls_min_version", cmd.Flags().Lookup("tls-min-version"))
+	viper.BindPFlag("tls_ciphers", cmd.Flags().Lookup("tls-ciphers"))
+	viper.BindPFlag("jwt_issuer", cmd.Flags().Lookup("jwt-issuer"))
+	viper.BindPFlag("jwt_audience", cmd.Flags().Lookup("jwt-audience"))
+	viper.BindPFlag("jwt_creator_claim", cmd.Flags().Lookup("jwt-creator-claim"))
+	viper.BindPFlag("jwt_tenant_claim", cmd.Flags().Lookup("jwt-tenant-claim"))
+	viper.BindPFlag("jwks_url", cmd.Flags().Lookup("jwks-url"))
+	viper.BindPFlag("jwks_file", cmd.Flags().Lookup("jwks-file"))
+	viper.BindPFlag("jwks_cache", cmd.Flags().Lookup("jwks-cache"))
+	viper.BindPFlag("trace_exporter", cmd.Flags().Lookup("trace-exporter"))
+	viper.BindPFlag("trace_file", cmd.Flags().Lookup("trace-file"))
+	viper.BindPFlag("trace_endpoint", cmd.Flags().Lookup("trace-endpoint"))
+	viper.BindPFlag("trace_headers", cmd.Flags().Lookup("trace-headers"))
+	bindAuditFlags(cmd)
+	bindAPIKeyFlags(cmd)
 }
 
 // sererFlags adds the service flags to the passed in command
 func serviceFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().IntP("port", "p", 8080, "Server port")
 	cmd.PersistentFlags().IntP("max-characters", "m", 200, "Maximum characters for a secret")
+	cmd.PersistentFlags().Bool("events", false, "Publish secret lifecycle events to JetStream")
+	cmd.PersistentFlags().String("events-prefix", secrets.DefaultEventPrefix, "Subject prefix for lifecycle events")
+	cmd.PersistentFlags().String("events-stream", secrets.DefaultEventStream, "JetStream stream that stores lifecycle events")
+	cmd.PersistentFlags().Duration("sweep-interval", time.Minute, "How often expired secrets are deleted, 0 disables the sweeper")
+	cmd.PersistentFlags().String("webhook-url", "", "Webhook that receives lifecycle events for every secret")
+	cmd.PersistentFlags().String("webhook-signing-key", "", "Key used to sign webhook deliveries")
+	cmd.PersistentFlags().Bool("webhook-allow-creator", false, "Allow creators to register a webhook for their secret")
+	cmd.PersistentFlags().Bool("webhook-allow-private", false, "Allow creator webhooks to private, loopback and link local addresses")
+	cmd.PersistentFlags().StringSlice("webhook-events", nil, "Events sent to webhooks (default viewed,burned,expired)")
+	cmd.PersistentFlags().Int("webhook-retries", 5, "Number of times a failed webhook delivery is retried")
+	cmd.PersistentFlags().Bool("audit", false, "Write lifecycle events to the tamper-evident audit log")
+	cmd.PersistentFlags().String("syslog-address", "", "Syslog server that security events are exported to")
+	cmd.PersistentFlags().String("syslog-network", "udp", "Syslog transport: udp, tcp or tls")
+	cmd.PersistentFlags().String("syslog-format", secrets.SyslogFormatRFC5424, "Syslog message format: rfc5424 or cef")
+	cmd.PersistentFlags().String("syslog-ca", "", "CA certificate file to verify a TLS syslog server")
+	cmd.PersistentFlags().String("id-scheme", secrets.IDSchemeKSUID, "How IDs of new secrets are created: ksuid, random, base58 or words")
+	cmd.PersistentFlags().Int("id-length", 0, "Characters of base58 IDs or words of word IDs, at least 64 bits of entropy (default 11 characters or 7 words)")
+	cmd.PersistentFlags().Duration("idempotency-window", secrets.DefaultIdempotencyWindow, "How long an idempotency key returns the original response, 0 disables idempotency keys")
+	cmd.PersistentFlags().String("idempotency-bucket", secrets.DefaultIdempotencyBucket, "KV bucket that stores idempotency keys")
+	cmd.PersistentFlags().String("policy-hook-subject", "", "NATS subject of an external policy service that approves new secrets")
+	cmd.PersistentFlags().Duration("policy-hook-timeout", secrets.DefaultPolicyHookTimeout, "How long to wait for the policy service")
+	cmd.PersistentFlags().Bool("policy-hook-fail-open", false, "Allow secrets when the policy service doesn't answer instead of rejecting them")
+	cmd.PersistentFlags().Bool("metrics", true, "Serve Prometheus metrics at /metrics")
+	cmd.PersistentFlags().Int("metrics-port", 9090, "Port of the admin server for /metrics, 0 serves them publicly on the main port")
+	cmd.PersistentFlags().String("metrics-host", "127.0.0.1", "Address the admin server listens on")
+	cmd.PersistentFlags().Bool("api-keys", false, "Accept API keys, they are required when anonymous access is off")
+	cmd.PersistentFlags().Bool("anonymous", true, "Allow requests without an API key")
+	cmd.PersistentFlags().StringSlice("trusted-proxies", nil, "IPs or CIDR ranges of proxies whose X-Forwarded-* and Fly-Client-IP headers are trusted")
+	cmd.PersistentFlags().String("csp", rest.DefaultContentSecurityPolicy, "Content-Security-Policy header, empty to omit it")
+	cmd.PersistentFlags().Duration("hsts-max-age", rest.DefaultHSTSMaxAge, "max-age of the Strict-Transport-Security header on HTTPS requests, 0 to omit it")
+	cmd.PersistentFlags().StringSlice("cors-origins", nil, "Origins allowed to call /api from a browser, * allows every origin")
+	cmd.PersistentFlags().Bool("cors-credentials", false, "Allow cross-origin requests to /api with cookies and credentials")
+	cmd.PersistentFlags().Duration("cors-max-age", 10*time.Minute, "How long browsers cache CORS preflight responses")
+	cmd.PersistentFlags().String("tls-cert", "", "Certificate file to serve HTTPS with, reloaded when it changes")
+	cmd.PersistentFlags().String("tls-key", "", "Private key file of the TLS certificate")
+	cmd.PersistentFlags().String("tls-client-ca", "", "CA file that client certificates must be signed by, enables mutual TLS")
+	cmd.PersistentFlags().String("tls-min-version", "1.2", "Minimum TLS version: 1.2 or 1.3")
+	cmd.PersistentFlags().StringSlice("tls-ciphers", nil, "TLS 1.2 cipher suites to allow, for example TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (default Go's secure suites)")
+	cmd.PersistentFlags().String("jwt-issuer", "", "Accept JWTs from this issuer as bearer tokens to record who created secrets")
+	cmd.PersistentFlags().String("jwt-audience", "", "Audience JWTs must be issued for")
+	cmd.PersistentFlags().String("jwt-creator-claim", secrets.DefaultCreatorClaim, "JWT claim recorded as the creator of secrets, sub is used if it's missing")
+	cmd.PersistentFlags().String("jwt-tenant-claim", "", "JWT claim used as the tenant")
+	cmd.PersistentFlags().String("jwks-url", "", "URL of the JSON Web Key Set that signs JWTs")
+	cmd.PersistentFlags().String("jwks-file", "", "File with the JSON Web Key Set that signs JWTs")
+	cmd.PersistentFlags().Duration("jwks-cache", secrets.DefaultJWKSCacheTTL, "How long keys from the JWKS URL are cached")
+	cmd.PersistentFlags().String("trace-exporter", "none", "Where spans are exported: none, stdout, file or otlp")
+	cmd.PersistentFlags().String("trace-file", "traces.jsonl", "File spans are written to with the file exporter")
+	cmd.PersistentFlags().String("trace-endpoint", "", "OTLP/HTTP collector endpoint, for example http://localhost:4318")
+	cmd.PersistentFlags().StringToString("trace-headers", nil, "Headers sent to the OTLP collector")
+	cmd.PersistentFlags().Duration("shutdown-timeout", 30*time.Second, "How long to wait for in flight requests and events on shutdown")
+	auditFlags(cmd)
+	apiKeyFlags(cmd)
+}
+
+// bindAuditFlags binds the audit log flag values to viper
+func bindAuditFlags(cmd *cobra.Command) {
+	viper.BindPFlag("audit_stream", cmd.Flags().Lookup("audit-stream"))
+	viper.BindPFlag("audit_subject", cmd.Flags().Lookup("audit-subject"))
+	viper.BindPFlag("audit_key", cmd.Flags().Lookup("audit-key"))
+}
+
+// auditFlags adds the audit log flags to the passed in command
+func auditFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("audit-stream", secrets.DefaultAuditStream, "JetStream stream that stores the audit log")
+	cmd.PersistentFlags().String("audit-subject", secrets.DefaultAuditSubject, "Subject audit entries are stored on")
+	cmd.PersistentFlags().String("audit-key", "", "Key used to HMAC audit entries, without it the chain only detects accidental corruption")
+}
+
+// bindAdminFlags binds the admin flag values to viper
+func bindAdminFlags(cmd *cobra.Command) {
+	bindAuditFlags(cmd)
+	bindAPIKeyFlags(cmd)
+}
+
+// adminFlags adds the admin flags to the passed in command
+func adminFlags(cmd *cobra.Command) {
+	auditFlags(cmd)
+	apiKeyFlags(cmd)
+}
+
+// bindAPIKeyFlags binds the API key flag values to viper
+func bindAPIKeyFlags(cmd *cobra.Command) {
+	viper.BindPFlag("apikey_bucket", cmd.Flags().Lookup("apikey-bucket"))
+}
+
+// apiKeyFlags adds the API key flags shared by the service and admin commands
+func apiKeyFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("apikey-bucket", secrets.DefaultAPIKeyBucket, "KV bucket that stores API keys")
 }
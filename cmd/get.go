@@ -19,10 +19,11 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/hooksie1/gophemeral/secrets"
 	"github.com/hooksie1/gophemeral/service"
-	"github.com/nats-io/nats.go"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -41,8 +42,18 @@ func init() {
 	viper.BindPFlag("id", getCmd.Flags().Lookup("id"))
 	getCmd.Flags().String("password", "", "The password for the secret")
 	viper.BindPFlag("password", getCmd.Flags().Lookup("password"))
+	getCmd.Flags().String("passphrase", "", "The passphrase set by the creator of the secret")
+	viper.BindPFlag("get_passphrase", getCmd.Flags().Lookup("passphrase"))
 	getCmd.Flags().String("get-subject", "gophemeral.secrets.get", "The subject to get a secret")
 	viper.BindPFlag("get_subject", getCmd.Flags().Lookup("get-subject"))
+	getCmd.Flags().String("info-subject", "gophemeral.secrets.info", "The subject to get the metadata of a secret")
+	viper.BindPFlag("info_subject", getCmd.Flags().Lookup("info-subject"))
+	getCmd.Flags().Bool("info", false, "Only print the description, views and expiration without using a view")
+	viper.BindPFlag("info", getCmd.Flags().Lookup("info"))
+	getCmd.Flags().String("field", "", "Only print the value of this field of a structured secret")
+	viper.BindPFlag("field", getCmd.Flags().Lookup("field"))
+	getCmd.Flags().Bool("env", false, "Print the secret as environment variables")
+	viper.BindPFlag("env", getCmd.Flags().Lookup("env"))
 }
 
 func get(cmd *cobra.Command, args []string) error {
@@ -57,8 +68,9 @@ func get(cmd *cobra.Command, args []string) error {
 		data = []byte(args[0])
 	} else {
 		req := service.IDPassword{
-			ID:       viper.GetString("id"),
-			Password: viper.GetString("password"),
+			ID:         viper.GetString("id"),
+			Password:   viper.GetString("password"),
+			Passphrase: viper.GetString("get_passphrase"),
 		}
 
 		data, err = json.Marshal(req)
@@ -68,30 +80,125 @@ func get(cmd *cobra.Command, args []string) error {
 
 	}
 
-	msg := nats.NewMsg(viper.GetString("get_subject"))
+	subject := viper.GetString("get_subject")
+	if viper.GetBool("info") {
+		subject = viper.GetString("info_subject")
+	}
+
+	msg := newRequest(subject)
 	msg.Data = data
 	resp, err := nc.RequestMsg(msg, 1*time.Second)
 	if err != nil {
 		return err
 	}
 
-	if resp.Header.Get("Nats-Service-Error-Code") != "" {
-		return fmt.Errorf(string(resp.Data))
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	if viper.GetBool("info") {
+		return printInfo(resp.Data)
 	}
 
 	if err := json.Unmarshal(resp.Data, &tv); err != nil {
 		return err
 	}
 
+	secret := secrets.Secret{Text: tv.Text, Fields: tv.Fields}
+
+	if viper.GetString("field") != "" {
+		value, ok := secret.Field(viper.GetString("field"))
+		if !ok {
+			return fmt.Errorf("field %s not found", viper.GetString("field"))
+		}
+		fmt.Println(value)
+		return nil
+	}
+
+	if viper.GetBool("env") {
+		printEnv(secret)
+		return nil
+	}
+
 	if viper.GetBool("json") {
-		fmt.Println(string(data))
+		fmt.Println(string(resp.Data))
 		return nil
 	}
 
-	fmt.Printf("Text: %s\nViews: %d\n", tv.Text, tv.Views)
+	if tv.Text != "" {
+		fmt.Printf("Text: %s\n", tv.Text)
+	}
+	for _, v := range tv.Fields {
+		fmt.Printf("%s: %s\n", v.Key, v.Value)
+	}
+	fmt.Printf("Views: %d\n", tv.Views)
 	if tv.Views == 0 && !viper.GetBool("json") {
 		fmt.Println("This is the last time you can view this message")
 	}
 
 	return nil
 }
+
+// printInfo prints the metadata of a secret
+func printInfo(data []byte) error {
+	if viper.GetBool("json") {
+		fmt.Println(string(data))
+		return nil
+	}
+
+	var m secrets.Metadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+
+	if m.Description != "" {
+		fmt.Printf("Description: %s\n", m.Description)
+	}
+	fmt.Printf("Views: %d\n", m.Views)
+	fmt.Printf("Passphrase: %t\n", m.Passphrase)
+	if m.Creator != "" {
+		fmt.Printf("Creator: %s\n", m.Creator)
+	}
+	if len(m.Classifications) > 0 {
+		classes := make([]string, len(m.Classifications))
+		for i, v := range m.Classifications {
+			classes[i] = string(v)
+		}
+		fmt.Printf("Classifications: %s\n", strings.Join(classes, ", "))
+	}
+	fmt.Printf("Created: %s\n", m.Created.Local().Format(time.RFC1123))
+	fmt.Printf("Expires: %s\n", m.Expires.Local().Format(time.RFC1123))
+
+	return nil
+}
+
+// printEnv prints the secret as shell environment variables. Field keys are upper cased
+// and any character that isn't valid in a variable name is replaced with an underscore.
+func printEnv(s secrets.Secret) {
+	if s.Text != "" {
+		fmt.Printf("TEXT=%s\n", shellQuote(s.Text))
+	}
+
+	for _, v := range s.Fields {
+		fmt.Printf("%s=%s\n", envName(v.Key), shellQuote(v.Value))
+	}
+}
+
+func envName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(key))
+
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+
+	return name
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -18,13 +18,28 @@ package cmd
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
 
-	cwnats "github.com/CoverWhale/coverwhale-go/transports/nats"
 	"github.com/CoverWhale/logr"
+	"github.com/hooksie1/gophemeral/metrics"
 	"github.com/hooksie1/gophemeral/rest"
 	"github.com/hooksie1/gophemeral/secrets"
 	"github.com/hooksie1/gophemeral/service"
+	"github.com/hooksie1/gophemeral/tracing"
 	"github.com/invopop/jsonschema"
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/micro"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -42,15 +57,31 @@ func init() {
 	serviceCmd.AddCommand(startCmd)
 }
 
+// flusher is a notifier that delivers events in the background
+type flusher interface {
+	Flush(context.Context) error
+}
+
 func start(cmd *cobra.Command, args []string) error {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// every logger writes through the standard logger so this covers all log output
+	log.SetOutput(secrets.NewRedactingWriter(os.Stderr))
 	logger := logr.NewLogger()
 
 	config := micro.Config{
-		Name:        "gophemeral",
-		Version:     "0.0.1",
-		Description: "Secrets sharing for everyone",
+		Name:         "gophemeral",
+		Version:      "0.0.1",
+		Description:  "Secrets sharing for everyone",
+		StatsHandler: service.StatsHandler,
+	}
+
+	tracer, err := newTracer(logger)
+	if err != nil {
+		return err
 	}
+	tracing.SetTracer(tracer)
 
 	nc, err := newNatsConnection("gophemeral-server")
 	if err != nil {
@@ -58,20 +89,117 @@ func start(cmd *cobra.Command, args []string) error {
 	}
 	defer nc.Close()
 
-	backend, err := secrets.NewNatsBackend(nc, secrets.DefaultValidator(viper.GetInt("max_characters")))
+	var opts []secrets.BackendOption
+	var flushers []flusher
+	if viper.GetBool("events") {
+		publisher, err := eventPublisher(nc, logger)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, secrets.WithNotifier(publisher))
+		flushers = append(flushers, publisher)
+	}
+
+	if viper.GetBool("audit") {
+		auditLog, err := auditLog(nc, logger)
+		if err != nil {
+			return err
+		}
+		defer auditLog.Close()
+		opts = append(opts, secrets.WithNotifier(auditLog))
+		flushers = append(flushers, auditLog)
+	}
+
+	if viper.GetString("syslog_address") != "" {
+		exporter, err := syslogExporter(logger)
+		if err != nil {
+			return err
+		}
+		defer exporter.Close()
+		opts = append(opts, secrets.WithNotifier(exporter))
+		flushers = append(flushers, exporter)
+	}
+
+	if viper.GetString("webhook_url") != "" || viper.GetBool("webhook_allow_creator") {
+		webhooks := webhookNotifier(logger)
+		defer webhooks.Close()
+		opts = append(opts, secrets.WithNotifier(webhooks))
+		flushers = append(flushers, webhooks)
+	}
+
+	ids, err := secrets.NewIDGenerator(viper.GetString("id_scheme"), viper.GetInt("id_length"))
 	if err != nil {
 		return err
 	}
+	opts = append(opts, secrets.WithIDGenerator(ids))
+
+	if viper.GetDuration("idempotency_window") > 0 {
+		store, err := idempotencyStore(nc)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, secrets.WithIdempotency(store))
+	}
+
+	policies, err := policyEngine()
+	if err != nil {
+		return err
+	}
+
+	validators := secrets.Validators{policies}
+	if viper.GetString("policy_hook_subject") != "" {
+		validators = append(validators, secrets.NewPolicyHook(nc, secrets.PolicyHookConfig{
+			Subject:  viper.GetString("policy_hook_subject"),
+			Timeout:  viper.GetDuration("policy_hook_timeout"),
+			FailOpen: viper.GetBool("policy_hook_fail_open"),
+			Limits:   policies,
+		}, logger))
+	}
+
+	backend, err := secrets.NewNatsBackend(nc, validators, opts...)
+	if err != nil {
+		return err
+	}
+
+	sweeperDone := make(chan struct{})
+	go func() {
+		defer close(sweeperDone)
+		secrets.RunSweeper(ctx, backend, viper.GetDuration("sweep_interval"), logger)
+	}()
+
+	var auth *secrets.Authenticator
+	if viper.GetBool("api_keys") || !viper.GetBool("anonymous") || viper.GetString("jwt_issuer") != "" {
+		var store secrets.APIKeyStore
+		if viper.GetBool("api_keys") || !viper.GetBool("anonymous") {
+			store, err = apiKeyStore(nc)
+			if err != nil {
+				return err
+			}
+		}
+
+		var authOpts []secrets.AuthenticatorOption
+		if viper.GetString("jwt_issuer") != "" {
+			validator, err := jwtValidator()
+			if err != nil {
+				return err
+			}
+			authOpts = append(authOpts, secrets.WithJWTValidator(validator))
+		}
+
+		auth = secrets.NewAuthenticator(store, viper.GetBool("anonymous"), backend, authOpts...)
+	}
 
 	svc, err := micro.AddService(nc, config)
 	if err != nil {
-		logr.Fatal(err)
+		return err
 	}
 
+	var inflight service.Inflight
+
 	// add a handler group
 	grp := svc.AddGroup("gophemeral.secrets")
 	grp.AddEndpoint("store",
-		service.SecretHandler(backend, logger, service.StoreSecret),
+		inflight.Track(service.SecretHandler(backend, logger, service.Authorize(auth, secrets.ScopeCreate, service.StoreSecret))),
 		micro.WithEndpointMetadata(map[string]string{
 			"description":     "stores a secret",
 			"format":          "application/json",
@@ -80,8 +208,18 @@ func start(cmd *cobra.Command, args []string) error {
 		}),
 		micro.WithEndpointSubject("store"),
 	)
+	grp.AddEndpoint("store_batch",
+		inflight.Track(service.SecretHandler(backend, logger, service.Authorize(auth, secrets.ScopeCreate, service.StoreBatch))),
+		micro.WithEndpointMetadata(map[string]string{
+			"description":     "stores a batch of secrets",
+			"format":          "application/json",
+			"request_schema":  schemaString(&service.Batch{}),
+			"response_schema": schemaString(&service.BatchResults{}),
+		}),
+		micro.WithEndpointSubject("store.batch"),
+	)
 	grp.AddEndpoint("get",
-		service.SecretHandler(backend, logger, service.GetSecret),
+		inflight.Track(service.SecretHandler(backend, logger, service.Authorize(auth, secrets.ScopeLookup, service.GetSecret))),
 		micro.WithEndpointMetadata(map[string]string{
 			"description":     "gets a secret",
 			"format":          "application/json",
@@ -90,18 +228,366 @@ func start(cmd *cobra.Command, args []string) error {
 		}),
 		micro.WithEndpointSubject("get"),
 	)
+	grp.AddEndpoint("info",
+		inflight.Track(service.SecretHandler(backend, logger, service.Authorize(auth, secrets.ScopeStatus, service.InfoSecret))),
+		micro.WithEndpointMetadata(map[string]string{
+			"description":     "gets the metadata of a secret without using a view",
+			"format":          "application/json",
+			"request_schema":  schemaString(&service.IDPassword{}),
+			"response_schema": schemaString(&secrets.Metadata{}),
+		}),
+		micro.WithEndpointSubject("info"),
+	)
 
 	logger.Infof("service %s %s started", svc.Info().Name, svc.Info().ID)
-	go cwnats.HandleNotify(svc)
 
-	errChan := make(chan error)
+	errChan := make(chan error, 2)
 
-	s := rest.NewServer(backend, logger, viper.GetInt("port"))
+	var serverOpts []rest.ServerOption
+	if auth != nil {
+		serverOpts = append(serverOpts, rest.WithAuthenticator(auth))
+	}
 
-	logger.Infof("starting HTTP server on port %d", viper.GetInt("port"))
+	proxies, err := rest.ParseTrustedProxies(viper.GetStringSlice("trusted_proxies"))
+	if err != nil {
+		return err
+	}
+	serverOpts = append(serverOpts, rest.WithTrustedProxies(proxies))
+
+	serverOpts = append(serverOpts, rest.WithSecurityHeaders(rest.SecurityHeaders{
+		ContentSecurityPolicy: viper.GetString("csp"),
+		HSTSMaxAge:            viper.GetDuration("hsts_max_age"),
+	}))
+
+	if origins := viper.GetStringSlice("cors_origins"); len(origins) > 0 {
+		if viper.GetBool("cors_credentials") && slices.Contains(origins, "*") {
+			return fmt.Errorf("--cors-origins can't be * with --cors-credentials")
+		}
+		serverOpts = append(serverOpts, rest.WithCORS(rest.CORS{
+			AllowedOrigins:   origins,
+			AllowCredentials: viper.GetBool("cors_credentials"),
+			MaxAge:           viper.GetDuration("cors_max_age"),
+		}))
+	}
+
+	var certs *rest.CertReloader
+	if viper.GetString("tls_cert") != "" || viper.GetString("tls_key") != "" {
+		certs, err = rest.NewCertReloader(rest.TLSOptions{
+			CertFile:     viper.GetString("tls_cert"),
+			KeyFile:      viper.GetString("tls_key"),
+			ClientCAFile: viper.GetString("tls_client_ca"),
+			MinVersion:   viper.GetString("tls_min_version"),
+			CipherSuites: viper.GetStringSlice("tls_ciphers"),
+		}, logger)
+		if err != nil {
+			return err
+		}
+		serverOpts = append(serverOpts, rest.WithTLS(certs.Config()))
+
+		go func() {
+			if err := certs.Watch(ctx); err != nil {
+				logger.Errorf("error watching tls files, certificates will not be reloaded: %v", err)
+			}
+		}()
+	}
+
+	s := rest.NewServer(backend, logger, viper.GetInt("port"), serverOpts...)
+
+	var admin *http.Server
+	if viper.GetBool("metrics") {
+		if viper.GetInt("metrics_port") == 0 {
+			s.HandleMetrics()
+		} else {
+			admin = adminServer(viper.GetString("metrics_host"), viper.GetInt("metrics_port"))
+			// the admin server uses the same certificate and client CA as the main port
+			if certs != nil {
+				admin.TLSConfig = certs.Config()
+			}
+			logger.Infof("starting admin server on %s", admin.Addr)
+			go func() {
+				var err error
+				if admin.TLSConfig != nil {
+					err = admin.ListenAndServeTLS("", "")
+				} else {
+					err = admin.ListenAndServe()
+				}
+				if err != nil && !errors.Is(err, http.ErrServerClosed) {
+					errChan <- err
+				}
+			}()
+		}
+	}
+
+	if certs != nil {
+		logger.Infof("starting HTTPS server on port %d", viper.GetInt("port"))
+	} else {
+		logger.Infof("starting HTTP server on port %d", viper.GetInt("port"))
+	}
 	go s.Serve(errChan)
-	s.AutoHandleErrors(ctx, errChan)
-	return nil
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+		logger.Info("received shutdown signal")
+	case serveErr = <-errChan:
+		logger.Errorf("error starting server: %v", serveErr)
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("shutdown_timeout"))
+	defer cancel()
+
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		logger.Errorf("error shutting down HTTP server: %v", err)
+	}
+
+	if admin != nil {
+		if err := admin.Shutdown(shutdownCtx); err != nil {
+			logger.Errorf("error shutting down admin server: %v", err)
+		}
+	}
+
+	if err := svc.Stop(); err != nil {
+		logger.Errorf("error stopping service: %v", err)
+	}
+
+	if err := inflight.Wait(shutdownCtx); err != nil {
+		logger.Errorf("error waiting for service requests: %v", err)
+	}
+
+	select {
+	case <-sweeperDone:
+	case <-shutdownCtx.Done():
+		logger.Error("sweeper did not stop before the shutdown timeout")
+	}
+
+	for _, v := range flushers {
+		if err := v.Flush(shutdownCtx); err != nil {
+			logger.Errorf("error flushing events: %v", err)
+		}
+	}
+
+	if err := drain(shutdownCtx, nc); err != nil {
+		logger.Errorf("error draining NATS connection: %v", err)
+	}
+
+	if tracer != nil {
+		if err := tracer.Flush(shutdownCtx); err != nil {
+			logger.Errorf("error flushing spans: %v", err)
+		}
+	}
+
+	logger.Info("shutdown complete")
+	return serveErr
+}
+
+// adminServer returns the server for the metrics endpoint when it isn't served on the main port
+func adminServer(host string, port int) *http.Server {
+	router := http.NewServeMux()
+	router.Handle("/metrics", metrics.Handler())
+
+	return &http.Server{
+		Addr:         net.JoinHostPort(host, strconv.Itoa(port)),
+		Handler:      router,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+}
+
+// newTracer returns the tracer for the configured exporter or nil if tracing is off
+func newTracer(logger *logr.Logger) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+	switch viper.GetString("trace_exporter") {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		exporter = tracing.NewWriterExporter(secrets.NewRedactingWriter(os.Stdout))
+	case "file":
+		f, err := os.OpenFile(viper.GetString("trace_file"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		exporter = tracing.NewWriterExporter(secrets.NewRedactingWriter(f))
+	case "otlp":
+		if viper.GetString("trace_endpoint") == "" {
+			return nil, fmt.Errorf("--trace-endpoint is required for the otlp exporter")
+		}
+		exporter = tracing.NewOTLPExporter(viper.GetString("trace_endpoint"), "gophemeral", viper.GetStringMapString("trace_headers"))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %s", viper.GetString("trace_exporter"))
+	}
+
+	return tracing.NewTracer(exporter, func(err error) {
+		logger.Errorf("error exporting spans: %v", err)
+	}), nil
+}
+
+// drain drains the NATS connection and waits for it to close
+func drain(ctx context.Context, nc *nats.Conn) error {
+	closed := make(chan struct{})
+	nc.SetClosedHandler(func(*nats.Conn) {
+		close(closed)
+	})
+
+	if err := nc.Drain(); err != nil {
+		return err
+	}
+
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// eventPublisher creates the lifecycle event stream and returns a publisher for it
+func eventPublisher(nc *nats.Conn, logger *logr.Logger) (*secrets.EventPublisher, error) {
+	js, err := nc.JetStream(nats.PublishAsyncErrHandler(func(_ nats.JetStream, msg *nats.Msg, err error) {
+		logger.Errorf("error publishing event to %s: %v", msg.Subject, err)
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := secrets.CreateEventStream(js, viper.GetString("events_stream"), viper.GetString("events_prefix")); err != nil {
+		return nil, err
+	}
+
+	return secrets.NewEventPublisher(js, viper.GetString("events_prefix"), logger), nil
+}
+
+// auditLog returns the audit log notifier backed by a JetStream stream
+func auditLog(nc *nats.Conn, logger *logr.Logger) (*secrets.AuditLog, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := secrets.NewNATSAuditStore(js, viper.GetString("audit_stream"), viper.GetString("audit_subject"))
+	if err != nil {
+		return nil, err
+	}
+
+	if viper.GetString("audit_key") == "" {
+		logger.Info("no audit key is set, the audit log only detects accidental corruption")
+	}
+
+	return secrets.NewAuditLog(store, secrets.AuditConfig{Key: viper.GetString("audit_key")}, logger), nil
+}
+
+// idempotencyStore returns the idempotency store backed by a KV bucket
+func idempotencyStore(nc *nats.Conn) (*secrets.NATSIdempotencyStore, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	return secrets.NewNATSIdempotencyStore(js, viper.GetString("idempotency_bucket"), viper.GetDuration("idempotency_window"))
+}
+
+// apiKeyStore returns the API key store backed by a KV bucket
+func apiKeyStore(nc *nats.Conn) (*secrets.NATSAPIKeyStore, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	return secrets.NewNATSAPIKeyStore(js, viper.GetString("apikey_bucket"))
+}
+
+// jwtValidator returns the JWT validator configured from the service flags
+func jwtValidator() (*secrets.JWTValidator, error) {
+	var keys *secrets.KeySet
+	switch {
+	case viper.GetString("jwks_file") != "":
+		var err error
+		keys, err = secrets.NewKeySetFile(viper.GetString("jwks_file"))
+		if err != nil {
+			return nil, err
+		}
+	case viper.GetString("jwks_url") != "":
+		keys = secrets.NewKeySetURL(viper.GetString("jwks_url"), viper.GetDuration("jwks_cache"))
+	default:
+		return nil, fmt.Errorf("--jwks-url or --jwks-file is required with --jwt-issuer")
+	}
+
+	return secrets.NewJWTValidator(secrets.JWTConfig{
+		Issuer:       viper.GetString("jwt_issuer"),
+		Audience:     viper.GetString("jwt_audience"),
+		CreatorClaim: viper.GetString("jwt_creator_claim"),
+		TenantClaim:  viper.GetString("jwt_tenant_claim"),
+	}, keys)
+}
+
+// syslogExporter returns the syslog exporter configured from the service flags
+func syslogExporter(logger *logr.Logger) (*secrets.SyslogExporter, error) {
+	config := secrets.SyslogConfig{
+		Network: viper.GetString("syslog_network"),
+		Address: viper.GetString("syslog_address"),
+		Format:  viper.GetString("syslog_format"),
+		Version: Version,
+	}
+
+	if viper.GetString("syslog_ca") != "" {
+		pem, err := os.ReadFile(viper.GetString("syslog_ca"))
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", viper.GetString("syslog_ca"))
+		}
+
+		config.TLSConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return secrets.NewSyslogExporter(config, logger)
+}
+
+// webhookNotifier returns a webhook notifier configured from the service flags
+func webhookNotifier(logger *logr.Logger) *secrets.WebhookNotifier {
+	var events []secrets.EventType
+	for _, v := range viper.GetStringSlice("webhook_events") {
+		events = append(events, secrets.EventType(v))
+	}
+
+	return secrets.NewWebhookNotifier(secrets.WebhookConfig{
+		URL:          viper.GetString("webhook_url"),
+		Key:          viper.GetString("webhook_signing_key"),
+		AllowCreator: viper.GetBool("webhook_allow_creator"),
+		AllowPrivate: viper.GetBool("webhook_allow_private"),
+		Events:       events,
+		MaxRetries:   viper.GetInt("webhook_retries"),
+	}, logger)
+}
+
+// policyEngine loads the policy profiles from the config. Profiles without a size limit,
+// including the default profile if it isn't configured, limit secrets to --max-characters.
+func policyEngine() (*secrets.PolicyEngine, error) {
+	var config secrets.PolicyConfig
+	if err := viper.UnmarshalKey("policies", &config); err != nil {
+		return nil, fmt.Errorf("error loading policies: %w", err)
+	}
+
+	if config.Default == "" {
+		config.Default = secrets.DefaultPolicy
+	}
+
+	if config.MaxRunes == 0 {
+		config.MaxRunes = viper.GetInt("max_characters")
+	}
+
+	if config.Profiles == nil {
+		config.Profiles = map[string]secrets.Policy{}
+	}
+
+	if _, ok := config.Profiles[config.Default]; !ok {
+		config.Profiles[config.Default] = secrets.Policy{}
+	}
+
+	return secrets.NewPolicyEngine(config)
 }
 
 func schemaString(s any) string {
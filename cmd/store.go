@@ -18,11 +18,15 @@ package cmd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/hooksie1/gophemeral/secrets"
 	"github.com/hooksie1/gophemeral/service"
 	"github.com/nats-io/nats.go"
+	"github.com/segmentio/ksuid"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -41,8 +45,46 @@ func init() {
 	viper.BindPFlag("text", storeCmd.Flags().Lookup("text"))
 	storeCmd.Flags().Int("views", 1, "The number of views for this secret")
 	viper.BindPFlag("views", storeCmd.Flags().Lookup("views"))
+	storeCmd.Flags().String("description", "", "A description shown to the recipient before the secret is revealed, it is not encrypted")
+	viper.BindPFlag("description", storeCmd.Flags().Lookup("description"))
+	storeCmd.Flags().String("passphrase", "", "A passphrase the recipient needs along with the password, share it separately")
+	viper.BindPFlag("store_passphrase", storeCmd.Flags().Lookup("passphrase"))
+	storeCmd.Flags().StringArray("recipient", nil, "Who the secret is for, can be repeated")
+	viper.BindPFlag("recipients", storeCmd.Flags().Lookup("recipient"))
+	storeCmd.Flags().Duration("ttl", secrets.DefaultTTL, "How long the secret is kept before it expires")
+	viper.BindPFlag("ttl", storeCmd.Flags().Lookup("ttl"))
+	storeCmd.Flags().String("webhook", "", "URL that is sent a signed POST when the secret is viewed, consumed or expires")
+	viper.BindPFlag("webhook", storeCmd.Flags().Lookup("webhook"))
+	storeCmd.Flags().String("webhook-secret", "", "Key used to sign webhook deliveries for this secret")
+	viper.BindPFlag("webhook_secret", storeCmd.Flags().Lookup("webhook-secret"))
+	storeCmd.Flags().Bool("canary", false, "Create a canary secret that alerts when it is accessed")
+	viper.BindPFlag("canary", storeCmd.Flags().Lookup("canary"))
+	storeCmd.Flags().String("decoy", "", "Decoy value served by a canary secret (default the text or a generated value)")
+	viper.BindPFlag("decoy", storeCmd.Flags().Lookup("decoy"))
+	storeCmd.Flags().String("idempotency-key", "", "Key that makes retries return the same secret (default a random key per run)")
+	viper.BindPFlag("idempotency_key", storeCmd.Flags().Lookup("idempotency-key"))
+	storeCmd.Flags().Int("retries", 2, "Number of times a timed out request is retried")
+	viper.BindPFlag("store_retries", storeCmd.Flags().Lookup("retries"))
+	storeCmd.Flags().String("from-csv", "", "Create a secret for each row of a CSV file with a header of recipient, text, views, ttl and description columns")
+	viper.BindPFlag("from_csv", storeCmd.Flags().Lookup("from-csv"))
+	storeCmd.Flags().StringP("output", "o", "", "File the CSV of share links is written to (default stdout)")
+	viper.BindPFlag("store_output", storeCmd.Flags().Lookup("output"))
+	storeCmd.Flags().String("base-url", "https://gophemeral.com", "URL of the web app used for share links")
+	viper.BindPFlag("base_url", storeCmd.Flags().Lookup("base-url"))
+	storeCmd.Flags().String("batch-subject", "gophemeral.secrets.store.batch", "The subject to store a batch of secrets")
+	viper.BindPFlag("batch_subject", storeCmd.Flags().Lookup("batch-subject"))
 	storeCmd.Flags().String("store-subject", "gophemeral.secrets.store", "The subject to store a secret")
 	viper.BindPFlag("store_subject", storeCmd.Flags().Lookup("store-subject"))
+	storeCmd.Flags().StringArray("field", nil, "A key=value field of a structured secret, can be repeated")
+	viper.BindPFlag("fields", storeCmd.Flags().Lookup("field"))
+	storeCmd.Flags().Bool("generate", false, "Generate a random value on the server instead of sending text")
+	viper.BindPFlag("generate", storeCmd.Flags().Lookup("generate"))
+	storeCmd.Flags().Int("length", secrets.DefaultGenerateLength, "Length of the generated value")
+	viper.BindPFlag("generate_length", storeCmd.Flags().Lookup("length"))
+	storeCmd.Flags().Int("words", 0, "Generate a passphrase with this many words instead of characters")
+	viper.BindPFlag("generate_words", storeCmd.Flags().Lookup("words"))
+	storeCmd.Flags().StringSlice("charset", nil, "Character classes for the generated value: lower, upper, digits, symbols (default all)")
+	viper.BindPFlag("generate_charset", storeCmd.Flags().Lookup("charset"))
 
 }
 
@@ -54,12 +96,16 @@ func store(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if viper.GetString("from_csv") != "" {
+		return storeCSV(nc)
+	}
+
 	if len(args) != 0 {
 		data = []byte(args[0])
 	} else {
-		req := service.TextViews{
-			Text:  viper.GetString("text"),
-			Views: viper.GetInt("views"),
+		req, err := storeRequest()
+		if err != nil {
+			return err
 		}
 
 		data, err = json.Marshal(req)
@@ -69,15 +115,30 @@ func store(cmd *cobra.Command, args []string) error {
 
 	}
 
-	msg := nats.NewMsg(viper.GetString("store_subject"))
+	key := viper.GetString("idempotency_key")
+	if key == "" {
+		key = ksuid.New().String()
+	}
+
+	msg := newRequest(viper.GetString("store_subject"))
+	msg.Header.Set(secrets.IdempotencyHeader, key)
 	msg.Data = data
-	resp, err := nc.RequestMsg(msg, 1*time.Second)
+
+	// the idempotency key makes it safe to retry, a retry returns the secret created
+	// by a request that timed out
+	var resp *nats.Msg
+	for attempt := 0; ; attempt++ {
+		resp, err = nc.RequestMsg(msg, 1*time.Second)
+		if err == nil || !errors.Is(err, nats.ErrTimeout) || attempt >= viper.GetInt("store_retries") {
+			break
+		}
+	}
 	if err != nil {
 		return err
 	}
 
-	if resp.Header.Get("Nats-Service-Error-Code") != "" {
-		return fmt.Errorf(string(resp.Data))
+	if err := responseError(resp); err != nil {
+		return err
 	}
 
 	if err := json.Unmarshal(resp.Data, &idp); err != nil {
@@ -94,3 +155,78 @@ func store(cmd *cobra.Command, args []string) error {
 	return nil
 
 }
+
+// parseFields turns key=value pairs into secret fields while keeping their order.
+func parseFields(pairs []string) ([]secrets.Field, error) {
+	var fields []secrets.Field
+	for _, v := range pairs {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return nil, fmt.Errorf("field %s must be in the form key=value", v)
+		}
+		fields = append(fields, secrets.Field{Key: key, Value: value})
+	}
+
+	return fields, nil
+}
+
+// storeRequest builds the request to store a secret from the flags
+func storeRequest() (service.TextViews, error) {
+	fields, err := parseFields(viper.GetStringSlice("fields"))
+	if err != nil {
+		return service.TextViews{}, err
+	}
+
+	req := service.TextViews{
+		Text:   viper.GetString("text"),
+		Views:  viper.GetInt("views"),
+		Fields: fields,
+		TTL:    int(viper.GetDuration("ttl").Seconds()),
+
+		Description: viper.GetString("description"),
+		Passphrase:  viper.GetString("store_passphrase"),
+		Recipients:  viper.GetStringSlice("recipients"),
+
+		Webhook:       viper.GetString("webhook"),
+		WebhookSecret: viper.GetString("webhook_secret"),
+
+		Canary: viper.GetBool("canary"),
+		Decoy:  viper.GetString("decoy"),
+	}
+
+	if viper.GetBool("generate") {
+		policy, err := generatePolicy()
+		if err != nil {
+			return service.TextViews{}, err
+		}
+		req.Text = ""
+		req.Generate = &policy
+	}
+
+	return req, nil
+}
+
+// generatePolicy builds the generate policy from the flags
+func generatePolicy() (secrets.GeneratePolicy, error) {
+	policy := secrets.GeneratePolicy{
+		Length: viper.GetInt("generate_length"),
+		Words:  viper.GetInt("generate_words"),
+	}
+
+	for _, v := range viper.GetStringSlice("generate_charset") {
+		switch v {
+		case "lower":
+			policy.Lower = true
+		case "upper":
+			policy.Upper = true
+		case "digits":
+			policy.Digits = true
+		case "symbols":
+			policy.Symbols = true
+		default:
+			return policy, fmt.Errorf("unknown character class %s", v)
+		}
+	}
+
+	return policy, nil
+}
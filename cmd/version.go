@@ -32,6 +32,11 @@ var versionCmd = &cobra.Command{
 	},
 }
 
+// userAgent identifies the client in requests to the service
+func userAgent() string {
+	return fmt.Sprintf("gophemeralctl/%s", Version)
+}
+
 func init() {
 	rootCmd.AddCommand(versionCmd)
 }
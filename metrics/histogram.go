@@ -0,0 +1,127 @@
+/*
+Copyright © 2024 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds in seconds used for latency histograms.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations into buckets split by label values.
+type Histogram struct {
+	Name    string
+	Help    string
+	Labels  []string
+	Buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*HistogramSample
+}
+
+// HistogramSample holds the observations for one set of label values. Counts are
+// cumulative and match Buckets by index.
+type HistogramSample struct {
+	LabelValues []string
+	Counts      []uint64
+	Count       uint64
+	Sum         float64
+}
+
+var histograms []*Histogram
+
+// NewHistogram creates a histogram and registers it. DefaultBuckets are used if no
+// buckets are passed.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	h := &Histogram{
+		Name:    name,
+		Help:    help,
+		Labels:  labels,
+		Buckets: append([]float64(nil), buckets...),
+		values:  map[string]*HistogramSample{},
+	}
+	sort.Float64s(h.Buckets)
+
+	mu.Lock()
+	defer mu.Unlock()
+	histograms = append(histograms, h)
+
+	return h
+}
+
+// Observe adds the value to the histogram for the label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	sample, ok := h.values[key]
+	if !ok {
+		sample = &HistogramSample{
+			LabelValues: append([]string(nil), labelValues...),
+			Counts:      make([]uint64, len(h.Buckets)),
+		}
+		h.values[key] = sample
+	}
+
+	for i, b := range h.Buckets {
+		if v <= b {
+			sample.Counts[i]++
+		}
+	}
+	sample.Count++
+	sample.Sum += v
+}
+
+// Samples returns a copy of every sample of the histogram sorted by label values.
+func (h *Histogram) Samples() []HistogramSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	samples := make([]HistogramSample, len(keys))
+	for i, k := range keys {
+		v := h.values[k]
+		samples[i] = HistogramSample{
+			LabelValues: v.LabelValues,
+			Counts:      append([]uint64(nil), v.Counts...),
+			Count:       v.Count,
+			Sum:         v.Sum,
+		}
+	}
+
+	return samples
+}
+
+// Histograms returns all registered histograms.
+func Histograms() []*Histogram {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]*Histogram(nil), histograms...)
+}
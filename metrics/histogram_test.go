@@ -0,0 +1,63 @@
+/*
+Copyright © 2024 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	h := NewHistogram("test_bucket_seconds", "Test buckets", []float64{1, 0.1, 0.5}, "op")
+
+	for _, v := range []float64{0.05, 0.1, 0.3, 0.5, 1, 2} {
+		h.Observe(v, "read")
+	}
+	h.Observe(0.2, "write")
+
+	samples := h.Samples()
+	if len(samples) != 2 {
+		t.Fatalf("expected 2 samples but got %d", len(samples))
+	}
+
+	read := samples[0]
+	if read.LabelValues[0] != "read" {
+		t.Fatalf("expected samples sorted by label but got %v", read.LabelValues)
+	}
+
+	// buckets are sorted and a value on a boundary counts in that bucket
+	expected := []uint64{2, 4, 5}
+	for i, v := range expected {
+		if read.Counts[i] != v {
+			t.Errorf("expected %d in bucket le=%v but got %d", v, h.Buckets[i], read.Counts[i])
+		}
+	}
+
+	if read.Count != 6 || read.Sum != 3.95 {
+		t.Errorf("expected count 6 and sum 3.95 but got %d and %v", read.Count, read.Sum)
+	}
+
+	if samples[1].Counts[0] != 0 || samples[1].Counts[1] != 1 {
+		t.Errorf("unexpected write counts %v", samples[1].Counts)
+	}
+}
+
+func TestHistogramDefaultBuckets(t *testing.T) {
+	h := NewHistogram("test_default_seconds", "Test default buckets", nil)
+	if len(h.Buckets) != len(DefaultBuckets) {
+		t.Errorf("expected default buckets but got %v", h.Buckets)
+	}
+}
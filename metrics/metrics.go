@@ -0,0 +1,110 @@
+/*
+Copyright © 2024 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics holds the counters the service keeps about itself.
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Counter is a monotonically increasing value split by label values.
+type Counter struct {
+	Name   string
+	Help   string
+	Labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+var (
+	mu       sync.Mutex
+	counters []*Counter
+)
+
+// NewCounter creates a counter and registers it.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		Name:   name,
+		Help:   help,
+		Labels: labels,
+		values: map[string]float64{},
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	counters = append(counters, c)
+
+	return c
+}
+
+// Inc increments the counter for the label values by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter for the label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[strings.Join(labelValues, "\xff")] += v
+}
+
+// Value returns the current value for the label values.
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(labelValues, "\xff")]
+}
+
+// Sample is the value of a counter for one set of label values.
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// Samples returns every value of the counter sorted by label values.
+func (c *Counter) Samples() []Sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	samples := make([]Sample, len(keys))
+	for i, k := range keys {
+		var labelValues []string
+		if len(c.Labels) > 0 {
+			labelValues = strings.Split(k, "\xff")
+		}
+		samples[i] = Sample{LabelValues: labelValues, Value: c.values[k]}
+	}
+
+	return samples
+}
+
+// Counters returns all registered counters.
+func Counters() []*Counter {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]*Counter(nil), counters...)
+}
@@ -0,0 +1,102 @@
+/*
+Copyright © 2024 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the content type of the Prometheus text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// WriteText writes every registered metric in the Prometheus text format.
+func WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	for _, c := range Counters() {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n", c.Name, escapeHelp(c.Help), c.Name)
+		for _, s := range c.Samples() {
+			fmt.Fprintf(bw, "%s%s %s\n", c.Name, labels(c.Labels, s.LabelValues), formatFloat(s.Value))
+		}
+	}
+
+	for _, h := range Histograms() {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s histogram\n", h.Name, escapeHelp(h.Help), h.Name)
+		for _, s := range h.Samples() {
+			names := append(append([]string(nil), h.Labels...), "le")
+			for i, b := range h.Buckets {
+				values := append(append([]string(nil), s.LabelValues...), formatFloat(b))
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", h.Name, labels(names, values), s.Counts[i])
+			}
+			values := append(append([]string(nil), s.LabelValues...), "+Inf")
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", h.Name, labels(names, values), s.Count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", h.Name, labels(h.Labels, s.LabelValues), formatFloat(s.Sum))
+			fmt.Fprintf(bw, "%s_count%s %d\n", h.Name, labels(h.Labels, s.LabelValues), s.Count)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Handler serves the registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		if err := WriteText(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+func labels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, v := range names {
+		var value string
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = fmt.Sprintf(`%s="%s"`, v, escapeLabel(value))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
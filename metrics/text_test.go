@@ -0,0 +1,63 @@
+/*
+Copyright © 2024 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	c := NewCounter("test_text_total", "Help with \\ and\nnewline", "path")
+	c.Inc(`/a"b`)
+	c.Add(2.5, "/c")
+
+	h := NewHistogram("test_text_seconds", "Test latency", []float64{0.1, 1}, "op")
+	h.Observe(0.5, "read")
+
+	var buf bytes.Buffer
+	if err := WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []string{
+		"# HELP test_text_total Help with \\\\ and\\nnewline\n# TYPE test_text_total counter\n",
+		`test_text_total{path="/a\"b"} 1` + "\n",
+		`test_text_total{path="/c"} 2.5` + "\n",
+		"# HELP test_text_seconds Test latency\n# TYPE test_text_seconds histogram\n",
+		`test_text_seconds_bucket{op="read",le="0.1"} 0` + "\n",
+		`test_text_seconds_bucket{op="read",le="1"} 1` + "\n",
+		`test_text_seconds_bucket{op="read",le="+Inf"} 1` + "\n",
+		`test_text_seconds_sum{op="read"} 0.5` + "\n",
+		`test_text_seconds_count{op="read"} 1` + "\n",
+	} {
+		if !strings.Contains(buf.String(), v) {
+			t.Errorf("expected output to contain %q but got\n%s", v, buf.String())
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if rec.Header().Get("Content-Type") != ContentType {
+		t.Errorf("expected %s but got %s", ContentType, rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CoverWhale/logr"
+	"github.com/hooksie1/gophemeral/secrets"
+	"github.com/hooksie1/gophemeral/secrets/secretstest"
+)
+
+// newAuthServer returns a server that requires API keys and a key for each of the keys
+func newAuthServer(t *testing.T, keys ...secrets.APIKey) (Server, []string) {
+	t.Helper()
+
+	store := secretstest.NewAPIKeyStore()
+	var tokens []string
+	for _, v := range keys {
+		token, err := store.Issue(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	b := secretstest.NewBackend()
+	auth := secrets.NewAuthenticator(store, false, b)
+
+	return NewServer(b, logr.NewLogger(), 0, WithAuthenticator(auth)), tokens
+}
+
+func TestAuthorize(t *testing.T) {
+	s, tokens := newAuthServer(t,
+		secrets.APIKey{Name: "creator", Scopes: []string{secrets.ScopeCreate}},
+		secrets.APIKey{Name: "reader", Scopes: []string{secrets.ScopeLookup}},
+	)
+	body := `{"text":"hunter2","views":2}`
+
+	tt := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		code   int
+	}{
+		{name: "no key", method: "POST", path: "/api/v1/secrets", body: body, code: http.StatusUnauthorized},
+		{name: "bad key", method: "POST", path: "/api/v1/secrets", token: "gph_abc_def", body: body, code: http.StatusUnauthorized},
+		{name: "missing scope", method: "POST", path: "/api/v1/secrets", token: tokens[1], body: body, code: http.StatusForbidden},
+		{name: "scope", method: "POST", path: "/api/v1/secrets", token: tokens[0], body: body, code: http.StatusCreated},
+		{name: "legacy no key", method: "POST", path: "/api/secret", body: body, code: http.StatusUnauthorized},
+		{name: "health", method: "GET", path: "/api/health", code: http.StatusOK},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			rec := serve(s, v.method, v.path, v.token, v.body)
+			if rec.Code != v.code {
+				t.Errorf("expected %d but got %d: %s", v.code, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestBrowserRoutesWithoutKey(t *testing.T) {
+	s, tokens := newAuthServer(t, secrets.APIKey{Name: "creator", Scopes: []string{secrets.ScopeCreate}})
+
+	rec := serve(s, "POST", "/api/v1/secrets", tokens[0], `{"text":"hunter2","views":2}`)
+	var created CreateSecretResponse
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("error creating secret: %v", err)
+	}
+
+	if rec := serve(s, "GET", "/s/"+created.ID, "", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Views remaining") {
+		t.Errorf("expected the recipient page without a key but got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest("POST", "/hx/lookupSecret", strings.NewReader("id="+created.ID+"&password="+created.Password))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	lookup := httptest.NewRecorder()
+	s.Router.Handler.ServeHTTP(lookup, req)
+	if lookup.Code != http.StatusOK || !strings.Contains(lookup.Body.String(), "hunter2") {
+		t.Errorf("expected the recipient to reveal the secret without a key but got %d", lookup.Code)
+	}
+
+	create := serve(s, "POST", "/hx/createSecret", "", `{"text":"hunter2","views":1}`)
+	if !strings.Contains(create.Body.String(), "an api key is required") || strings.Contains(create.Header().Get("Content-Type"), "json") {
+		t.Errorf("expected the web app to show that a key is required but got %s", create.Body.String())
+	}
+}
+
+func TestRateLimitAndQuota(t *testing.T) {
+	s, tokens := newAuthServer(t,
+		secrets.APIKey{Name: "limited", Scopes: []string{secrets.ScopeCreate}, RateLimit: 1},
+		secrets.APIKey{Name: "quota", Scopes: []string{secrets.ScopeCreate}, DailyQuota: 2},
+	)
+	body := `{"text":"hunter2","views":1}`
+
+	if rec := serve(s, "POST", "/api/v1/secrets", tokens[0], body); rec.Code != http.StatusCreated {
+		t.Fatalf("expected first request to be allowed but got %d", rec.Code)
+	}
+	if rec := serve(s, "POST", "/api/v1/secrets", tokens[0], body); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected rate limited request to get 429 but got %d", rec.Code)
+	}
+
+	for i := range 2 {
+		if rec := serve(s, "POST", "/api/v1/secrets", tokens[1], body); rec.Code != http.StatusCreated {
+			t.Fatalf("expected request %d within the quota to be allowed but got %d", i+1, rec.Code)
+		}
+	}
+	if rec := serve(s, "POST", "/api/v1/secrets", tokens[1], body); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected request over the quota to get 429 but got %d", rec.Code)
+	}
+}
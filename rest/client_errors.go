@@ -17,22 +17,23 @@ limitations under the License.
 package rest
 
 import (
-	"fmt"
 	"log"
 	"net/http"
+
+	"github.com/hooksie1/gophemeral/secrets"
 )
 
 type AppHandlerFunc func(http.ResponseWriter, *http.Request) error
 
-func getErrorDetails(err error) (int, string) {
-	clientError, ok := err.(ClientError)
+// getErrorDetails returns the client facing error for err. Errors that aren't
+// secrets.RecordErrors are logged and hidden behind a generic internal error.
+func getErrorDetails(err error) secrets.RecordError {
+	rerr, ok := secrets.ErrorFrom(err)
 	if !ok {
 		log.Printf("An error ocurred: %v", err)
-		return 500, http.StatusText(http.StatusInternalServerError)
 	}
 
-	return clientError.Code(), clientError.Body()
-
+	return rerr
 }
 
 func errHandlers(h AppHandlerFunc) http.HandlerFunc {
@@ -42,43 +43,18 @@ func errHandlers(h AppHandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		status, body := getErrorDetails(err)
-
-		apiErrDetails := fmt.Sprintf(`{"error": "%s"}`, body)
-
-		w.WriteHeader(status)
-		w.Write([]byte(apiErrDetails))
+		writeError(w, getErrorDetails(err))
 	}
 }
 
-type ClientError interface {
-	Error() string
-	Code() int
-	Body() string
-}
-
-type HTTPError struct {
-	Details string `json:"details"`
-	Status  int    `json:"-"`
-}
-
-func (e *HTTPError) Error() string {
-	return e.Details
-}
-
-func (e *HTTPError) Body() string {
-	return fmt.Sprintf(`{"error": "%s"}`, e.Details)
+// writeError writes the JSON error envelope
+func writeError(w http.ResponseWriter, err secrets.RecordError) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(err.Code())
+	w.Write(err.JSON())
 }
 
-func (e *HTTPError) Headers() (int, map[string]string) {
-	return e.Status, map[string]string{
-		"Content-Type": "application/json; charset=utf-8",
-	}
-}
-
-func NewHTTPError(status int, details string) error {
-	return &HTTPError{
-		Details: details,
-		Status:  status,
-	}
+// badRequest wraps a decoding error so it is returned as a 400
+func badRequest(err error) error {
+	return secrets.NewSecretError(http.StatusBadRequest, "invalid request body: "+err.Error())
 }
@@ -0,0 +1,164 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hooksie1/gophemeral/secrets"
+)
+
+// DefaultContentSecurityPolicy allows the web UI's scripts and styles from /static and
+// its fonts from Google. No inline scripts or eval are allowed.
+const DefaultContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self'; " +
+	"style-src 'self' https://fonts.googleapis.com; " +
+	"font-src 'self' https://fonts.gstatic.com; " +
+	"img-src 'self' data: https://ik.imagekit.io; " +
+	"connect-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// DefaultHSTSMaxAge is how long browsers only use HTTPS after seeing the
+// Strict-Transport-Security header.
+const DefaultHSTSMaxAge = 365 * 24 * time.Hour
+
+// SecurityHeaders are the headers added to every response.
+type SecurityHeaders struct {
+	// ContentSecurityPolicy is omitted if empty.
+	ContentSecurityPolicy string
+	// HSTSMaxAge is sent in Strict-Transport-Security on HTTPS requests, the header is
+	// omitted if it's 0.
+	HSTSMaxAge time.Duration
+}
+
+// DefaultSecurityHeaders returns the headers used unless WithSecurityHeaders is passed.
+func DefaultSecurityHeaders() SecurityHeaders {
+	return SecurityHeaders{
+		ContentSecurityPolicy: DefaultContentSecurityPolicy,
+		HSTSMaxAge:            DefaultHSTSMaxAge,
+	}
+}
+
+// WithSecurityHeaders replaces the default security headers.
+func WithSecurityHeaders(h SecurityHeaders) ServerOption {
+	return func(s *Server) {
+		s.headers = h
+	}
+}
+
+// secureHeaders adds the security headers. Nothing except the static assets may be
+// cached since responses hold secrets and links, and no Referer is sent so link IDs
+// don't leak to other sites.
+func secureHeaders(h SecurityHeaders, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("Referrer-Policy", "no-referrer")
+		header.Set("Cross-Origin-Opener-Policy", "same-origin")
+		if h.ContentSecurityPolicy != "" {
+			header.Set("Content-Security-Policy", h.ContentSecurityPolicy)
+		}
+
+		if !strings.HasPrefix(r.URL.Path, "/static/") {
+			header.Set("Cache-Control", "no-store")
+			header.Set("Pragma", "no-cache")
+		}
+
+		if h.HSTSMaxAge > 0 && requestHTTPS(r) {
+			header.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(h.HSTSMaxAge.Seconds())))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CORS configures cross-origin access to /api. An origin of * allows every origin, but
+// can't be used with credentials.
+type CORS struct {
+	AllowedOrigins   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// corsHeaders are the request headers clients of the API send
+var corsHeaders = strings.Join([]string{
+	"Content-Type",
+	secrets.AuthorizationHeader,
+	secrets.IdempotencyHeader,
+	secrets.RequestIDHeader,
+	"X-Password",
+	"X-Passphrase",
+	"Traceparent",
+}, ", ")
+
+// corsExposed are the response headers cross-origin clients can read
+var corsExposed = strings.Join([]string{secrets.RequestIDHeader, secrets.ErrorCodeHeader}, ", ")
+
+// WithCORS allows cross-origin requests to /api from the origins. Without it browsers
+// only allow the API to be called from the same origin.
+func WithCORS(c CORS) ServerOption {
+	return func(s *Server) {
+		s.cors = &c
+	}
+}
+
+func (c *CORS) allowed(origin string) bool {
+	return slices.Contains(c.AllowedOrigins, origin) || (!c.AllowCredentials && slices.Contains(c.AllowedOrigins, "*"))
+}
+
+// corsHandler answers preflight requests for /api before routing, so they don't need
+// an OPTIONS route, and adds the CORS headers to responses for allowed origins.
+func corsHandler(c *CORS, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if c == nil || origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		if !c.allowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header.Set("Access-Control-Allow-Origin", origin)
+		if c.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			header.Set("Access-Control-Allow-Methods", "GET, HEAD, POST")
+			header.Set("Access-Control-Allow-Headers", corsHeaders)
+			if c.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		header.Set("Access-Control-Expose-Headers", corsExposed)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,225 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CoverWhale/logr"
+	"github.com/hooksie1/gophemeral/secrets"
+	"github.com/hooksie1/gophemeral/secrets/secretstest"
+)
+
+var trustedProxy = []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32")}
+
+func TestSecureHeaders(t *testing.T) {
+	s := NewServer(secretstest.NewBackend(), logr.NewLogger(), 0, WithTrustedProxies(trustedProxy))
+
+	tt := []struct {
+		name    string
+		path    string
+		remote  string
+		tls     bool
+		proto   string
+		hsts    bool
+		noStore bool
+	}{
+		{name: "http", path: "/api/health", noStore: true},
+		{name: "tls", path: "/api/health", tls: true, hsts: true, noStore: true},
+		{name: "trusted proxy https", path: "/api/health", proto: "https", hsts: true, noStore: true},
+		{name: "untrusted proxy https", path: "/api/health", remote: "198.51.100.1:1234", proto: "https", noStore: true},
+		{name: "not found", path: "/nope", noStore: true},
+		{name: "static", path: "/static/min.css"},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", v.path, nil)
+			if v.remote != "" {
+				req.RemoteAddr = v.remote
+			}
+			if v.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if v.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", v.proto)
+			}
+
+			rec := httptest.NewRecorder()
+			s.Router.Handler.ServeHTTP(rec, req)
+			header := rec.Header()
+
+			if header.Get("X-Content-Type-Options") != "nosniff" || header.Get("X-Frame-Options") != "DENY" || header.Get("Referrer-Policy") != "no-referrer" {
+				t.Errorf("expected security headers but got %v", header)
+			}
+
+			if header.Get("Content-Security-Policy") != DefaultContentSecurityPolicy {
+				t.Errorf("expected the default policy but got %q", header.Get("Content-Security-Policy"))
+			}
+
+			if hsts := header.Get("Strict-Transport-Security") != ""; hsts != v.hsts {
+				t.Errorf("expected HSTS %t but got %q", v.hsts, header.Get("Strict-Transport-Security"))
+			}
+
+			if noStore := header.Get("Cache-Control") == "no-store"; noStore != v.noStore {
+				t.Errorf("expected no-store %t but got %q", v.noStore, header.Get("Cache-Control"))
+			}
+		})
+	}
+}
+
+func TestContentSecurityPolicyAssets(t *testing.T) {
+	if strings.Contains(DefaultContentSecurityPolicy, "unsafe") || strings.Contains(DefaultContentSecurityPolicy, "unpkg") {
+		t.Errorf("expected the policy to only allow local scripts: %s", DefaultContentSecurityPolicy)
+	}
+
+	s := NewServer(secretstest.NewBackend(), logr.NewLogger(), 0)
+	for _, v := range []string{"/static/htmx.min.js", "/static/json-enc.js", "/static/app.js"} {
+		rec := serve(s, "GET", v, "", "")
+		if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/javascript") {
+			t.Errorf("expected %s to be served as javascript but got %d %q", v, rec.Code, rec.Header().Get("Content-Type"))
+		}
+	}
+
+	for _, v := range []string{"/", "/s/abc"} {
+		page := serve(s, "GET", v, "", "").Body.String()
+		if strings.Contains(page, "unpkg.com") || strings.Contains(page, "<script>") || strings.Contains(page, ` _="`) {
+			t.Errorf("expected %s to only load scripts from /static", v)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	origin := "https://app.example.com"
+	allowed := NewServer(secretstest.NewBackend(), logr.NewLogger(), 0, WithCORS(CORS{AllowedOrigins: []string{origin}, MaxAge: time.Minute}))
+	none := NewServer(secretstest.NewBackend(), logr.NewLogger(), 0)
+
+	tt := []struct {
+		name      string
+		server    Server
+		method    string
+		path      string
+		origin    string
+		preflight bool
+		code      int
+		allow     string
+	}{
+		{name: "preflight", server: allowed, method: "OPTIONS", path: "/api/v1/secrets", origin: origin, preflight: true, code: http.StatusNoContent, allow: origin},
+		{name: "preflight other origin", server: allowed, method: "OPTIONS", path: "/api/v1/secrets", origin: "https://evil.example.com", preflight: true},
+		{name: "request", server: allowed, method: "GET", path: "/api/health", origin: origin, code: http.StatusOK, allow: origin},
+		{name: "request other origin", server: allowed, method: "GET", path: "/api/health", origin: "https://evil.example.com", code: http.StatusOK},
+		{name: "outside api", server: allowed, method: "GET", path: "/", origin: origin, code: http.StatusOK},
+		{name: "not configured", server: none, method: "GET", path: "/api/health", origin: origin, code: http.StatusOK},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			req := httptest.NewRequest(v.method, v.path, nil)
+			req.Header.Set("Origin", v.origin)
+			if v.preflight {
+				req.Header.Set("Access-Control-Request-Method", "POST")
+			}
+
+			rec := httptest.NewRecorder()
+			v.server.Router.Handler.ServeHTTP(rec, req)
+
+			if v.code != 0 && rec.Code != v.code {
+				t.Errorf("expected %d but got %d", v.code, rec.Code)
+			}
+
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != v.allow {
+				t.Errorf("expected allowed origin %q but got %q", v.allow, got)
+			}
+
+			if v.allow != "" && v.preflight && rec.Header().Get("Access-Control-Max-Age") != "60" {
+				t.Errorf("expected max age 60 but got %q", rec.Header().Get("Access-Control-Max-Age"))
+			}
+
+			if v.allow != "" && !v.preflight && !strings.Contains(rec.Header().Get("Access-Control-Expose-Headers"), secrets.RequestIDHeader) {
+				t.Errorf("expected exposed headers but got %q", rec.Header().Get("Access-Control-Expose-Headers"))
+			}
+		})
+	}
+}
+
+func TestCORSAllowed(t *testing.T) {
+	wildcard := CORS{AllowedOrigins: []string{"*"}}
+	if !wildcard.allowed("https://app.example.com") {
+		t.Errorf("expected * to allow every origin")
+	}
+
+	credentials := CORS{AllowedOrigins: []string{"*"}, AllowCredentials: true}
+	if credentials.allowed("https://app.example.com") {
+		t.Errorf("expected * not to allow origins with credentials")
+	}
+}
+
+func TestShareLink(t *testing.T) {
+	tt := []struct {
+		name    string
+		remote  string
+		tls     bool
+		headers map[string]string
+		link    string
+	}{
+		{name: "host", link: "http://gophemeral.example.com/s/abc"},
+		{name: "tls", tls: true, link: "https://gophemeral.example.com/s/abc"},
+		{name: "same origin", headers: map[string]string{"Origin": "https://gophemeral.example.com"}, link: "https://gophemeral.example.com/s/abc"},
+		{name: "foreign origin", headers: map[string]string{"Origin": "https://evil.example.com"}, link: "http://gophemeral.example.com/s/abc"},
+		{
+			name:    "trusted proxy",
+			headers: map[string]string{"X-Forwarded-Host": "secrets.example.com", "X-Forwarded-Proto": "https"},
+			link:    "https://secrets.example.com/s/abc",
+		},
+		{
+			name:    "untrusted proxy",
+			remote:  "198.51.100.1:1234",
+			headers: map[string]string{"X-Forwarded-Host": "evil.example.com", "X-Forwarded-Proto": "https"},
+			link:    "http://gophemeral.example.com/s/abc",
+		},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://gophemeral.example.com/api/v1/secrets", nil)
+			if v.remote != "" {
+				req.RemoteAddr = v.remote
+			}
+			if v.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			for k, h := range v.headers {
+				req.Header.Set(k, h)
+			}
+
+			var link string
+			proxyHeaders(trustedProxy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				link = shareLink(r, "abc")
+			})).ServeHTTP(httptest.NewRecorder(), req)
+
+			if link != v.link {
+				t.Errorf("expected %s but got %s", v.link, link)
+			}
+		})
+	}
+}
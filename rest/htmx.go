@@ -19,9 +19,7 @@ package rest
 import (
 	"embed"
 	"encoding/json"
-	"fmt"
 	"html/template"
-	"io"
 	"net/http"
 
 	"github.com/hooksie1/gophemeral/secrets"
@@ -31,13 +29,24 @@ import (
 var staticFS embed.FS
 
 var lookupTemplate = `
-<div id="modal" _="on closeModal add .closing then wait for animationend then remove me">
+<div id="modal">
 	<div class="modal-underlay">
 		<div class="modal-content text-[#41454c] bg-[#fcfcfc] dark:text-[#ffffff] dark:bg-[#031022]">
         <h3 class="text-3xl text-[#41454c] dark:text-[#ffffff] max-w-none">Secret Information</h3>
-            {{ if .Text  }}
+            {{ if or .Text .Fields }}
 			    <div class="text-left items-left">
-			    	<div><b>Secret Text</b>: {{ .Text }}</div>
+			    	{{ if .Text }}<div><b>Secret Text</b>: {{ .Text }}</div>{{ end }}
+			    	{{ range $i, $f := .Fields }}
+			    	<div><b>{{ $f.Key }}</b>: <span id="secretField{{ $i }}">{{ $f.Value }}</span>
+			    		<button class="px-4 text-[#41454c] dark:text-[#ffffff]"
+			    			data-copy="#secretField{{ $i }}" data-copy-message="Field Copied!">
+			    			<svg xmlns="http://www.w3.org/2000/svg" fill="none" viewBox="0 0 24 24" stroke-width="1.5" width="20" stroke="currentColor">
+			    				<path stroke-linecap="round" stroke-linejoin="round" d="M8.25 7.5V6.108c0-1.135.845-2.098 1.976-2.192.373-.03.748-.057 1.123-.08M15.75 18H18a2.25 2.25 0 0 0 2.25-2.25V6.108c0-1.135-.845-2.098-1.976-2.192a48.424 48.424 0 0 0-1.123-.08M15.75 18.75v-1.875a3.375 3.375 0 0 0-3.375-3.375h-1.5a1.125 1.125 0 0 1-1.125-1.125v-1.5A3.375 3.375 0 0 0 6.375 7.5H5.25m11.9-3.664A2.251 2.251 0 0 0 15 2.25h-1.5a2.251 2.251 0 0 0-2.15 1.586m5.8 0c.065.21.1.433.1.664v.75h-6V4.5c0-.231.035-.454.1-.664M6.75 7.5H4.875c-.621 0-1.125.504-1.125 1.125v12c0 .621.504 1.125 1.125 1.125h9.75c.621 0 1.125-.504 1.125-1.125V16.5a9 9 0 0 0-9-9Z" />
+			    			</svg>
+			    		</button>
+			    	</div>
+			    	{{ end }}
+			    	<p id="copyConfirmation" hidden></p>
 			    	<div><b>Views</b>: {{ .Views }}
 			    </div>
                 {{ if eq .Views 0 }} 
@@ -50,14 +59,14 @@ var lookupTemplate = `
 			    </div>
             {{ end }}
 			<div>
-				<button class="mt-3 bg-transparent font-semibold hover:text-white py-2 px-4 border hover:border-transparent rounded" type="button" _="on click trigger closeModal">Close</button>
+				<button class="mt-3 bg-transparent font-semibold hover:text-white py-2 px-4 border hover:border-transparent rounded" type="button" data-close-modal>Close</button>
 			</div>
 		</div>
 	</div>
 </div>
 `
 var errorTemplate = `
-<div id="modal" _="on closeModal add .closing then wait for animationend then remove me">
+<div id="modal">
 	<div class="modal-underlay">
 		<div class="modal-content text-[#41454c] bg-[#fcfcfc] dark:text-[#ffffff] dark:bg-[#031022]">
             <h3 class="text-3xl text-[#41454c] dark:text-[#ffffff] max-w-none">Secret Information</h3>
@@ -65,7 +74,7 @@ var errorTemplate = `
 				<div>{{ .Err }}</div>
 			</div>
 			<div>
-				<button class="mt-3 bg-transparent font-semibold hover:text-white py-2 px-4 border hover:border-transparent rounded" type="button" _="on click trigger closeModal">Close</button>
+				<button class="mt-3 bg-transparent font-semibold hover:text-white py-2 px-4 border hover:border-transparent rounded" type="button" data-close-modal>Close</button>
 			</div>
 		</div>
 	</div>
@@ -73,28 +82,28 @@ var errorTemplate = `
 `
 
 var createTemplate = `
-<div id="modal" _="on closeModal add .closing then wait for animationend then remove me">
+<div id="modal">
 	<div class="modal-underlay">
 		<div class="modal-content text-[#41454c] bg-[#fcfcfc] dark:text-[#ffffff] dark:bg-[#031022]">
             <h3 class="text-3xl text-[#41454c] dark:text-[#ffffff] max-w-none">Secret Information</h3>
 			<div class="text-left items-left">
 				<div><b>Secret ID</b>: <a href={{ .Link }}>{{ .ID }}</a></div>
 				
-					<div><b>Password</b>: <span id="secretPassword" style="display:none">{{ .Password }}</span>
+					<div><b>Password</b>: <span id="secretPassword" hidden>{{ .Password }}</span>
 					<button class="px-4"
-						_="on click show #secretPassword then hide">
+						data-show="#secretPassword">
 						Show Password
 					</button>
 					<button class="px-4 text-[#41454c] dark:text-[#ffffff]"
-						_="on click call navigator.clipboard.writeText(#secretPassword.innerText) then put 'Secret Copied!' into #copyConfirmation then remove .hidden from #copyConfirmation">
-						<svg xmlns="http://www.w3.org/2000/svg" fill="none" viewBox="0 0 24 24" stroke-width="1.5" style="width:20px" stroke="currentColor">
+						data-copy="#secretPassword" data-copy-message="Secret Copied!">
+						<svg xmlns="http://www.w3.org/2000/svg" fill="none" viewBox="0 0 24 24" stroke-width="1.5" width="20" stroke="currentColor">
 							<path stroke-linecap="round" stroke-linejoin="round" d="M8.25 7.5V6.108c0-1.135.845-2.098 1.976-2.192.373-.03.748-.057 1.123-.08M15.75 18H18a2.25 2.25 0 0 0 2.25-2.25V6.108c0-1.135-.845-2.098-1.976-2.192a48.424 48.424 0 0 0-1.123-.08M15.75 18.75v-1.875a3.375 3.375 0 0 0-3.375-3.375h-1.5a1.125 1.125 0 0 1-1.125-1.125v-1.5A3.375 3.375 0 0 0 6.375 7.5H5.25m11.9-3.664A2.251 2.251 0 0 0 15 2.25h-1.5a2.251 2.251 0 0 0-2.15 1.586m5.8 0c.065.21.1.433.1.664v.75h-6V4.5c0-.231.035-.454.1-.664M6.75 7.5H4.875c-.621 0-1.125.504-1.125 1.125v12c0 .621.504 1.125 1.125 1.125h9.75c.621 0 1.125-.504 1.125-1.125V16.5a9 9 0 0 0-9-9Z" />
 						</svg>
 					</button>
 				</div>
-				<p id="copyConfirmation" class="hidden"></p>
+				<p id="copyConfirmation" hidden></p>
 			<div>
-				<button class="mt-3 bg-transparent font-semibold hover:text-white py-2 px-4 border hover:border-transparent rounded" type="button" _="on click trigger closeModal">Close</button>
+				<button class="mt-3 bg-transparent font-semibold hover:text-white py-2 px-4 border hover:border-transparent rounded" type="button" data-close-modal>Close</button>
 			</div>
 		</div>
 	</div>
@@ -110,31 +119,64 @@ func (s *Server) addHxSecret(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&tv); err != nil {
-		return err
+		return badRequest(err)
 	}
 
 	rec := secrets.Secret{
-		Text:  tv.Text,
-		Views: tv.Views,
+		Text:        tv.Text,
+		Views:       tv.Views,
+		Fields:      tv.Fields,
+		Description: tv.Description,
+		Passphrase:  tv.Passphrase,
 	}
 
-	resp, err := secrets.AddSecret(s.Backend, rec)
+	resp, err := secrets.AddSecret(clientContext(r), s.Backend, rec)
 	if err != nil {
 		return handleHTMXError(err, w)
 	}
 
-	url := r.Header.Get("origin")
+	idPass := IDPass{
+		ID:       resp.ID,
+		Password: resp.Password,
+		Link:     shareLink(r, resp.ID),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return modal.Execute(w, idPass)
+}
+
+// generateHxSecret stores a random value generated by the server. The value is never
+// sent back to the browser, only the link and password.
+func (s *Server) generateHxSecret(w http.ResponseWriter, r *http.Request) error {
+	var tv TextViews
 
-	if r.Header.Get("x-forward-host") != "" {
-		url = r.Header.Get("x-forward-host")
+	modal, err := template.New("modal").Parse(createTemplate)
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&tv); err != nil {
+		return badRequest(err)
+	}
+
+	rec := secrets.Secret{
+		Views:       tv.Views,
+		Description: tv.Description,
+		Passphrase:  tv.Passphrase,
+	}
+
+	resp, err := secrets.GenerateSecret(clientContext(r), s.Backend, rec, secrets.GeneratePolicy{})
+	if err != nil {
+		return handleHTMXError(err, w)
 	}
 
 	idPass := IDPass{
 		ID:       resp.ID,
 		Password: resp.Password,
-		Link:     fmt.Sprintf(`%s?id=%s`, url, resp.ID),
+		Link:     shareLink(r, resp.ID),
 	}
 
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	return modal.Execute(w, idPass)
 }
 
@@ -147,20 +189,22 @@ func (s *Server) getHxSecret(w http.ResponseWriter, r *http.Request) error {
 	r.ParseForm()
 
 	secret := secrets.Secret{
-		ID:       r.FormValue("id"),
-		Password: r.FormValue("password"),
+		ID:         r.FormValue("id"),
+		Password:   r.FormValue("password"),
+		Passphrase: r.FormValue("passphrase"),
 	}
 
-	resp, err := secrets.GetSecret(secret, s.Backend)
+	resp, err := secrets.GetSecret(clientContext(r), secret, s.Backend)
 	if err != nil {
 		return handleHTMXError(err, w)
 	}
 
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	return modal.Execute(w, resp)
 }
 
-func handleHTMXError(err error, w io.Writer) error {
-	code, errDetails := getErrorDetails(err)
+func handleHTMXError(err error, w http.ResponseWriter) error {
+	rerr := getErrorDetails(err)
 
 	modal, err := template.New("modal").Parse(errorTemplate)
 	if err != nil {
@@ -171,9 +215,10 @@ func handleHTMXError(err error, w io.Writer) error {
 		Code int
 		Err  string
 	}{
-		Code: code,
-		Err:  errDetails,
+		Code: rerr.Code(),
+		Err:  rerr.Body(),
 	}
 
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	return modal.Execute(w, r)
 }
@@ -0,0 +1,111 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hooksie1/gophemeral/secrets"
+)
+
+// accessLogEntry is one line of the access log. Only the path is logged, never the
+// query string, so passwords sent as query parameters don't end up in logs. Route
+// variables such as secret IDs are replaced by their names in the path.
+type accessLogEntry struct {
+	Time      string  `json:"time"`
+	Level     string  `json:"level"`
+	Msg       string  `json:"msg"`
+	RequestID string  `json:"request_id"`
+	Method    string  `json:"method"`
+	Route     string  `json:"route"`
+	Path      string  `json:"path"`
+	Status    int     `json:"status"`
+	Bytes     int     `json:"bytes"`
+	Duration  float64 `json:"duration_ms"`
+	ClientIP  string  `json:"client_ip"`
+	UserAgent string  `json:"user_agent,omitempty"`
+}
+
+// requestID uses the request ID sent by the client or creates one. It is returned
+// in the response and added to the request context.
+func requestID(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := secrets.RequestID(r.Header.Get(secrets.RequestIDHeader))
+		w.Header().Set(secrets.RequestIDHeader, id)
+
+		inner.ServeHTTP(w, r.WithContext(secrets.WithRequestID(r.Context(), id)))
+	})
+}
+
+// accessLog writes a JSON access log line for every request
+func accessLog(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		inner.ServeHTTP(rec, r)
+
+		data, err := json.Marshal(accessLogEntry{
+			Time:      start.UTC().Format(time.RFC3339Nano),
+			Level:     "INFO",
+			Msg:       "request",
+			RequestID: secrets.RequestIDFromContext(r.Context()),
+			Method:    r.Method,
+			Route:     routeTemplate(r),
+			Path:      loggedPath(r),
+			Status:    rec.status,
+			Bytes:     rec.bytes,
+			Duration:  float64(time.Since(start).Microseconds()) / 1000,
+			ClientIP:  clientIP(r),
+			UserAgent: r.UserAgent(),
+		})
+		if err != nil {
+			log.Printf("error encoding access log: %v", err)
+			return
+		}
+
+		log.Print(string(data))
+	})
+}
+
+// loggedPath returns the path with the segments that hold route variables replaced by
+// the variable's name, so /s/{id} is logged instead of the secret ID.
+func loggedPath(r *http.Request) string {
+	vars := mux.Vars(r)
+	if len(vars) == 0 {
+		return r.URL.Path
+	}
+
+	names := make(map[string]string, len(vars))
+	for k, v := range vars {
+		names[v] = "{" + k + "}"
+	}
+
+	segments := strings.Split(r.URL.Path, "/")
+	for i, v := range segments {
+		if name, ok := names[v]; ok && v != "" {
+			segments[i] = name
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,154 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/CoverWhale/logr"
+	"github.com/gorilla/mux"
+	"github.com/hooksie1/gophemeral/secrets"
+	"github.com/hooksie1/gophemeral/secrets/secretstest"
+)
+
+// captureLog sends the standard logger to a buffer for the rest of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	flags := log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	})
+
+	return &buf
+}
+
+// accessLogs returns the access log entries in the log output
+func accessLogs(t *testing.T, buf *bytes.Buffer) []accessLogEntry {
+	var entries []accessLogEntry
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+
+		var e accessLogEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("expected a JSON access log line but got %s: %v", line, err)
+		}
+		if e.Msg == "request" {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
+
+func TestAccessLog(t *testing.T) {
+	s := NewServer(secretstest.NewBackend(), logr.NewLogger(), 0)
+
+	rec := serve(s, "POST", "/api/v1/secrets", "", `{"text":"hunter2","views":2}`)
+	var created CreateSecretResponse
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := captureLog(t)
+
+	req := httptest.NewRequest("GET", "/api/v1/secrets/"+created.ID, nil)
+	req.Header.Set(secrets.RequestIDHeader, "req-123")
+	info := httptest.NewRecorder()
+	s.Router.Handler.ServeHTTP(info, req)
+
+	serve(s, "GET", "/api/secret?id="+created.ID+"&password="+created.Password, "", "")
+	serve(s, "POST", "/api/v1/secrets/"+created.ID+"/reveal", "", `{"password":"`+created.Password+`"}`)
+	serve(s, "GET", "/s/"+created.ID, "", "")
+	serve(s, "GET", "/api/v1/secrets/missing", "", "")
+
+	entries := accessLogs(t, buf)
+	if len(entries) != 5 {
+		t.Fatalf("expected 5 access log lines but got %d: %s", len(entries), buf.String())
+	}
+
+	tt := []struct {
+		route  string
+		path   string
+		status int
+	}{
+		{route: "/api/v1/secrets/{id}", path: "/api/v1/secrets/{id}", status: http.StatusOK},
+		{route: "/api/secret", path: "/api/secret", status: http.StatusUnauthorized},
+		{route: "/api/v1/secrets/{id}/reveal", path: "/api/v1/secrets/{id}/reveal", status: http.StatusOK},
+		{route: "/s/{id}", path: "/s/{id}", status: http.StatusOK},
+		{route: "/api/v1/secrets/{id}", path: "/api/v1/secrets/{id}", status: http.StatusNotFound},
+	}
+
+	for i, v := range tt {
+		e := entries[i]
+		if e.Route != v.route || e.Path != v.path || e.Status != v.status {
+			t.Errorf("expected %s %s %d but got %s %s %d", v.route, v.path, v.status, e.Route, e.Path, e.Status)
+		}
+
+		if e.Method == "" || e.Time == "" || e.Duration < 0 || e.ClientIP == "" {
+			t.Errorf("expected method, time, duration and client IP to be logged but got %+v", e)
+		}
+
+		if e.RequestID == "" {
+			t.Errorf("expected a request ID to be created for %s", e.Route)
+		}
+	}
+
+	if entries[0].RequestID != "req-123" || info.Header().Get(secrets.RequestIDHeader) != "req-123" {
+		t.Errorf("expected the client's request ID to be logged and returned but got %q", entries[0].RequestID)
+	}
+
+	if entries[0].Bytes != info.Body.Len() {
+		t.Errorf("expected %d bytes to be logged but got %d", info.Body.Len(), entries[0].Bytes)
+	}
+
+	for _, v := range []string{created.ID, created.Password, "hunter2", "?", "password="} {
+		if strings.Contains(buf.String(), v) {
+			t.Errorf("expected %q to not be logged: %s", v, buf.String())
+		}
+	}
+}
+
+func TestLoggedPath(t *testing.T) {
+	tt := []struct {
+		path     string
+		vars     map[string]string
+		expected string
+	}{
+		{path: "/api/v1/health", expected: "/api/v1/health"},
+		{path: "/s/abc", vars: map[string]string{"id": "abc"}, expected: "/s/{id}"},
+		{path: "/api/v1/secrets/s/reveal", vars: map[string]string{"id": "s"}, expected: "/api/v1/secrets/{id}/reveal"},
+	}
+
+	for _, v := range tt {
+		req := mux.SetURLVars(httptest.NewRequest("GET", v.path, nil), v.vars)
+		if got := loggedPath(req); got != v.expected {
+			t.Errorf("loggedPath(%s) = %s, expected %s", v.path, got, v.expected)
+		}
+	}
+}
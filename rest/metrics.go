@@ -0,0 +1,85 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hooksie1/gophemeral/metrics"
+)
+
+var (
+	httpRequests = metrics.NewCounter("gophemeral_http_requests_total", "HTTP requests by route, method and status", "route", "method", "status")
+	httpDuration = metrics.NewHistogram("gophemeral_http_request_duration_seconds", "Latency of HTTP requests by route, method and status", nil, "route", "method", "status")
+)
+
+// statusRecorder keeps the status code and size of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// instrument counts requests and their latency by the route template so IDs don't
+// end up in label values.
+func instrument(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		inner.ServeHTTP(rec, r)
+
+		route := routeTemplate(r)
+		status := strconv.Itoa(rec.status)
+		httpRequests.Inc(route, r.Method, status)
+		httpDuration.Observe(time.Since(start).Seconds(), route, r.Method, status)
+	})
+}
+
+// routeTemplate returns the path template of the matched route
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if tmpl, err := current.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+
+	return "other"
+}
+
+// HandleMetrics serves the metrics in the Prometheus text format at /metrics.
+func (s *Server) HandleMetrics() {
+	s.mux.Handle("/metrics", metrics.Handler()).Methods("GET")
+}
@@ -0,0 +1,215 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/hooksie1/gophemeral/secrets"
+	"github.com/invopop/jsonschema"
+)
+
+// APIVersion is the version of the /api/v1 API in the OpenAPI document.
+const APIVersion = "1.0.0"
+
+type openAPIDoc struct {
+	OpenAPI    string                     `json:"openapi"`
+	Info       openAPIInfo                `json:"info"`
+	Paths      map[string]openAPIPathItem `json:"paths"`
+	Components openAPIComponents          `json:"components"`
+	Security   []map[string][]string      `json:"security"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+type openAPIPathItem map[string]openAPIOperation
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required"`
+	Schema      map[string]any `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema map[string]any `json:"schema"`
+}
+
+type openAPIComponents struct {
+	Schemas         jsonschema.Definitions    `json:"schemas"`
+	SecuritySchemes map[string]map[string]any `json:"securitySchemes"`
+}
+
+// OpenAPI returns the OpenAPI 3.1 document of /api/v1. The schemas are generated from
+// the request and response types.
+func OpenAPI() ([]byte, error) {
+	r := &jsonschema.Reflector{Anonymous: true}
+	schemas := jsonschema.Definitions{}
+	for _, v := range []any{
+		CreateSecretRequest{},
+		CreateSecretResponse{},
+		RevealSecretRequest{},
+		SecretResponse{},
+		BatchRequest{},
+		BatchResponse{},
+		secrets.ErrorEnvelope{},
+		secrets.Metadata{},
+	} {
+		for name, schema := range r.Reflect(v).Definitions {
+			schemas[name] = schema
+		}
+	}
+
+	idParam := openAPIParameter{Name: "id", In: "path", Required: true, Description: "ID of the secret", Schema: map[string]any{"type": "string"}}
+	idempotencyParam := openAPIParameter{Name: secrets.IdempotencyHeader, In: "header", Description: "Repeating a key returns the original response instead of creating another secret", Schema: map[string]any{"type": "string"}}
+
+	doc := openAPIDoc{
+		OpenAPI: "3.1.0",
+		Info: openAPIInfo{
+			Title:       "Gophemeral",
+			Description: "Share secrets that are destroyed after they are viewed.",
+			Version:     APIVersion,
+		},
+		Paths: map[string]openAPIPathItem{
+			"/api/v1/secrets": {
+				"post": {
+					OperationID: "createSecret",
+					Summary:     "Create a secret",
+					Parameters:  []openAPIParameter{idempotencyParam},
+					RequestBody: body(CreateSecretRequest{}),
+					Responses:   responses(http.StatusCreated, "The secret was created", CreateSecretResponse{}),
+				},
+			},
+			"/api/v1/secrets/batch": {
+				"post": {
+					OperationID: "createSecrets",
+					Summary:     "Create a batch of secrets, each secret succeeds or fails on its own",
+					RequestBody: body(BatchRequest{}),
+					Responses:   responses(http.StatusOK, "The result of each secret", BatchResponse{}),
+				},
+			},
+			"/api/v1/secrets/{id}": {
+				"get": {
+					OperationID: "getSecretInfo",
+					Summary:     "Get the metadata of a secret without using a view",
+					Parameters:  []openAPIParameter{idParam},
+					Responses:   responses(http.StatusOK, "The metadata of the secret", secrets.Metadata{}),
+				},
+			},
+			"/api/v1/secrets/{id}/reveal": {
+				"post": {
+					OperationID: "revealSecret",
+					Summary:     "Reveal a secret, this uses one view",
+					Parameters:  []openAPIParameter{idParam},
+					RequestBody: body(RevealSecretRequest{}),
+					Responses:   responses(http.StatusOK, "The secret", SecretResponse{}),
+				},
+			},
+			"/api/v1/health": {
+				"get": {
+					OperationID: "health",
+					Summary:     "Check the server is up",
+					Responses:   map[string]openAPIResponse{"200": {Description: "The server is up"}},
+				},
+			},
+		},
+		Components: openAPIComponents{
+			Schemas: schemas,
+			SecuritySchemes: map[string]map[string]any{
+				"apiKey": {"type": "http", "scheme": "bearer", "description": "API key issued with gophemeral admin apikey create"},
+			},
+		},
+		// API keys are optional unless the server turned off anonymous access
+		Security: []map[string][]string{{"apiKey": {}}, {}},
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	// jsonschema references its own definitions, in OpenAPI they are components
+	return []byte(strings.ReplaceAll(string(data), "#/$defs/", "#/components/schemas/")), nil
+}
+
+func ref(v any) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + reflect.TypeOf(v).Name()}
+}
+
+func body(v any) *openAPIRequestBody {
+	return &openAPIRequestBody{
+		Required: true,
+		Content:  map[string]openAPIMediaType{"application/json": {Schema: ref(v)}},
+	}
+}
+
+// responses returns the success response and the error responses every operation has
+func responses(status int, description string, v any) map[string]openAPIResponse {
+	errResponse := func(description string) openAPIResponse {
+		return openAPIResponse{
+			Description: description,
+			Content:     map[string]openAPIMediaType{"application/json": {Schema: ref(secrets.ErrorEnvelope{})}},
+		}
+	}
+
+	return map[string]openAPIResponse{
+		strconv.Itoa(status): {
+			Description: description,
+			Content:     map[string]openAPIMediaType{"application/json": {Schema: ref(v)}},
+		},
+		"4XX": errResponse("The request was invalid or the secret wasn't found"),
+		"5XX": errResponse("The server failed"),
+	}
+}
+
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) error {
+	data, err := OpenAPI()
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	return err
+}
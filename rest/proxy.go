@@ -0,0 +1,156 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// WithTrustedProxies trusts the forwarding headers of requests from the proxies. Without
+// trusted proxies the headers are ignored since any client can set them.
+func WithTrustedProxies(p []netip.Prefix) ServerOption {
+	return func(s *Server) {
+		s.proxies = p
+	}
+}
+
+// ParseTrustedProxies parses IP addresses and CIDR ranges of proxies.
+func ParseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range values {
+		if p, err := netip.ParsePrefix(v); err == nil {
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s", v)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+// forwarded is what a trusted proxy reported about the client
+type forwarded struct {
+	ip    string
+	https bool
+	host  string
+}
+
+type forwardedKey struct{}
+
+// proxyHeaders resolves the client address, scheme and host from the forwarding headers
+// when the request comes from a trusted proxy.
+func proxyHeaders(proxies []netip.Prefix, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote := remoteAddr(r)
+		if !trusted(proxies, remote) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		f := forwarded{
+			ip:    remote,
+			https: strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https"),
+			host:  r.Header.Get("X-Forwarded-Host"),
+		}
+
+		if ip := r.Header.Get("Fly-Client-IP"); ip != "" {
+			f.ip = strings.TrimSpace(ip)
+		} else if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			// proxies append the address they received the request from, so the first
+			// untrusted address from the right is the client
+			hops := strings.Split(strings.Join(xff, ","), ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := strings.TrimSpace(hops[i])
+				if hop == "" {
+					continue
+				}
+				f.ip = hop
+				if !trusted(proxies, hop) {
+					break
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), forwardedKey{}, f)))
+	})
+}
+
+func trusted(proxies []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, v := range proxies {
+		if v.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func remoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// clientIP returns the IP of the client. The address reported by a trusted proxy is
+// preferred over the address of the connection.
+func clientIP(r *http.Request) string {
+	if f, ok := r.Context().Value(forwardedKey{}).(forwarded); ok {
+		return f.ip
+	}
+
+	return remoteAddr(r)
+}
+
+// requestHTTPS returns true if the client used HTTPS, either to the server or to a
+// trusted proxy.
+func requestHTTPS(r *http.Request) bool {
+	if f, ok := r.Context().Value(forwardedKey{}).(forwarded); ok && f.https {
+		return true
+	}
+
+	return r.TLS != nil
+}
+
+// requestHost returns the host the client sent the request to. The host reported by a
+// trusted proxy is preferred over the Host header.
+func requestHost(r *http.Request) string {
+	if f, ok := r.Context().Value(forwardedKey{}).(forwarded); ok && f.host != "" {
+		return f.host
+	}
+
+	return r.Host
+}
@@ -21,13 +21,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"net/http"
-	"os"
-	"os/signal"
+	"net/netip"
+	"net/url"
 	"strconv"
-	"syscall"
 	"time"
 
 	"github.com/CoverWhale/logr"
@@ -42,6 +42,23 @@ type Server struct {
 	Router   *http.Server
 	Logger   *logr.Logger
 	Length   int
+
+	mux     *mux.Router
+	auth    *secrets.Authenticator
+	headers SecurityHeaders
+	cors    *CORS
+	proxies []netip.Prefix
+}
+
+// ServerOption configures the server.
+type ServerOption func(*Server)
+
+// WithAuthenticator requires API keys with the scope of each route. Without an
+// authenticator every route is anonymous.
+func WithAuthenticator(a *secrets.Authenticator) ServerOption {
+	return func(s *Server) {
+		s.auth = a
+	}
 }
 
 type IDPass struct {
@@ -51,8 +68,12 @@ type IDPass struct {
 }
 
 type TextViews struct {
-	Text  string `json:"text,omitempty"`
-	Views int    `json:"views"`
+	Text   string          `json:"text,omitempty"`
+	Views  int             `json:"views"`
+	Fields []secrets.Field `json:"fields,omitempty"`
+
+	Description string `json:"description,omitempty"`
+	Passphrase  string `json:"passphrase,omitempty"`
 }
 
 func (t *TextViews) UnmarshalJSON(b []byte) error {
@@ -66,12 +87,38 @@ func (t *TextViews) UnmarshalJSON(b []byte) error {
 		return fmt.Errorf("TextViews was empty")
 	}
 
-	text, ok := data["text"].(string)
-	if !ok {
-		return fmt.Errorf("whoa")
+	if fields, ok := data["fields"]; ok {
+		b, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal(b, &t.Fields); err != nil {
+			return fmt.Errorf("unacceptable value for fields")
+		}
 	}
 
-	t.Text = text
+	// text is optional for structured and generated secrets
+	if text, ok := data["text"]; ok {
+		t.Text, ok = text.(string)
+		if !ok {
+			return fmt.Errorf("unacceptable value for text")
+		}
+	}
+
+	if description, ok := data["description"]; ok {
+		t.Description, ok = description.(string)
+		if !ok {
+			return fmt.Errorf("unacceptable value for description")
+		}
+	}
+
+	if passphrase, ok := data["passphrase"]; ok {
+		t.Passphrase, ok = passphrase.(string)
+		if !ok {
+			return fmt.Errorf("unacceptable value for passphrase")
+		}
+	}
 
 	views, ok := data["views"].(int)
 	if ok {
@@ -124,7 +171,7 @@ func (i *IDPass) UnmarshalJSON(b []byte) error {
 
 }
 
-func NewServer(b secrets.Backend, l *logr.Logger, port int) Server {
+func NewServer(b secrets.Backend, l *logr.Logger, port int, opts ...ServerOption) Server {
 	address := fmt.Sprintf(":%d", port)
 
 	apiServer := &http.Server{
@@ -143,64 +190,170 @@ func NewServer(b secrets.Backend, l *logr.Logger, port int) Server {
 		Router:  apiServer,
 		Backend: b,
 		Logger:  l,
+		headers: DefaultSecurityHeaders(),
+	}
+	for _, opt := range opts {
+		opt(&s)
 	}
 	router := mux.NewRouter().StrictSlash(true)
+	router.Use(requestID, accessLog, instrument, trace)
+	s.mux = router
 	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.FS(sub))))
 	router.Handle("/", http.FileServer(http.FS(sub)))
 
-	apiServer.Handler = router
+	// recipients open secrets in a browser without an API key, the link and password
+	// are their credentials
+	router.Handle("/s/{id}", errHandlers(s.revealSecret)).Methods("GET", "HEAD")
 
 	hxRouter := router.PathPrefix("/hx").Subrouter().StrictSlash(true)
-	hxRouter.Handle("/createSecret", http.HandlerFunc(errHandlers(s.addHxSecret))).Methods("POST")
+	hxRouter.Handle("/createSecret", http.HandlerFunc(errHandlers(s.authorizeHTMX(secrets.ScopeCreate, s.addHxSecret)))).Methods("POST")
 	hxRouter.Handle("/lookupSecret", http.HandlerFunc(errHandlers(s.getHxSecret))).Methods("POST")
+	hxRouter.Handle("/generateSecret", http.HandlerFunc(errHandlers(s.authorizeHTMX(secrets.ScopeCreate, s.generateHxSecret)))).Methods("POST")
+
+	s.v1Routes(router)
 
+	// /api/secret is kept for clients written before /api/v1
 	apiRouter := router.PathPrefix("/api").Subrouter().StrictSlash(true)
-	apiRouter.Handle("/secret", http.HandlerFunc(errHandlers(s.addSecret))).Methods("POST")
-	apiRouter.Handle("/secret", http.HandlerFunc(errHandlers(s.getSecret))).Methods("GET")
+	apiRouter.Handle("/secret", http.HandlerFunc(errHandlers(s.authorize(secrets.ScopeCreate, s.addSecret)))).Methods("POST")
+	apiRouter.Handle("/secret", http.HandlerFunc(errHandlers(s.authorize(secrets.ScopeLookup, s.getSecret)))).Methods("GET")
+	apiRouter.Handle("/secret/batch", http.HandlerFunc(errHandlers(s.authorize(secrets.ScopeCreate, s.addSecrets)))).Methods("POST")
+	apiRouter.Handle("/secret/info", http.HandlerFunc(errHandlers(s.authorize(secrets.ScopeStatus, s.getSecretInfo)))).Methods("GET")
 	apiRouter.Handle("/health", http.HandlerFunc(getHealth)).Methods("GET")
 
-	apiRouter.Use(s.logger)
-	hxRouter.Use(s.logger)
-
-	apiServer.Handler = router
+	// the headers are added outside of the router so 404 and 405 responses and CORS
+	// preflight requests get them too
+	apiServer.Handler = proxyHeaders(s.proxies, secureHeaders(s.headers, corsHandler(s.cors, router)))
 
 	return s
 }
 
-func (s *Server) Serve(errChan chan<- error) {
-	if err := s.Router.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		errChan <- err
+// clientContext returns the request context with the client information attached.
+func clientContext(r *http.Request) context.Context {
+	ctx := secrets.WithClient(r.Context(), secrets.Client{
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		RequestID: secrets.RequestIDFromContext(r.Context()),
+		Headers:   secrets.ClientHeaders(r.Header),
+	})
+
+	if key, ok := secrets.APIKeyFromContext(ctx); ok {
+		ctx = secrets.WithAPIKey(ctx, key)
+	}
+
+	if id, ok := secrets.IdentityFromContext(ctx); ok {
+		ctx = secrets.WithIdentity(ctx, id)
 	}
+
+	return ctx
 }
 
-func getHealth(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
+// authorize checks the API key of the request has the scope before calling the handler
+func (s *Server) authorize(scope string, h AppHandlerFunc) AppHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		r, err := s.authenticate(r, scope)
+		if err != nil {
+			return err
+		}
+
+		return h(w, r)
+	}
 }
 
-// logger logs the endpoint requested and times how long the request takes.
-func (s *Server) logger(inner http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		defer func() {
-			ctx := s.Logger.WithContext(map[string]string{"method": r.Method, "path": r.RequestURI})
-			ctx.Infof("processing time: %dms", time.Since(start).Milliseconds())
+// authorizeHTMX is authorize for the routes of the web app. Failures are shown in the
+// page since HTMX doesn't swap in error responses.
+func (s *Server) authorizeHTMX(scope string, h AppHandlerFunc) AppHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		r, err := s.authenticate(r, scope)
+		if err != nil {
+			return handleHTMXError(err, w)
+		}
+
+		return h(w, r)
+	}
+}
 
-		}()
+// authenticate returns the request with the context of its API key or token
+func (s *Server) authenticate(r *http.Request, scope string) (*http.Request, error) {
+	if s.auth == nil {
+		return r, nil
+	}
 
-		inner.ServeHTTP(w, r)
+	ctx, err := s.auth.Authenticate(clientContext(r), r.Header.Get(secrets.AuthorizationHeader), scope)
+	if err != nil {
+		return r, err
+	}
 
-	})
+	return r.WithContext(ctx), nil
+}
+
+// shareLink builds the link to a secret from the host the request was sent to. The
+// Origin header is only used when it's for the same host, since it's set by the page
+// that sent the request.
+func shareLink(r *http.Request, id string) string {
+	host := requestHost(r)
+	base := "http://" + host
+	if requestHTTPS(r) {
+		base = "https://" + host
+	}
+
+	if o, err := url.Parse(r.Header.Get("Origin")); err == nil && o.Host == host && (o.Scheme == "http" || o.Scheme == "https") {
+		base = o.Scheme + "://" + o.Host
+	}
+
+	return fmt.Sprintf(`%s/s/%s`, base, id)
+}
+
+// createOnce creates the secret once for the idempotency key of the request. If only
+// storing the response for retries failed the secret is still returned.
+func (s *Server) createOnce(r *http.Request, body []byte, create func(context.Context) (secrets.Secret, error)) (secrets.Secret, error) {
+	key := r.Header.Get(secrets.IdempotencyHeader)
+	record, err := secrets.CreateOnce(clientContext(r), s.Backend, key, body, create)
+	if errors.Is(err, secrets.ErrIdempotencyNotStored) {
+		s.Logger.Errorf("created secret %s without saving its idempotency key: %v", record.ID, err)
+		return record, nil
+	}
+
+	return record, err
+}
+
+func (s *Server) Serve(errChan chan<- error) {
+	var err error
+	if s.Router.TLSConfig != nil {
+		// the certificate comes from the TLS config so it can be reloaded
+		err = s.Router.ListenAndServeTLS("", "")
+	} else {
+		err = s.Router.ListenAndServe()
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		errChan <- err
+	}
+}
+
+func getHealth(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
 }
 
-// AddRecord is a handler that creates a record
+// AddRecord is a handler that creates a record. If a generate policy is passed the
+// secret text is generated by the server instead.
 func (s *Server) addSecret(w http.ResponseWriter, r *http.Request) error {
-	var secret secrets.Secret
+	var req CreateSecretRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&secret); err != nil {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
 		return err
 	}
 
-	record, err := secrets.AddSecret(s.Backend, secret)
+	if err := json.Unmarshal(body, &req); err != nil {
+		return badRequest(err)
+	}
+
+	record, err := s.createOnce(r, body, func(ctx context.Context) (secrets.Secret, error) {
+		if req.Generate != nil {
+			return secrets.GenerateSecret(ctx, s.Backend, req.Secret(), *req.Generate)
+		}
+		return secrets.AddSecret(ctx, s.Backend, req.Secret())
+	})
 	if err != nil {
 		return err
 	}
@@ -208,8 +361,39 @@ func (s *Server) addSecret(w http.ResponseWriter, r *http.Request) error {
 	resp := IDPass{
 		ID:       record.ID,
 		Password: record.Password,
+		Link:     shareLink(r, record.ID),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return fmt.Errorf("error encoding json data: %s", err)
+	}
+
+	return nil
+}
+
+// addSecrets is a handler that creates a batch of records. Each result holds the
+// link or the error of its secret.
+func (s *Server) addSecrets(w http.ResponseWriter, r *http.Request) error {
+	var req BatchRequest
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(body, &req); err != nil {
+		return badRequest(err)
 	}
 
+	results, err := s.batch(r, body, req.Secrets)
+	if err != nil {
+		return err
+	}
+
+	resp := BatchResponse{Results: results}
+
+	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		return fmt.Errorf("error encoding json data: %s", err)
 	}
@@ -217,24 +401,57 @@ func (s *Server) addSecret(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// batch creates the secrets once for the idempotency key of the request and adds the
+// share link of each one to its result
+func (s *Server) batch(r *http.Request, body []byte, reqs []CreateSecretRequest) ([]secrets.BatchResult, error) {
+	items := make([]secrets.BatchItem, len(reqs))
+	for i, v := range reqs {
+		items[i] = secrets.BatchItem{Secret: v.Secret(), Generate: v.Generate}
+	}
+
+	key := r.Header.Get(secrets.IdempotencyHeader)
+	results, err := secrets.AddSecretsOnce(clientContext(r), s.Backend, key, body, items)
+	if errors.Is(err, secrets.ErrIdempotencyNotStored) {
+		s.Logger.Errorf("created batch without saving its idempotency key: %v", err)
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, v := range results {
+		if v.ID != "" {
+			results[i].Link = shareLink(r, v.ID)
+		}
+		if v.Code == http.StatusInternalServerError {
+			s.Logger.Errorf("error creating secret %d of batch: %v", v.Index, v.Err)
+		}
+	}
+
+	return results, nil
+}
+
 // getRecord is a handler that retrieves a record
 func (s *Server) getSecret(w http.ResponseWriter, r *http.Request) error {
 	password := r.Header.Get("X-Password")
 	secret := secrets.Secret{
-		ID:       r.URL.Query().Get("id"),
-		Password: password,
+		ID:         r.URL.Query().Get("id"),
+		Password:   password,
+		Passphrase: r.Header.Get("X-Passphrase"),
 	}
 
-	record, err := secrets.GetSecret(secret, s.Backend)
+	record, err := secrets.GetSecret(clientContext(r), secret, s.Backend)
 	if err != nil {
 		return err
 	}
 
 	resp := TextViews{
-		Text:  record.Text,
-		Views: record.Views,
+		Text:   record.Text,
+		Views:  record.Views,
+		Fields: record.Fields,
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		return fmt.Errorf("error encoding json data: %s", err)
 	}
@@ -243,28 +460,29 @@ func (s *Server) getSecret(w http.ResponseWriter, r *http.Request) error {
 
 }
 
-func (s *Server) AutoHandleErrors(ctx context.Context, errChan <-chan error) {
-	go func() {
-		serverErr := <-errChan
-		if serverErr != nil {
-			s.Logger.Errorf("error starting server: %v", serverErr)
-			s.ShutdownServer(ctx)
-		}
-	}()
+// getSecretInfo is a handler that returns the metadata of a record without using a view
+func (s *Server) getSecretInfo(w http.ResponseWriter, r *http.Request) error {
+	metadata, err := secrets.GetMetadata(clientContext(r), r.URL.Query().Get("id"), s.Backend)
+	if err != nil {
+		return err
+	}
 
-	sigTerm := make(chan os.Signal, 1)
-	signal.Notify(sigTerm, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(metadata); err != nil {
+		return fmt.Errorf("error encoding json data: %s", err)
+	}
 
-	sig := <-sigTerm
-	s.Logger.Infof("received signal: %s", sig)
-	s.ShutdownServer(ctx)
+	return nil
 }
 
-func (s *Server) ShutdownServer(ctx context.Context) {
+// Shutdown stops accepting connections and waits for in flight requests to finish
+// or for the context to be done.
+func (s *Server) Shutdown(ctx context.Context) error {
 	s.Logger.Info("shutting down server")
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
+	if err := s.Router.Shutdown(ctx); err != nil {
+		return err
+	}
 
 	s.Logger.Info("server stopped")
-	os.Exit(1)
+	return nil
 }
@@ -0,0 +1,282 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CoverWhale/logr"
+	"github.com/hooksie1/gophemeral/secrets"
+	"github.com/hooksie1/gophemeral/secrets/secretstest"
+)
+
+func serve(s Server, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set(secrets.AuthorizationHeader, "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	s.Router.Handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRevealContentType(t *testing.T) {
+	s := NewServer(secretstest.NewBackend(), logr.NewLogger(), 0)
+
+	rec := serve(s, "GET", "/s/missing", "", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 but got %d", rec.Code)
+	}
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("expected the error page to be html but got %q", ct)
+	}
+}
+
+func TestV1Routes(t *testing.T) {
+	s := NewServer(secretstest.NewBackend(), logr.NewLogger(), 0)
+
+	rec := serve(s, "POST", "/api/v1/secrets", "", `{"text":"hunter2","views":2,"description":"db"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201 but got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var created CreateSecretResponse
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" || created.Password == "" || !strings.HasSuffix(created.Link, "/s/"+created.ID) {
+		t.Fatalf("unexpected response %+v", created)
+	}
+
+	info := serve(s, "GET", "/api/v1/secrets/"+created.ID, "", "")
+	var metadata secrets.Metadata
+	if err := json.NewDecoder(info.Body).Decode(&metadata); err != nil || info.Code != http.StatusOK {
+		t.Fatalf("expected metadata but got %d: %v", info.Code, err)
+	}
+	if metadata.Views != 2 || metadata.Description != "db" {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+
+	reveal := serve(s, "POST", "/api/v1/secrets/"+created.ID+"/reveal", "", `{"password":"`+created.Password+`"}`)
+	var secret SecretResponse
+	if err := json.NewDecoder(reveal.Body).Decode(&secret); err != nil || reveal.Code != http.StatusOK {
+		t.Fatalf("expected the secret but got %d: %v", reveal.Code, err)
+	}
+	if secret.Text != "hunter2" || secret.Views != 1 {
+		t.Errorf("unexpected secret %+v", secret)
+	}
+
+	if rec := serve(s, "POST", "/api/v1/secrets/"+created.ID+"/reveal", "", `{"password":"wrong"}`); rec.Code < 400 {
+		t.Errorf("expected a wrong password to fail but got %d", rec.Code)
+	}
+
+	batch := serve(s, "POST", "/api/v1/secrets/batch", "", `{"secrets":[{"text":"a","views":1},{"text":"b","views":0}]}`)
+	var results BatchResponse
+	if err := json.NewDecoder(batch.Body).Decode(&results); err != nil || batch.Code != http.StatusOK {
+		t.Fatalf("expected batch results but got %d: %v", batch.Code, err)
+	}
+	if len(results.Results) != 2 || results.Results[0].ID == "" || results.Results[1].Error == "" {
+		t.Errorf("expected one created and one failed secret but got %+v", results.Results)
+	}
+
+	if rec := serve(s, "GET", "/api/v1/health", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("expected health to be ok but got %d", rec.Code)
+	}
+}
+
+func TestV1RejectsUnknownFields(t *testing.T) {
+	s := NewServer(secretstest.NewBackend(), logr.NewLogger(), 0)
+
+	tt := []struct {
+		name string
+		path string
+		body string
+	}{
+		{name: "create with id", path: "/api/v1/secrets", body: `{"text":"hunter2","views":1,"id":"abc"}`},
+		{name: "create with password", path: "/api/v1/secrets", body: `{"text":"hunter2","views":1,"password":"abc"}`},
+		{name: "batch with id", path: "/api/v1/secrets/batch", body: `{"secrets":[{"text":"a","views":1,"id":"abc"}]}`},
+		{name: "reveal with text", path: "/api/v1/secrets/abc/reveal", body: `{"password":"abc","text":"x"}`},
+		{name: "not json", path: "/api/v1/secrets", body: `text=hunter2`},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			rec := serve(s, "POST", v.path, "", v.body)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("expected 400 but got %d: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestLegacyRoutes(t *testing.T) {
+	s := NewServer(secretstest.NewBackend(), logr.NewLogger(), 0)
+
+	// legacy clients are decoded leniently, so fields the old API ignored still work
+	rec := serve(s, "POST", "/api/secret", "", `{"text":"hunter2","views":2,"id":"ignored"}`)
+	var created IDPass
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("expected the legacy create to succeed but got %d: %v", rec.Code, err)
+	}
+	if created.ID == "ignored" || created.Password == "" {
+		t.Fatalf("unexpected response %+v", created)
+	}
+
+	info := serve(s, "GET", "/api/secret/info?id="+created.ID, "", "")
+	var metadata secrets.Metadata
+	if err := json.NewDecoder(info.Body).Decode(&metadata); err != nil || metadata.Views != 2 {
+		t.Errorf("expected the legacy info lookup to return 2 views but got %d: %v", info.Code, err)
+	}
+
+	req := httptest.NewRequest("GET", "/api/secret?id="+created.ID, nil)
+	req.Header.Set("X-Password", created.Password)
+	lookup := httptest.NewRecorder()
+	s.Router.Handler.ServeHTTP(lookup, req)
+
+	// TextViews validates requests, so decode the response on its own
+	var secret struct {
+		Text  string `json:"text"`
+		Views int    `json:"views"`
+	}
+	if err := json.NewDecoder(lookup.Body).Decode(&secret); err != nil || secret.Text != "hunter2" || secret.Views != 1 {
+		t.Errorf("expected the legacy lookup to return the secret but got %d %+v: %v", lookup.Code, secret, err)
+	}
+
+	batch := serve(s, "POST", "/api/secret/batch", "", `{"secrets":[{"text":"a","views":1}]}`)
+	var results BatchResponse
+	if err := json.NewDecoder(batch.Body).Decode(&results); err != nil || len(results.Results) != 1 || results.Results[0].ID == "" {
+		t.Errorf("expected the legacy batch to create a secret but got %d: %v", batch.Code, err)
+	}
+}
+
+// refs returns every $ref in the JSON value
+func refs(v any) []string {
+	var found []string
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if s, ok := child.(string); ok && k == "$ref" {
+				found = append(found, s)
+				continue
+			}
+			found = append(found, refs(child)...)
+		}
+	case []any:
+		for _, child := range v {
+			found = append(found, refs(child)...)
+		}
+	}
+
+	return found
+}
+
+func TestOpenAPI(t *testing.T) {
+	s := NewServer(secretstest.NewBackend(), logr.NewLogger(), 0)
+
+	rec := serve(s, "GET", "/api/v1/openapi.json", "", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected the document but got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	var doc struct {
+		OpenAPI    string                    `json:"openapi"`
+		Paths      map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	data := rec.Body.Bytes()
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("expected the document to parse: %v", err)
+	}
+
+	if doc.OpenAPI != "3.1.0" {
+		t.Errorf("expected openapi 3.1.0 but got %q", doc.OpenAPI)
+	}
+
+	for path, method := range map[string]string{
+		"/api/v1/secrets":             "post",
+		"/api/v1/secrets/batch":       "post",
+		"/api/v1/secrets/{id}":        "get",
+		"/api/v1/secrets/{id}/reveal": "post",
+		"/api/v1/health":              "get",
+	} {
+		if _, ok := doc.Paths[path][method]; !ok {
+			t.Errorf("expected %s %s in the document", method, path)
+		}
+	}
+
+	for _, v := range []string{"CreateSecretRequest", "CreateSecretResponse", "RevealSecretRequest", "SecretResponse", "BatchRequest", "BatchResponse", "ErrorEnvelope", "Metadata"} {
+		if _, ok := doc.Components.Schemas[v]; !ok {
+			t.Errorf("expected a %s schema", v)
+		}
+	}
+
+	var raw any
+	json.Unmarshal(data, &raw)
+	all := refs(raw)
+	if len(all) == 0 {
+		t.Fatal("expected the document to have references")
+	}
+
+	for _, v := range all {
+		name, ok := strings.CutPrefix(v, "#/components/schemas/")
+		if !ok {
+			t.Errorf("expected %s to reference components/schemas", v)
+			continue
+		}
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("%s does not resolve", v)
+		}
+	}
+}
+
+func TestIsPreviewBot(t *testing.T) {
+	tt := []struct {
+		userAgent string
+		bot       bool
+	}{
+		{userAgent: "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", bot: true},
+		{userAgent: "Mozilla/5.0 (Windows NT 6.1; WOW64) SkypeUriPreview Preview/0.5 skype-url-preview@microsoft.com", bot: true},
+		{userAgent: "Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)", bot: true},
+		{userAgent: "TelegramBot (like TwitterBot)", bot: true},
+		{userAgent: "WhatsApp/2.23.20.0 A", bot: true},
+		{userAgent: "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", bot: true},
+		{userAgent: "LinkedInBot/1.0 (compatible; Mozilla/5.0; Apache-HttpClient +http://www.linkedin.com)", bot: true},
+		{userAgent: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", bot: true},
+		{userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) BingPreview/1.0b", bot: true},
+		{userAgent: "Mattermost-Bot/1.1", bot: true},
+		{userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36"},
+		{userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_5) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Safari/605.1.15"},
+		{userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:127.0) Gecko/20100101 Firefox/127.0"},
+		{userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36 Edg/126.0.0.0"},
+		{userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64; Microsoft Outlook 16.0.17726; Microsoft Office; ms-office) like Gecko"},
+		{userAgent: ""},
+	}
+
+	for _, v := range tt {
+		if got := isPreviewBot(v.userAgent); got != v.bot {
+			t.Errorf("isPreviewBot(%q) = %t, expected %t", v.userAgent, got, v.bot)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright © 2023 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/hooksie1/gophemeral/secrets"
+)
+
+// previewBots are user agent tokens of link unfurlers and mail scanners. They are
+// served a generic card so they never look up a secret. Only tokens specific to a
+// crawler are listed, generic words like "bot" or "preview" also match real browsers.
+var previewBots = []string{
+	"slackbot",
+	"slack-imgproxy",
+	"skypeuripreview",
+	"discordbot",
+	"telegrambot",
+	"whatsapp/",
+	"twitterbot",
+	"facebookexternalhit",
+	"facebot",
+	"linkedinbot",
+	"pinterestbot",
+	"redditbot",
+	"embedly",
+	"iframely",
+	"googlebot",
+	"bingbot",
+	"bingpreview",
+	"duckduckbot",
+	"applebot",
+	"yandexbot",
+	"mattermost-bot",
+	"zoominfobot",
+	"google-safety",
+}
+
+var revealPage = `<!DOCTYPE html>
+<html class="scroll-smooth">
+<head>
+  <meta charset="UTF-8" />
+  <meta name="viewport" content="width=device-width, initial-scale=1.0, maximum-scale=1.0, user-scalable=no">
+  <meta name="robots" content="noindex, nofollow" />
+  <title>Gophemeral</title>
+  <meta property="og:title" content="Gophemeral" />
+  <meta property="og:description" content="Someone shared a secret with you. Open the link to reveal it." />
+  <link rel="stylesheet" href="/static/min.css" />
+  {{ if not .Bot }}
+  <meta name="htmx-config" content='{"allowEval":false,"includeIndicatorStyles":false}' />
+  <script src="/static/htmx.min.js"
+    integrity="sha384-lVb3Rd/Ca0AxaoZg5sACe8FJKF0tnUgR2Kd7ehUOG5GCcROv5uBIZsOqovBAcWua"></script>
+  <script src="/static/app.js"></script>
+  {{ end }}
+</head>
+
+<body class="font-body antialiased text-[#41454c] bg-[#fcfcfc] dark:text-[#ffffff] dark:bg-[#031022]">
+  <div class="mt-6 flex flex-wrap justify-center">
+    <div class="px-4 py-16 sm:px-6 lg:px-8">
+      <div class="max-w-lg mx-auto sm:max-w-md">
+        <h1 class="text-3xl font-bold text-center text-primary-500">Someone shared a secret with you</h1>
+        {{ if .Bot }}
+          <p class="mt-6 text-center">Open this link in your browser to reveal the secret.</p>
+        {{ else if .Err }}
+          <p class="mt-6 text-center">{{ .Err }}</p>
+        {{ else }}
+          <form class="mt-6 mb-0 space-y-4 rounded-lg shadow-2xl dark:shadow-slate-800 p-[55px]"
+            hx-post="/hx/lookupSecret" hx-trigger="submit" hx-target="body" hx-swap="beforeend">
+            <div class="text-left items-left">
+              {{ if .Metadata.Description }}<div class="whitespace-pre-wrap break-words"><b>Description</b>: {{ .Metadata.Description }}</div>{{ end }}
+              <div><b>Views remaining</b>: {{ .Metadata.Views }}</div>
+              {{ if not .Metadata.Expires.IsZero }}<div><b>Expires</b>: {{ .Metadata.Expires.Format "2006-01-02 15:04 MST" }}</div>{{ end }}
+            </div>
+            <p>Revealing the secret uses up one view.</p>
+            <input type="hidden" name="id" value="{{ .Metadata.ID }}" />
+            <div><label class="text-sm font-medium">
+                <p class="">Password</p>
+              </label>
+              <div class="relative mt-1"><input type="password" id="password" name="password" autocomplete="off"
+                  class="w-full p-3 text-sm shadow-sm border border-gray-200 rounded-global dark:bg-slate-900 dark:border-gray-700" /></div>
+            </div>
+            {{ if .Metadata.Passphrase }}
+            <div><label class="text-sm font-medium">
+                <p class="">Passphrase</p>
+              </label>
+              <div class="relative mt-1"><input type="password" id="passphrase" name="passphrase" autocomplete="off"
+                  class="w-full p-3 text-sm shadow-sm border border-gray-200 rounded-global dark:bg-slate-900 dark:border-gray-700" /></div>
+            </div>
+            {{ end }}
+            <button
+              class="block w-full px-5 py-3 text-sm font-medium text-white bg-primary-500 rounded-global mt-3 hover:bg-primary-700"
+              type="submit">Reveal</button>
+          </form>
+        {{ end }}
+      </div>
+    </div>
+  </div>
+</body>
+</html>
+`
+
+// isPreviewBot returns true if the user agent belongs to a link previewer or scanner
+func isPreviewBot(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	for _, v := range previewBots {
+		if strings.Contains(ua, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// revealSecret renders the page for a shared link. It only shows metadata, the secret
+// is looked up when the recipient explicitly posts the reveal form.
+func (s *Server) revealSecret(w http.ResponseWriter, r *http.Request) error {
+	page, err := template.New("reveal").Parse(revealPage)
+	if err != nil {
+		return err
+	}
+
+	data := struct {
+		Bot      bool
+		Err      string
+		Metadata secrets.Metadata
+	}{
+		Bot: isPreviewBot(r.UserAgent()),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if !data.Bot {
+		data.Metadata, err = secrets.GetMetadata(clientContext(r), mux.Vars(r)["id"], s.Backend)
+		if err != nil {
+			rerr := getErrorDetails(err)
+			w.WriteHeader(rerr.Code())
+			data.Err = rerr.Body()
+		}
+	}
+
+	return page.Execute(w, data)
+}
@@ -0,0 +1,38 @@
+// The page is served with a Content-Security-Policy that only allows scripts from
+// /static, so the behaviour of the modals is wired up here with data attributes
+// instead of inline handlers.
+(function () {
+  document.addEventListener("DOMContentLoaded", function () {
+    const params = new URLSearchParams(window.location.search);
+    const id = document.getElementById("id");
+    if (id && params.get("id")) {
+      id.value = params.get("id");
+    }
+  });
+
+  document.addEventListener("click", function (evt) {
+    const close = evt.target.closest("[data-close-modal]");
+    if (close) {
+      const modal = close.closest("#modal");
+      modal.addEventListener("animationend", function () { modal.remove(); }, { once: true });
+      modal.classList.add("closing");
+      return;
+    }
+
+    const show = evt.target.closest("[data-show]");
+    if (show) {
+      document.querySelector(show.dataset.show).hidden = false;
+      show.hidden = true;
+      return;
+    }
+
+    const copy = evt.target.closest("[data-copy]");
+    if (copy) {
+      navigator.clipboard.writeText(document.querySelector(copy.dataset.copy).innerText).then(function () {
+        const confirmation = document.getElementById("copyConfirmation");
+        confirmation.innerText = copy.dataset.copyMessage;
+        confirmation.hidden = false;
+      });
+    }
+  });
+})();
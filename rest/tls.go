@@ -0,0 +1,261 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/CoverWhale/logr"
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay waits for a certificate and key written one after the other to both be
+// on disk before loading them
+const reloadDelay = 250 * time.Millisecond
+
+// TLSOptions configures TLS for the server. Clients must present a certificate signed
+// by the client CA if ClientCAFile is set.
+type TLSOptions struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	// MinVersion is 1.2 or 1.3, 1.2 if empty.
+	MinVersion string
+	// CipherSuites are the names of the TLS 1.2 cipher suites to allow, the Go
+	// defaults if empty. TLS 1.3 suites aren't configurable.
+	CipherSuites []string
+}
+
+// CertReloader serves the certificate and client CA from disk and reloads them when
+// the files change.
+type CertReloader struct {
+	opts   TLSOptions
+	logger *logr.Logger
+	config *tls.Config
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// NewCertReloader loads the certificate and client CA and returns the reloader.
+func NewCertReloader(o TLSOptions, l *logr.Logger) (*CertReloader, error) {
+	if o.CertFile == "" || o.KeyFile == "" {
+		return nil, fmt.Errorf("a tls certificate and key are required")
+	}
+
+	version, err := tlsVersion(o.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphers, err := cipherSuites(o.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &CertReloader{opts: o, logger: l}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+
+	c.config = &tls.Config{
+		MinVersion:     version,
+		CipherSuites:   ciphers,
+		GetCertificate: c.getCertificate,
+	}
+
+	// the client certificate is verified against the current pool so a new CA is used
+	// without restarting
+	if o.ClientCAFile != "" {
+		c.config.ClientAuth = tls.RequireAnyClientCert
+		c.config.VerifyConnection = c.verifyClient
+	}
+
+	return c, nil
+}
+
+// Config returns the TLS configuration for the server.
+func (c *CertReloader) Config() *tls.Config {
+	return c.config
+}
+
+func (c *CertReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
+
+func (c *CertReloader) verifyClient(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("client certificate required")
+	}
+
+	c.mu.RLock()
+	roots := c.clientCAs
+	c.mu.RUnlock()
+
+	intermediates := x509.NewCertPool()
+	for _, v := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(v)
+	}
+
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	return err
+}
+
+// reload loads the files. On errors the current certificate and CA are kept.
+func (c *CertReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.opts.CertFile, c.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("error loading tls certificate: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if c.opts.ClientCAFile != "" {
+		data, err := os.ReadFile(c.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("error loading client ca: %w", err)
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in %s", c.opts.ClientCAFile)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	c.clientCAs = pool
+
+	return nil
+}
+
+// Watch reloads the files when they change until the context is cancelled. The
+// directories are watched instead of the files so replacing a file by renaming, as
+// editors and Kubernetes secret mounts do, is seen.
+func (c *CertReloader) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	dirs := map[string]bool{}
+	for _, v := range []string{c.opts.CertFile, c.opts.KeyFile, c.opts.ClientCAFile} {
+		if v == "" {
+			continue
+		}
+		dir := filepath.Dir(v)
+		if dirs[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			return fmt.Errorf("error watching %s: %w", dir, err)
+		}
+		dirs[dir] = true
+	}
+
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Chmod) && !event.Has(fsnotify.Write) {
+				continue
+			}
+			timer.Reset(reloadDelay)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			c.logger.Errorf("error watching tls files: %v", err)
+		case <-timer.C:
+			if err := c.reload(); err != nil {
+				c.logger.Errorf("error reloading tls files, keeping the current certificate: %v", err)
+				continue
+			}
+			c.logger.Info("reloaded tls certificate")
+		}
+	}
+}
+
+func tlsVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported minimum tls version %s, must be 1.2 or 1.3", v)
+	}
+}
+
+// cipherSuites returns the IDs of the named cipher suites. Only the suites Go considers
+// secure are allowed.
+func cipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	suites := map[string]uint16{}
+	for _, v := range tls.CipherSuites() {
+		suites[v.Name] = v.ID
+	}
+
+	var ids []uint16
+	var errs []error
+	for _, v := range names {
+		id, ok := suites[v]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unsupported cipher suite %s", v))
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, errors.Join(errs...)
+}
+
+// WithTLS serves HTTPS with the configuration.
+func WithTLS(c *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.Router.TLSConfig = c
+	}
+}
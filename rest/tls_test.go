@@ -0,0 +1,350 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CoverWhale/logr"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert creates a certificate signed by parent, or a self signed CA if parent is nil
+func newTestCert(t *testing.T, serial int64, parent *testCert, usage x509.ExtKeyUsage) testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "gophemeral test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = nil
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	cert, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servingSerial returns the serial number of the certificate the reloader serves
+func servingSerial(t *testing.T, c *CertReloader) int64 {
+	t.Helper()
+
+	cert, err := c.Config().GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return leaf.SerialNumber.Int64()
+}
+
+func newTestReloader(t *testing.T, o TLSOptions, server testCert) (*CertReloader, TLSOptions) {
+	t.Helper()
+
+	dir := t.TempDir()
+	o.CertFile = filepath.Join(dir, "tls.crt")
+	o.KeyFile = filepath.Join(dir, "tls.key")
+	writeFile(t, o.CertFile, server.certPEM)
+	writeFile(t, o.KeyFile, server.keyPEM)
+
+	c, err := NewCertReloader(o, logr.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, o
+}
+
+func TestCertReloaderWatch(t *testing.T) {
+	ca := newTestCert(t, 1, nil, 0)
+	c, o := newTestReloader(t, TLSOptions{}, newTestCert(t, 2, &ca, x509.ExtKeyUsageServerAuth))
+
+	if serial := servingSerial(t, c); serial != 2 {
+		t.Fatalf("expected certificate 2 but got %d", serial)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Watch(ctx)
+
+	// give the watcher time to start before the files change
+	time.Sleep(100 * time.Millisecond)
+	renewed := newTestCert(t, 3, &ca, x509.ExtKeyUsageServerAuth)
+	writeFile(t, o.KeyFile, renewed.keyPEM)
+	writeFile(t, o.CertFile, renewed.certPEM)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for servingSerial(t, c) != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the renewed certificate to be served")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestCertReloaderKeepsCertOnError(t *testing.T) {
+	ca := newTestCert(t, 1, nil, 0)
+	c, o := newTestReloader(t, TLSOptions{}, newTestCert(t, 2, &ca, x509.ExtKeyUsageServerAuth))
+
+	writeFile(t, o.CertFile, []byte("not a certificate"))
+	if err := c.reload(); err == nil {
+		t.Fatalf("expected an error reloading an invalid certificate")
+	}
+
+	if serial := servingSerial(t, c); serial != 2 {
+		t.Errorf("expected the current certificate to be kept but got %d", serial)
+	}
+
+	// a key that doesn't match the certificate is also rejected
+	other := newTestCert(t, 3, &ca, x509.ExtKeyUsageServerAuth)
+	writeFile(t, o.CertFile, other.certPEM)
+	writeFile(t, o.KeyFile, newTestCert(t, 4, &ca, x509.ExtKeyUsageServerAuth).keyPEM)
+	if err := c.reload(); err == nil {
+		t.Fatalf("expected an error reloading a mismatched key")
+	}
+
+	if serial := servingSerial(t, c); serial != 2 {
+		t.Errorf("expected the current certificate to be kept but got %d", serial)
+	}
+}
+
+// handshake connects a client with the certificates to the server config
+func handshake(t *testing.T, config *tls.Config, roots *x509.CertPool, certs ...tls.Certificate) error {
+	t.Helper()
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.(*tls.Conn).Handshake(); err == nil {
+			conn.Write([]byte("ok"))
+		}
+	}()
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", l.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: certs})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// TLS 1.3 clients finish the handshake before the server verifies them, so the
+	// result is only known after reading from the server
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(conn, buf)
+	return err
+}
+
+func TestCertReloaderClientCA(t *testing.T) {
+	ca := newTestCert(t, 1, nil, 0)
+	otherCA := newTestCert(t, 10, nil, 0)
+	server := newTestCert(t, 2, &ca, x509.ExtKeyUsageServerAuth)
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.certPEM)
+	c, _ := newTestReloader(t, TLSOptions{ClientCAFile: caFile}, server)
+
+	if c.Config().ClientAuth != tls.RequireAnyClientCert || c.Config().VerifyConnection == nil {
+		t.Fatalf("expected client certificates to be required and verified")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := newTestCert(t, 3, &ca, x509.ExtKeyUsageClientAuth)
+
+	tt := []struct {
+		name  string
+		certs []tls.Certificate
+		valid bool
+	}{
+		{name: "signed by ca", certs: []tls.Certificate{client.tlsCertificate(t)}, valid: true},
+		{name: "no certificate"},
+		{name: "other ca", certs: []tls.Certificate{newTestCert(t, 11, &otherCA, x509.ExtKeyUsageClientAuth).tlsCertificate(t)}},
+		{name: "server usage", certs: []tls.Certificate{newTestCert(t, 4, &ca, x509.ExtKeyUsageServerAuth).tlsCertificate(t)}},
+		{name: "self signed", certs: []tls.Certificate{otherCA.tlsCertificate(t)}},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			err := handshake(t, c.Config(), roots, v.certs...)
+			if v.valid && err != nil {
+				t.Errorf("expected handshake to succeed: %v", err)
+			}
+			if !v.valid && err == nil {
+				t.Errorf("expected handshake to fail")
+			}
+		})
+	}
+
+	t.Run("reloaded ca", func(t *testing.T) {
+		writeFile(t, caFile, otherCA.certPEM)
+		if err := c.reload(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := handshake(t, c.Config(), roots, client.tlsCertificate(t)); err == nil {
+			t.Errorf("expected a client of the old ca to be rejected")
+		}
+
+		other := newTestCert(t, 12, &otherCA, x509.ExtKeyUsageClientAuth)
+		if err := handshake(t, c.Config(), roots, other.tlsCertificate(t)); err != nil {
+			t.Errorf("expected a client of the new ca to be accepted: %v", err)
+		}
+	})
+}
+
+func TestTLSOptions(t *testing.T) {
+	tt := []struct {
+		name    string
+		version string
+		ciphers []string
+		min     uint16
+		valid   bool
+	}{
+		{name: "defaults", min: tls.VersionTLS12, valid: true},
+		{name: "1.2", version: "1.2", min: tls.VersionTLS12, valid: true},
+		{name: "1.3", version: "1.3", min: tls.VersionTLS13, valid: true},
+		{name: "1.1", version: "1.1"},
+		{name: "unknown version", version: "tls13"},
+		{name: "ciphers", ciphers: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"}, min: tls.VersionTLS12, valid: true},
+		{name: "unknown cipher", ciphers: []string{"TLS_NOPE"}},
+		{name: "insecure cipher", ciphers: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{name: "one bad cipher", ciphers: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_NOPE"}},
+	}
+
+	ca := newTestCert(t, 1, nil, 0)
+	server := newTestCert(t, 2, &ca, x509.ExtKeyUsageServerAuth)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeFile(t, certFile, server.certPEM)
+	writeFile(t, keyFile, server.keyPEM)
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			c, err := NewCertReloader(TLSOptions{CertFile: certFile, KeyFile: keyFile, MinVersion: v.version, CipherSuites: v.ciphers}, logr.NewLogger())
+			if !v.valid {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if c.Config().MinVersion != v.min {
+				t.Errorf("expected min version %x but got %x", v.min, c.Config().MinVersion)
+			}
+
+			if len(c.Config().CipherSuites) != len(v.ciphers) {
+				t.Errorf("expected %d cipher suites but got %d", len(v.ciphers), len(c.Config().CipherSuites))
+			}
+		})
+	}
+
+	if _, err := NewCertReloader(TLSOptions{CertFile: certFile}, logr.NewLogger()); err == nil {
+		t.Errorf("expected an error without a key")
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/hooksie1/gophemeral/secrets"
+)
+
+// CreateSecretRequest is the body to create a secret. If Generate is set the text is
+// generated by the server.
+type CreateSecretRequest struct {
+	Text          string                  `json:"text,omitempty"`
+	Views         int                     `json:"views"`
+	Fields        []secrets.Field         `json:"fields,omitempty"`
+	TTL           int                     `json:"ttl,omitempty" jsonschema:"description=Seconds until the secret expires"`
+	Description   string                  `json:"description,omitempty"`
+	Passphrase    string                  `json:"passphrase,omitempty"`
+	Recipients    []string                `json:"recipients,omitempty"`
+	Generate      *secrets.GeneratePolicy `json:"generate,omitempty"`
+	Webhook       string                  `json:"webhook,omitempty"`
+	WebhookSecret string                  `json:"webhook_secret,omitempty"`
+	Canary        bool                    `json:"canary,omitempty"`
+	Decoy         string                  `json:"decoy,omitempty"`
+}
+
+// Secret returns the secret to create from the request.
+func (c CreateSecretRequest) Secret() secrets.Secret {
+	return secrets.Secret{
+		Text:          c.Text,
+		Views:         c.Views,
+		Fields:        c.Fields,
+		TTL:           c.TTL,
+		Description:   c.Description,
+		Passphrase:    c.Passphrase,
+		Recipients:    c.Recipients,
+		Webhook:       c.Webhook,
+		WebhookSecret: c.WebhookSecret,
+		Canary:        c.Canary,
+		Decoy:         c.Decoy,
+	}
+}
+
+// CreateSecretResponse is returned when a secret is created.
+type CreateSecretResponse struct {
+	ID       string `json:"id"`
+	Password string `json:"password"`
+	Link     string `json:"link"`
+}
+
+// RevealSecretRequest is the body to reveal a secret.
+type RevealSecretRequest struct {
+	Password   string `json:"password"`
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+// SecretResponse is a revealed secret. Views is the number of views left.
+type SecretResponse struct {
+	Text   string          `json:"text,omitempty"`
+	Fields []secrets.Field `json:"fields,omitempty"`
+	Views  int             `json:"views"`
+}
+
+// BatchRequest is the body to create many secrets.
+type BatchRequest struct {
+	Secrets []CreateSecretRequest `json:"secrets"`
+}
+
+// BatchResponse holds the result of every secret of a batch in request order.
+type BatchResponse struct {
+	Results []secrets.BatchResult `json:"results"`
+}
+
+// v1Routes adds the versioned API to the router
+func (s *Server) v1Routes(router *mux.Router) {
+	v1 := router.PathPrefix("/api/v1").Subrouter()
+	v1.Handle("/secrets", http.HandlerFunc(errHandlers(s.authorize(secrets.ScopeCreate, s.createSecretV1)))).Methods("POST")
+	v1.Handle("/secrets/batch", http.HandlerFunc(errHandlers(s.authorize(secrets.ScopeCreate, s.createSecretsV1)))).Methods("POST")
+	v1.Handle("/secrets/{id}", http.HandlerFunc(errHandlers(s.authorize(secrets.ScopeStatus, s.secretInfoV1)))).Methods("GET")
+	v1.Handle("/secrets/{id}/reveal", http.HandlerFunc(errHandlers(s.authorize(secrets.ScopeLookup, s.revealSecretV1)))).Methods("POST")
+	v1.Handle("/health", http.HandlerFunc(getHealth)).Methods("GET")
+	v1.Handle("/openapi.json", http.HandlerFunc(errHandlers(serveOpenAPI))).Methods("GET")
+}
+
+// decodeStrict decodes the JSON body into v rejecting unknown fields
+func decodeStrict(r io.Reader, v any) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badRequest(err)
+	}
+
+	return nil
+}
+
+// writeJSON writes the response as JSON with the status code
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		return fmt.Errorf("error encoding json data: %s", err)
+	}
+
+	return nil
+}
+
+func (s *Server) createSecretV1(w http.ResponseWriter, r *http.Request) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	var req CreateSecretRequest
+	if err := decodeStrict(bytes.NewReader(body), &req); err != nil {
+		return err
+	}
+
+	record, err := s.createOnce(r, body, func(ctx context.Context) (secrets.Secret, error) {
+		if req.Generate != nil {
+			return secrets.GenerateSecret(ctx, s.Backend, req.Secret(), *req.Generate)
+		}
+		return secrets.AddSecret(ctx, s.Backend, req.Secret())
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, CreateSecretResponse{
+		ID:       record.ID,
+		Password: record.Password,
+		Link:     shareLink(r, record.ID),
+	})
+}
+
+func (s *Server) createSecretsV1(w http.ResponseWriter, r *http.Request) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	var req BatchRequest
+	if err := decodeStrict(bytes.NewReader(body), &req); err != nil {
+		return err
+	}
+
+	results, err := s.batch(r, body, req.Secrets)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, BatchResponse{Results: results})
+}
+
+func (s *Server) secretInfoV1(w http.ResponseWriter, r *http.Request) error {
+	metadata, err := secrets.GetMetadata(clientContext(r), mux.Vars(r)["id"], s.Backend)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, metadata)
+}
+
+func (s *Server) revealSecretV1(w http.ResponseWriter, r *http.Request) error {
+	var req RevealSecretRequest
+	if err := decodeStrict(r.Body, &req); err != nil {
+		return err
+	}
+
+	record, err := secrets.GetSecret(clientContext(r), secrets.Secret{
+		ID:         mux.Vars(r)["id"],
+		Password:   req.Password,
+		Passphrase: req.Passphrase,
+	}, s.Backend)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, SecretResponse{
+		Text:   record.Text,
+		Fields: record.Fields,
+		Views:  record.Views,
+	})
+}
@@ -0,0 +1,480 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// ScopeCreate allows creating secrets.
+	ScopeCreate = "create"
+	// ScopeLookup allows revealing secrets.
+	ScopeLookup = "lookup"
+	// ScopeStatus allows reading the metadata of secrets.
+	ScopeStatus = "status"
+	// ScopeAdmin allows everything.
+	ScopeAdmin = "admin"
+
+	// APIKeyPrefix starts every API key so they are easy to spot in code and logs.
+	APIKeyPrefix = "gph_"
+	// DefaultAPIKeyBucket is the KV bucket API keys are stored in.
+	DefaultAPIKeyBucket = "apikeys"
+	// AuthorizationHeader holds the API key as a bearer token over HTTP and NATS.
+	AuthorizationHeader = "Authorization"
+
+	apiKeyIDLength     = 12
+	apiKeySecretLength = 32
+	// usageTTL is how long daily usage counters are kept
+	usageTTL = 48 * time.Hour
+)
+
+// Scopes are all scopes an API key can have.
+var Scopes = []string{ScopeCreate, ScopeLookup, ScopeStatus, ScopeAdmin}
+
+var (
+	ErrAPIKeyExists   = errors.New("api key already exists")
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
+
+// APIKey is an issued API key. Only a hash of the key is stored, the key itself is
+// shown once when it is created.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Hash       string     `json:"hash"`
+	Scopes     []string   `json:"scopes"`
+	RateLimit  int        `json:"rate_limit,omitempty"`
+	DailyQuota int        `json:"daily_quota,omitempty"`
+	Profile    string     `json:"profile,omitempty"`
+	Tenant     string     `json:"tenant,omitempty"`
+	Created    time.Time  `json:"created"`
+	Revoked    *time.Time `json:"revoked,omitempty"`
+}
+
+// HasScope returns true if the key has the scope. The admin scope has every scope.
+func (k APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, ScopeAdmin)
+}
+
+// APIKeyStore stores API keys and counts their daily usage.
+type APIKeyStore interface {
+	// Create stores the key, it returns ErrAPIKeyExists if the ID is already used.
+	Create(APIKey) error
+	// Get returns ErrAPIKeyNotFound if the key isn't stored.
+	Get(id string) (APIKey, error)
+	List() ([]APIKey, error)
+	Put(APIKey) error
+	// Use adds one to the usage of the key on day and returns the new count.
+	Use(id, day string) (int, error)
+}
+
+// NewAPIKey creates a key and returns the key to give to its owner. The key can't be
+// recovered after this.
+func NewAPIKey(k APIKey) (APIKey, string, error) {
+	for _, v := range k.Scopes {
+		if !slices.Contains(Scopes, v) {
+			return APIKey{}, "", fmt.Errorf("unknown scope %s", v)
+		}
+	}
+
+	if len(k.Scopes) == 0 {
+		return APIKey{}, "", fmt.Errorf("api keys need at least one scope")
+	}
+
+	if k.RateLimit < 0 || k.DailyQuota < 0 {
+		return APIKey{}, "", fmt.Errorf("rate limit and daily quota cannot be negative")
+	}
+
+	id, err := Base58IDs(apiKeyIDLength).NewID()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	secret := make([]byte, apiKeySecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return APIKey{}, "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+
+	k.ID = id
+	k.Hash = hashAPIKey(encoded)
+	k.Created = time.Now().UTC()
+	k.Revoked = nil
+
+	return k, APIKeyPrefix + id + "_" + encoded, nil
+}
+
+// parseAPIKey splits a key into its ID and secret
+func parseAPIKey(key string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(key, APIKeyPrefix)
+	if !ok {
+		return "", "", false
+	}
+
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || id == "" || secret == "" {
+		return "", "", false
+	}
+
+	return id, secret, true
+}
+
+// hashAPIKey returns the hex SHA-256 of the secret part of a key. Keys are random so
+// a fast hash is enough.
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticator checks API keys and enforces their scopes, rate limits and quotas.
+type Authenticator struct {
+	store     APIKeyStore
+	jwt       *JWTValidator
+	anonymous bool
+	notifier  any
+	now       func() time.Time
+
+	mu       sync.Mutex
+	limiters map[string]*rateLimiter
+}
+
+// AuthenticatorOption configures an Authenticator.
+type AuthenticatorOption func(*Authenticator)
+
+// WithJWTValidator accepts JWTs from an identity provider as bearer tokens in addition
+// to API keys.
+func WithJWTValidator(v *JWTValidator) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.jwt = v
+	}
+}
+
+// NewAuthenticator returns an authenticator for the keys in the store. The store may be
+// nil if only JWTs are accepted. If anonymous is true requests without a key are allowed.
+// Rejected requests over a limit are sent as rate limited events if the backend is a
+// notifier.
+func NewAuthenticator(store APIKeyStore, anonymous bool, backend any, opts ...AuthenticatorOption) *Authenticator {
+	a := &Authenticator{
+		store:     store,
+		anonymous: anonymous,
+		notifier:  backend,
+		now:       time.Now,
+		limiters:  map[string]*rateLimiter{},
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// Authenticate checks the value of the Authorization header for the scope. It returns
+// a copy of the context holding the key, with the key's profile and tenant applied.
+func (a *Authenticator) Authenticate(ctx context.Context, authorization, scope string) (context.Context, error) {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if authorization == "" || !ok {
+		if authorization == "" && a.anonymous {
+			return ctx, nil
+		}
+		return ctx, NewSecretError(http.StatusUnauthorized, "an api key is required")
+	}
+
+	token = strings.TrimSpace(token)
+	if a.jwt != nil && !strings.HasPrefix(token, APIKeyPrefix) {
+		return a.authenticateJWT(ctx, token, scope)
+	}
+
+	id, secret, ok := parseAPIKey(token)
+	if !ok || a.store == nil {
+		return ctx, NewSecretError(http.StatusUnauthorized, "invalid api key")
+	}
+
+	key, err := a.store.Get(id)
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return ctx, NewSecretError(http.StatusUnauthorized, "invalid api key")
+	}
+	if err != nil {
+		return ctx, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashAPIKey(secret))) != 1 || key.Revoked != nil {
+		return ctx, NewSecretError(http.StatusUnauthorized, "invalid api key")
+	}
+
+	ctx = WithAPIKey(ctx, key)
+
+	if !key.HasScope(scope) {
+		return ctx, NewSecretError(http.StatusForbidden, fmt.Sprintf("api key does not have the %s scope", scope))
+	}
+
+	if key.RateLimit > 0 && !a.limiter(key).allow(a.now()) {
+		notify(ctx, a.notifier, Secret{}, EventRateLimited)
+		return ctx, NewSecretError(http.StatusTooManyRequests, "api key rate limit exceeded")
+	}
+
+	if key.DailyQuota > 0 {
+		used, err := a.store.Use(key.ID, a.now().UTC().Format("2006-01-02"))
+		if err != nil {
+			return ctx, err
+		}
+		if used > key.DailyQuota {
+			notify(ctx, a.notifier, Secret{}, EventRateLimited)
+			return ctx, NewSecretError(http.StatusTooManyRequests, "api key daily quota exceeded")
+		}
+	}
+
+	return ctx, nil
+}
+
+// authenticateJWT checks a token from the identity provider. Tokens can use every scope
+// except admin, which needs an API key.
+func (a *Authenticator) authenticateJWT(ctx context.Context, token, scope string) (context.Context, error) {
+	id, err := a.jwt.Validate(token)
+	if err != nil {
+		return ctx, NewSecretError(http.StatusUnauthorized, fmt.Sprintf("invalid token: %v", err))
+	}
+
+	ctx = WithIdentity(ctx, id)
+	if scope == ScopeAdmin {
+		return ctx, NewSecretError(http.StatusForbidden, "the admin scope requires an api key")
+	}
+
+	return ctx, nil
+}
+
+func (a *Authenticator) limiter(k APIKey) *rateLimiter {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	l, ok := a.limiters[k.ID]
+	if !ok || l.limit != k.RateLimit {
+		l = newRateLimiter(k.RateLimit, a.now())
+		a.limiters[k.ID] = l
+	}
+
+	return l
+}
+
+// rateLimiter is a token bucket refilled with limit tokens per minute
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limit int, now time.Time) *rateLimiter {
+	return &rateLimiter{limit: limit, tokens: float64(limit), last: now}
+}
+
+func (r *rateLimiter) allow(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tokens = min(float64(r.limit), r.tokens+now.Sub(r.last).Minutes()*float64(r.limit))
+	r.last = now
+	if r.tokens < 1 {
+		return false
+	}
+
+	r.tokens--
+	return true
+}
+
+type apiKeyKey struct{}
+
+// WithAPIKey returns a copy of the context holding the key. The key's profile is
+// selected and its tenant and ID are added to the client information. The key's name
+// is recorded as the creator of secrets.
+func WithAPIKey(ctx context.Context, k APIKey) context.Context {
+	ctx = context.WithValue(ctx, apiKeyKey{}, k)
+	if k.Profile != "" {
+		ctx = WithProfile(ctx, k.Profile)
+	}
+
+	client := ClientFromContext(ctx)
+	client.APIKey = k.ID
+	client.Creator = "apikey:" + cmp.Or(k.Name, k.ID)
+	if k.Tenant != "" {
+		client.Tenant = k.Tenant
+	}
+
+	return WithClient(ctx, client)
+}
+
+// APIKeyFromContext returns the key the request was authenticated with.
+func APIKeyFromContext(ctx context.Context) (APIKey, bool) {
+	k, ok := ctx.Value(apiKeyKey{}).(APIKey)
+	return k, ok
+}
+
+// NATSAPIKeyStore stores API keys in a KV bucket. Daily usage is counted in a second
+// bucket whose entries expire.
+type NATSAPIKeyStore struct {
+	kv    nats.KeyValue
+	usage nats.KeyValue
+}
+
+// NewNATSAPIKeyStore returns a store for the bucket, creating the buckets if needed.
+func NewNATSAPIKeyStore(js nats.JetStreamContext, bucket string) (*NATSAPIKeyStore, error) {
+	kv, err := keyValue(js, &nats.KeyValueConfig{
+		Bucket:      bucket,
+		Description: "gophemeral api keys",
+		Storage:     nats.FileStorage,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := keyValue(js, &nats.KeyValueConfig{
+		Bucket:      bucket + "_usage",
+		Description: "gophemeral api key daily usage",
+		TTL:         usageTTL,
+		Storage:     nats.FileStorage,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &NATSAPIKeyStore{kv: kv, usage: usage}, nil
+}
+
+// keyValue returns the bucket, creating it if it doesn't exist
+func keyValue(js nats.JetStreamContext, config *nats.KeyValueConfig) (nats.KeyValue, error) {
+	kv, err := js.KeyValue(config.Bucket)
+	if err != nil && errors.Is(err, nats.ErrBucketNotFound) {
+		return js.CreateKeyValue(config)
+	}
+
+	return kv, err
+}
+
+func (n *NATSAPIKeyStore) Create(k APIKey) error {
+	data, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+
+	_, err = n.kv.Create(k.ID, data)
+	if err != nil && errors.Is(err, nats.ErrKeyExists) {
+		return ErrAPIKeyExists
+	}
+
+	return err
+}
+
+func (n *NATSAPIKeyStore) Get(id string) (APIKey, error) {
+	var k APIKey
+	entry, err := n.kv.Get(id)
+	if err != nil && (errors.Is(err, nats.ErrKeyNotFound) || errors.Is(err, nats.ErrInvalidKey)) {
+		return k, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return k, err
+	}
+
+	err = json.Unmarshal(entry.Value(), &k)
+	return k, err
+}
+
+func (n *NATSAPIKeyStore) List() ([]APIKey, error) {
+	ids, err := n.kv.Keys()
+	if err != nil && errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]APIKey, 0, len(ids))
+	for _, v := range ids {
+		k, err := n.Get(v)
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+func (n *NATSAPIKeyStore) Put(k APIKey) error {
+	data, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+
+	_, err = n.kv.Put(k.ID, data)
+	return err
+}
+
+// Use increments the counter with compare and swap so every instance of the service
+// shares the quota.
+func (n *NATSAPIKeyStore) Use(id, day string) (int, error) {
+	key := id + "." + day
+
+	for {
+		entry, err := n.usage.Get(key)
+		if err != nil && errors.Is(err, nats.ErrKeyNotFound) {
+			if _, err := n.usage.Create(key, []byte("1")); err == nil {
+				return 1, nil
+			} else if !errors.Is(err, nats.ErrKeyExists) {
+				return 0, err
+			}
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		count, err := strconv.Atoi(string(entry.Value()))
+		if err != nil {
+			return 0, err
+		}
+		count++
+
+		_, err = n.usage.Update(key, []byte(strconv.Itoa(count)), entry.Revision())
+		if err == nil {
+			return count, nil
+		}
+		if !errors.Is(err, nats.ErrKeyExists) {
+			return 0, err
+		}
+	}
+}
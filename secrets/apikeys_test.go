@@ -0,0 +1,209 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type memoryAPIKeyStore struct {
+	keys  map[string]APIKey
+	usage map[string]int
+}
+
+func newMemoryAPIKeyStore() *memoryAPIKeyStore {
+	return &memoryAPIKeyStore{keys: map[string]APIKey{}, usage: map[string]int{}}
+}
+
+func (m *memoryAPIKeyStore) Create(k APIKey) error {
+	if _, ok := m.keys[k.ID]; ok {
+		return ErrAPIKeyExists
+	}
+	m.keys[k.ID] = k
+	return nil
+}
+
+func (m *memoryAPIKeyStore) Get(id string) (APIKey, error) {
+	k, ok := m.keys[id]
+	if !ok {
+		return k, ErrAPIKeyNotFound
+	}
+	return k, nil
+}
+
+func (m *memoryAPIKeyStore) List() ([]APIKey, error) {
+	var keys []APIKey
+	for _, v := range m.keys {
+		keys = append(keys, v)
+	}
+	return keys, nil
+}
+
+func (m *memoryAPIKeyStore) Put(k APIKey) error {
+	m.keys[k.ID] = k
+	return nil
+}
+
+func (m *memoryAPIKeyStore) Use(id, day string) (int, error) {
+	m.usage[id+"."+day]++
+	return m.usage[id+"."+day], nil
+}
+
+func issueKey(t *testing.T, store *memoryAPIKeyStore, k APIKey) string {
+	t.Helper()
+	k, token, err := NewAPIKey(k)
+	if err != nil {
+		t.Fatalf("error creating key: %v", err)
+	}
+	if err := store.Create(k); err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + token
+}
+
+func TestAuthenticate(t *testing.T) {
+	store := newMemoryAPIKeyStore()
+	creator := issueKey(t, store, APIKey{Name: "creator", Scopes: []string{ScopeCreate}})
+	admin := issueKey(t, store, APIKey{Name: "admin", Scopes: []string{ScopeAdmin}})
+	revoked := issueKey(t, store, APIKey{Name: "revoked", Scopes: []string{ScopeCreate}})
+	for id, k := range store.keys {
+		if k.Name == "revoked" {
+			now := time.Now()
+			k.Revoked = &now
+			store.keys[id] = k
+		}
+	}
+
+	tt := []struct {
+		name      string
+		anonymous bool
+		header    string
+		scope     string
+		status    int
+	}{
+		{name: "anonymous allowed", anonymous: true, scope: ScopeCreate},
+		{name: "anonymous denied", scope: ScopeCreate, status: http.StatusUnauthorized},
+		{name: "scope", header: creator, scope: ScopeCreate},
+		{name: "missing scope", header: creator, scope: ScopeLookup, status: http.StatusForbidden},
+		{name: "admin", header: admin, scope: ScopeLookup},
+		{name: "revoked", anonymous: true, header: revoked, scope: ScopeCreate, status: http.StatusUnauthorized},
+		{name: "wrong secret", anonymous: true, header: creator + "x", scope: ScopeCreate, status: http.StatusUnauthorized},
+		{name: "not bearer", anonymous: true, header: "Basic abc", scope: ScopeCreate, status: http.StatusUnauthorized},
+		{name: "malformed", header: "Bearer nope", scope: ScopeCreate, status: http.StatusUnauthorized},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			a := NewAuthenticator(store, v.anonymous, nil)
+			_, err := a.Authenticate(context.Background(), v.header, v.scope)
+			if v.status == 0 {
+				if err != nil {
+					t.Errorf("expected no error but got %v", err)
+				}
+				return
+			}
+
+			rerr, _ := ErrorFrom(err)
+			if rerr.Code() != v.status {
+				t.Errorf("expected %d but got %v", v.status, err)
+			}
+		})
+	}
+}
+
+func TestAPIKeyContext(t *testing.T) {
+	store := newMemoryAPIKeyStore()
+	header := issueKey(t, store, APIKey{Scopes: []string{ScopeCreate}, Profile: "strict", Tenant: "acme"})
+
+	ctx := WithClient(context.Background(), Client{IP: "127.0.0.1"})
+	ctx, err := NewAuthenticator(store, false, nil).Authenticate(ctx, header, ScopeCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ProfileFromContext(ctx) != "strict" {
+		t.Errorf("expected strict profile but got %s", ProfileFromContext(ctx))
+	}
+
+	client := ClientFromContext(ctx)
+	if client.Tenant != "acme" || client.APIKey == "" || client.IP != "127.0.0.1" {
+		t.Errorf("unexpected client %+v", client)
+	}
+}
+
+func TestAPIKeyLimits(t *testing.T) {
+	store := newMemoryAPIKeyStore()
+	b := newMemoryBackend()
+	limited := issueKey(t, store, APIKey{Scopes: []string{ScopeCreate}, RateLimit: 2})
+	quota := issueKey(t, store, APIKey{Scopes: []string{ScopeCreate}, DailyQuota: 1})
+
+	now := time.Now()
+	a := NewAuthenticator(store, false, b)
+	a.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := a.Authenticate(context.Background(), limited, ScopeCreate); err != nil {
+			t.Fatalf("expected request %d to be allowed but got %v", i, err)
+		}
+	}
+
+	_, err := a.Authenticate(context.Background(), limited, ScopeCreate)
+	if rerr, _ := ErrorFrom(err); rerr.Code() != http.StatusTooManyRequests {
+		t.Errorf("expected 429 but got %v", err)
+	}
+
+	now = now.Add(30 * time.Second)
+	if _, err := a.Authenticate(context.Background(), limited, ScopeCreate); err != nil {
+		t.Errorf("expected token to be refilled but got %v", err)
+	}
+
+	if _, err := a.Authenticate(context.Background(), quota, ScopeCreate); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = a.Authenticate(context.Background(), quota, ScopeCreate)
+	if rerr, _ := ErrorFrom(err); rerr.ErrorCode() != CodeRateLimited {
+		t.Errorf("expected quota to be exceeded but got %v", err)
+	}
+
+	if len(b.events) != 2 || b.events[0].Type != EventRateLimited {
+		t.Errorf("expected two rate limited events but got %v", b.eventTypes())
+	}
+}
+
+func TestNewAPIKey(t *testing.T) {
+	k, token, err := NewAPIKey(APIKey{Scopes: []string{ScopeCreate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, secret, ok := parseAPIKey(token)
+	if !ok || id != k.ID || hashAPIKey(secret) != k.Hash {
+		t.Errorf("expected token %s to match key %+v", token, k)
+	}
+
+	if _, _, err := NewAPIKey(APIKey{Scopes: []string{"delete"}}); err == nil {
+		t.Error("expected unknown scope to fail")
+	}
+
+	if _, _, err := NewAPIKey(APIKey{}); err == nil {
+		t.Error("expected key without scopes to fail")
+	}
+}
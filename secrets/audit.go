@@ -0,0 +1,423 @@
+/*
+Copyright © 2024 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CoverWhale/logr"
+	"github.com/hooksie1/gophemeral/metrics"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// DefaultAuditStream is the name of the JetStream stream holding the audit log.
+	DefaultAuditStream = "GOPHEMERAL_AUDIT"
+	// DefaultAuditSubject is the subject audit entries are stored on.
+	DefaultAuditSubject = "gophemeral.audit"
+	// DefaultAuditQueueSize is how many events wait to be written before new ones are dropped.
+	DefaultAuditQueueSize = 1024
+
+	auditHeadKey = "head"
+)
+
+var ErrAuditConflict = errors.New("audit log was appended to concurrently")
+
+var auditDropped = metrics.NewCounter("gophemeral_audit_dropped_total", "Audit events dropped because the queue was full")
+
+// AuditEntry is a single record in the audit log. Each entry holds the hash of the
+// previous entry so edits and deletions break the chain.
+type AuditEntry struct {
+	Seq      uint64    `json:"seq"`
+	Time     time.Time `json:"timestamp"`
+	Event    EventType `json:"event"`
+	ID       string    `json:"id"`
+	Views    int       `json:"views"`
+	Client   Client    `json:"client"`
+	PrevHash string    `json:"prev_hash"`
+	Hash     string    `json:"hash"`
+}
+
+// AuditHead is the sequence and hash of the newest entry. It is stored apart from the
+// entries so deleting the newest entries is detected.
+type AuditHead struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// ComputeHash returns the hash of the entry chained to the previous hash. With a key the
+// hash is an HMAC-SHA256, so entries can't be rewritten without the key. Without one it
+// is a plain SHA-256 that only detects accidental corruption and edits that aren't rehashed.
+func (a AuditEntry) ComputeHash(key string) string {
+	a.Hash = ""
+	data, _ := json.Marshal(a)
+
+	h := sha256.New()
+	if key != "" {
+		h = hmac.New(sha256.New, []byte(key))
+	}
+	h.Write([]byte(a.PrevHash))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AuditStore persists audit entries. Append must fail with ErrAuditConflict if the
+// entry's sequence isn't the next one in the store.
+type AuditStore interface {
+	Append(AuditEntry) error
+	Last() (AuditEntry, bool, error)
+	Entries() ([]AuditEntry, error)
+	// Head returns the stored head, or the zero head if there is none.
+	Head() (AuditHead, error)
+	// SetHead stores the head unless a newer one is already stored.
+	SetHead(AuditHead) error
+}
+
+// AuditConfig configures the audit log. Key is used to HMAC the entries.
+type AuditConfig struct {
+	Key       string
+	QueueSize int
+}
+
+// AuditLog is a notifier that writes every lifecycle event to the audit store. Events are
+// queued and written in order in the background, when the queue is full they are dropped.
+type AuditLog struct {
+	mu      sync.Mutex
+	store   AuditStore
+	key     string
+	logger  *logr.Logger
+	queue   chan Event
+	pending sync.WaitGroup
+	quit    chan struct{}
+	stop    sync.Once
+}
+
+func NewAuditLog(store AuditStore, c AuditConfig, logger *logr.Logger) *AuditLog {
+	if c.QueueSize <= 0 {
+		c.QueueSize = DefaultAuditQueueSize
+	}
+
+	a := &AuditLog{
+		store:  store,
+		key:    c.Key,
+		logger: logger,
+		queue:  make(chan Event, c.QueueSize),
+		quit:   make(chan struct{}),
+	}
+	go a.run()
+
+	return a
+}
+
+// Notify queues the event. It never blocks, if the queue is full the event is dropped.
+func (a *AuditLog) Notify(ctx context.Context, e Event) {
+	a.pending.Add(1)
+	select {
+	case a.queue <- e:
+	default:
+		a.pending.Done()
+		auditDropped.Inc()
+		a.logger.Errorf("audit queue is full, dropped %s event for %s", e.Type, e.ID)
+	}
+}
+
+// run writes the queued events until the audit log is closed
+func (a *AuditLog) run() {
+	for {
+		select {
+		case <-a.quit:
+			return
+		case e := <-a.queue:
+			if err := a.Append(e); err != nil {
+				a.logger.Errorf("error writing %s event for %s to audit log: %v", e.Type, e.ID, err)
+			}
+			a.pending.Done()
+		}
+	}
+}
+
+// Flush waits for the queued events to be written or for the context to be done.
+func (a *AuditLog) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		a.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops writing queued events. Call Flush first to write the events that are
+// still queued.
+func (a *AuditLog) Close() error {
+	a.stop.Do(func() { close(a.quit) })
+	return nil
+}
+
+// Append chains the event to the last entry in the store. If another writer appended
+// first the last entry is reloaded and the append is retried.
+func (a *AuditLog) Append(e Event) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for attempt := 0; attempt < 5; attempt++ {
+		last, _, err := a.store.Last()
+		if err != nil {
+			return err
+		}
+
+		entry := AuditEntry{
+			Seq:      last.Seq + 1,
+			Time:     e.Time,
+			Event:    e.Type,
+			ID:       e.ID,
+			Views:    e.Views,
+			Client:   e.Client,
+			PrevHash: last.Hash,
+		}
+		entry.Hash = entry.ComputeHash(a.key)
+
+		err = a.store.Append(entry)
+		if errors.Is(err, ErrAuditConflict) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		return a.store.SetHead(AuditHead{Seq: entry.Seq, Hash: entry.Hash})
+	}
+
+	return ErrAuditConflict
+}
+
+// VerifyAudit checks that the sequence numbers start at 1 and are contiguous, every
+// entry's hash is correct and chained to the entry before it and the newest entry
+// matches the stored head. The key must be the one the entries were written with. It
+// returns an error describing the first problem found.
+func VerifyAudit(entries []AuditEntry, head AuditHead, key string) error {
+	var prev AuditEntry
+	for i, v := range entries {
+		if i == 0 && v.Seq != 1 {
+			return fmt.Errorf("entries 1 to %d are missing", v.Seq-1)
+		}
+
+		if i == 0 && v.PrevHash != "" {
+			return fmt.Errorf("entry 1 has a previous hash")
+		}
+
+		if i > 0 {
+			if v.Seq != prev.Seq+1 {
+				return fmt.Errorf("entries %d to %d are missing", prev.Seq+1, v.Seq-1)
+			}
+
+			if v.PrevHash != prev.Hash {
+				return fmt.Errorf("entry %d is not chained to entry %d", v.Seq, prev.Seq)
+			}
+		}
+
+		if v.Hash != v.ComputeHash(key) {
+			return fmt.Errorf("entry %d has been modified", v.Seq)
+		}
+
+		prev = v
+	}
+
+	if head.Seq > prev.Seq {
+		return fmt.Errorf("entries %d to %d are missing from the end", prev.Seq+1, head.Seq)
+	}
+
+	if head.Seq < prev.Seq {
+		return fmt.Errorf("entry %d is newer than the head %d", prev.Seq, head.Seq)
+	}
+
+	if head.Seq > 0 && head.Hash != prev.Hash {
+		return fmt.Errorf("entry %d does not match the head", prev.Seq)
+	}
+
+	return nil
+}
+
+// NATSAuditStore stores the audit log in a JetStream stream. The entry sequence is the
+// stream sequence so concurrent writers are detected with an expected last sequence.
+type NATSAuditStore struct {
+	js      nats.JetStreamContext
+	kv      nats.KeyValue
+	stream  string
+	subject string
+}
+
+// NewNATSAuditStore returns an audit store for the stream, creating the stream if needed.
+// The head is stored in the <stream>_HEAD KV bucket.
+func NewNATSAuditStore(js nats.JetStreamContext, stream, subject string) (*NATSAuditStore, error) {
+	_, err := js.StreamInfo(stream)
+	if err != nil && errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(&nats.StreamConfig{
+			Name:        stream,
+			Description: "gophemeral audit log",
+			Subjects:    []string{subject},
+			Storage:     nats.FileStorage,
+			DenyDelete:  true,
+			DenyPurge:   true,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	kv, err := keyValue(js, &nats.KeyValueConfig{
+		Bucket:      stream + "_HEAD",
+		Description: "gophemeral audit log head",
+		Storage:     nats.FileStorage,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &NATSAuditStore{
+		js:      js,
+		kv:      kv,
+		stream:  stream,
+		subject: subject,
+	}, nil
+}
+
+func (n *NATSAuditStore) Append(a AuditEntry) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	_, err = n.js.Publish(n.subject, data, nats.ExpectLastSequence(a.Seq-1))
+	var apiErr *nats.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode == nats.JSErrCodeStreamWrongLastSequence {
+		return ErrAuditConflict
+	}
+
+	return err
+}
+
+func (n *NATSAuditStore) Last() (AuditEntry, bool, error) {
+	var a AuditEntry
+	msg, err := n.js.GetLastMsg(n.stream, n.subject)
+	if err != nil && errors.Is(err, nats.ErrMsgNotFound) {
+		return a, false, nil
+	}
+	if err != nil {
+		return a, false, err
+	}
+
+	if err := json.Unmarshal(msg.Data, &a); err != nil {
+		return a, false, err
+	}
+
+	return a, true, nil
+}
+
+// Entries returns every entry in the stream. Missing messages are skipped so they
+// show up as gaps when verifying.
+func (n *NATSAuditStore) Entries() ([]AuditEntry, error) {
+	info, err := n.js.StreamInfo(n.stream)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []AuditEntry
+	for seq := info.State.FirstSeq; seq > 0 && seq <= info.State.LastSeq; seq++ {
+		msg, err := n.js.GetMsg(n.stream, seq)
+		if err != nil && errors.Is(err, nats.ErrMsgNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var a AuditEntry
+		if err := json.Unmarshal(msg.Data, &a); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", seq, err)
+		}
+		entries = append(entries, a)
+	}
+
+	return entries, nil
+}
+
+func (n *NATSAuditStore) Head() (AuditHead, error) {
+	var h AuditHead
+	entry, err := n.kv.Get(auditHeadKey)
+	if err != nil && errors.Is(err, nats.ErrKeyNotFound) {
+		return h, nil
+	}
+	if err != nil {
+		return h, err
+	}
+
+	err = json.Unmarshal(entry.Value(), &h)
+	return h, err
+}
+
+// SetHead updates the head with a revision check so concurrent writers can't move it
+// back to an older entry.
+func (n *NATSAuditStore) SetHead(h AuditHead) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < 5; attempt++ {
+		entry, err := n.kv.Get(auditHeadKey)
+		if err != nil && errors.Is(err, nats.ErrKeyNotFound) {
+			_, err = n.kv.Create(auditHeadKey, data)
+			if errors.Is(err, nats.ErrKeyExists) {
+				continue
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		var current AuditHead
+		if err := json.Unmarshal(entry.Value(), &current); err != nil {
+			return err
+		}
+		if current.Seq >= h.Seq {
+			return nil
+		}
+
+		_, err = n.kv.Update(auditHeadKey, data, entry.Revision())
+		if errors.Is(err, nats.ErrKeyExists) {
+			continue
+		}
+		return err
+	}
+
+	return ErrAuditConflict
+}
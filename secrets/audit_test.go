@@ -0,0 +1,244 @@
+/*
+Copyright © 2024 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CoverWhale/logr"
+)
+
+type memoryAuditStore struct {
+	entries []AuditEntry
+	head    AuditHead
+}
+
+func (m *memoryAuditStore) Append(a AuditEntry) error {
+	if a.Seq != uint64(len(m.entries))+1 {
+		return ErrAuditConflict
+	}
+	m.entries = append(m.entries, a)
+	return nil
+}
+
+func (m *memoryAuditStore) Last() (AuditEntry, bool, error) {
+	if len(m.entries) == 0 {
+		return AuditEntry{}, false, nil
+	}
+
+	return m.entries[len(m.entries)-1], true, nil
+}
+
+func (m *memoryAuditStore) Entries() ([]AuditEntry, error) {
+	return append([]AuditEntry(nil), m.entries...), nil
+}
+
+func (m *memoryAuditStore) Head() (AuditHead, error) {
+	return m.head, nil
+}
+
+func (m *memoryAuditStore) SetHead(h AuditHead) error {
+	if h.Seq > m.head.Seq {
+		m.head = h
+	}
+	return nil
+}
+
+const testAuditKey = "audit-key"
+
+func newTestAuditLog(t *testing.T) *memoryAuditStore {
+	store := &memoryAuditStore{}
+	audit := NewAuditLog(store, AuditConfig{Key: testAuditKey}, logr.NewLogger())
+	defer audit.Close()
+	b := newMemoryBackend()
+	ctx := WithClient(context.Background(), Client{IP: "192.0.2.1"})
+
+	resp, err := AddSecret(ctx, b, Secret{Text: "test", Views: 2})
+	if err != nil {
+		t.Fatalf("error adding secret: %v", err)
+	}
+
+	GetSecret(ctx, Secret{ID: resp.ID, Password: generateString(24)}, b)
+	GetSecret(ctx, Secret{ID: resp.ID, Password: resp.Password}, b)
+	GetSecret(ctx, Secret{ID: resp.ID, Password: resp.Password}, b)
+
+	for _, v := range b.events {
+		audit.Notify(ctx, v)
+	}
+
+	if err := audit.Flush(ctx); err != nil {
+		t.Fatalf("error flushing audit log: %v", err)
+	}
+
+	return store
+}
+
+func TestVerifyAudit(t *testing.T) {
+	store := newTestAuditLog(t)
+
+	if len(store.entries) != 5 {
+		t.Fatalf("expected 5 audit entries but got %d", len(store.entries))
+	}
+
+	if err := VerifyAudit(store.entries, store.head, testAuditKey); err != nil {
+		t.Errorf("expected audit log to verify: %v", err)
+	}
+
+	if store.head.Seq != 5 || store.head.Hash != store.entries[4].Hash {
+		t.Errorf("expected head to be entry 5 but got %d", store.head.Seq)
+	}
+
+	if err := VerifyAudit(nil, AuditHead{}, testAuditKey); err != nil {
+		t.Errorf("expected empty audit log to verify: %v", err)
+	}
+}
+
+func TestVerifyAuditTampered(t *testing.T) {
+	tt := []struct {
+		name   string
+		tamper func([]AuditEntry) []AuditEntry
+	}{
+		{
+			name: "edited",
+			tamper: func(e []AuditEntry) []AuditEntry {
+				e[2].Client.IP = "198.51.100.1"
+				return e
+			},
+		},
+		{
+			name: "rehashed",
+			tamper: func(e []AuditEntry) []AuditEntry {
+				e[2].Views = 10
+				e[2].Hash = e[2].ComputeHash(testAuditKey)
+				return e
+			},
+		},
+
+		{
+			name: "deleted",
+			tamper: func(e []AuditEntry) []AuditEntry {
+				return append(e[:1], e[2:]...)
+			},
+		},
+		{
+			name: "deleted first entry",
+			tamper: func(e []AuditEntry) []AuditEntry {
+				return e[1:]
+			},
+		},
+		{
+			name: "deleted head",
+			tamper: func(e []AuditEntry) []AuditEntry {
+				return e[:4]
+			},
+		},
+		{
+			name: "deleted all",
+			tamper: func(e []AuditEntry) []AuditEntry {
+				return nil
+			},
+		},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			store := newTestAuditLog(t)
+			if err := VerifyAudit(v.tamper(store.entries), store.head, testAuditKey); err == nil {
+				t.Errorf("expected tampered audit log to fail verification")
+			}
+		})
+	}
+}
+
+func TestVerifyAuditKey(t *testing.T) {
+	store := newTestAuditLog(t)
+
+	// rewrite the whole chain and head like someone with write access but without the key
+	var prev string
+	for i := range store.entries {
+		store.entries[i].Client.IP = "198.51.100.1"
+		store.entries[i].PrevHash = prev
+		store.entries[i].Hash = store.entries[i].ComputeHash("")
+		prev = store.entries[i].Hash
+	}
+	head := AuditHead{Seq: 5, Hash: prev}
+
+	if err := VerifyAudit(store.entries, head, testAuditKey); err == nil {
+		t.Errorf("expected a chain rewritten without the key to fail verification")
+	}
+
+	if err := VerifyAudit(store.entries, head, ""); err != nil {
+		t.Errorf("expected an unkeyed chain to verify without a key: %v", err)
+	}
+
+	other := newTestAuditLog(t)
+	if err := VerifyAudit(other.entries, other.head, "other-key"); err == nil {
+		t.Errorf("expected verifying with the wrong key to fail")
+	}
+}
+
+func TestAuditQueueFull(t *testing.T) {
+	audit := &AuditLog{
+		store:  &memoryAuditStore{},
+		logger: logr.NewLogger(),
+		queue:  make(chan Event, 1),
+		quit:   make(chan struct{}),
+	}
+
+	dropped := auditDropped.Value()
+	for range 3 {
+		audit.Notify(context.Background(), Event{ID: "test", Type: EventViewed})
+	}
+
+	if auditDropped.Value() != dropped+2 {
+		t.Errorf("expected 2 dropped events but got %v", auditDropped.Value()-dropped)
+	}
+}
+
+func TestNATSIdentity(t *testing.T) {
+	tt := []struct {
+		name    string
+		headers map[string][]string
+		user    string
+		account string
+	}{
+		{
+			name:    "request info",
+			headers: map[string][]string{NATSRequestInfoHeader: {`{"acc":"APP","user":"UABC","rtt":"1ms"}`}},
+			user:    "UABC",
+			account: "APP",
+		},
+		{
+			name:    "missing",
+			headers: map[string][]string{"User-Agent": {"nats.go"}},
+		},
+		{
+			name:    "malformed",
+			headers: map[string][]string{NATSRequestInfoHeader: {"nope"}},
+		},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			user, account := NATSIdentity(v.headers)
+			if user != v.user || account != v.account {
+				t.Errorf("expected %q/%q but got %q/%q", v.user, v.account, user, account)
+			}
+		})
+	}
+}
@@ -16,6 +16,8 @@ limitations under the License.
 
 package secrets
 
+import "context"
+
 type Backend interface {
 	Writer
 	Reader
@@ -24,7 +26,7 @@ type Backend interface {
 
 type Writer interface {
 	Write(Secret) error
-	Validate(Secret) error
+	Validate(context.Context, *Secret) error
 }
 type Reader interface {
 	Read(string) (Secret, error)
@@ -33,7 +35,16 @@ type Deleter interface {
 	Delete(string) error
 }
 
+type Lister interface {
+	Keys() ([]string, error)
+}
+
 type ReaderDeleter interface {
 	Reader
 	Deleter
 }
+
+type ListReaderDeleter interface {
+	Lister
+	ReaderDeleter
+}
@@ -0,0 +1,89 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// MaxBatchSize is the most secrets that can be created in one batch.
+const MaxBatchSize = 100
+
+// BatchItem is a secret to create in a batch. If Generate is set the text is generated.
+type BatchItem struct {
+	Secret
+	Generate *GeneratePolicy `json:"generate,omitempty"`
+}
+
+// BatchResult is the result of creating one item of a batch. Index is the position of
+// the item in the request. Failed items have an error and status code instead of an ID.
+type BatchResult struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id,omitempty"`
+	Password string `json:"password,omitempty"`
+	Link     string `json:"link,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Code     int    `json:"code,omitempty"`
+
+	// Err is the error that failed the item so it can be logged
+	Err error `json:"-"`
+}
+
+// AddSecrets creates each item of the batch independently. A failed item doesn't stop
+// the rest of the batch, its result holds the error. An error is only returned if the
+// batch itself is invalid.
+func AddSecrets(ctx context.Context, w Writer, items []BatchItem) ([]BatchResult, error) {
+	if len(items) == 0 {
+		return nil, NewSecretError(http.StatusBadRequest, "batch cannot be empty")
+	}
+
+	if len(items) > MaxBatchSize {
+		return nil, NewSecretError(http.StatusBadRequest, fmt.Sprintf("batch cannot have more than %d secrets", MaxBatchSize))
+	}
+
+	results := make([]BatchResult, len(items))
+	for i, v := range items {
+		var secret Secret
+		var err error
+		if v.Generate != nil {
+			secret, err = GenerateSecret(ctx, w, v.Secret, *v.Generate)
+		} else {
+			secret, err = AddSecret(ctx, w, v.Secret)
+		}
+
+		results[i] = BatchResult{Index: i, ID: secret.ID, Password: secret.Password}
+		if err == nil {
+			continue
+		}
+
+		results[i].Err = err
+		var re RecordError
+		if errors.As(err, &re) {
+			results[i].Code = re.Code()
+			results[i].Error = re.Body()
+			continue
+		}
+
+		results[i].Code = http.StatusInternalServerError
+		results[i].Error = http.StatusText(http.StatusInternalServerError)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,64 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestAddSecrets(t *testing.T) {
+	b := newMemoryBackend()
+
+	results, err := AddSecrets(context.Background(), b, []BatchItem{
+		{Secret: Secret{Text: "hunter2", Views: 1}},
+		{Secret: Secret{Text: "no views"}},
+		{Secret: Secret{Views: 2}, Generate: &GeneratePolicy{Words: 4}},
+	})
+	if err != nil {
+		t.Fatalf("error adding batch: %v", err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results but got %d", len(results))
+	}
+
+	if results[0].ID == "" || results[0].Password == "" || results[0].Error != "" {
+		t.Errorf("expected first secret to be created but got %+v", results[0])
+	}
+
+	if results[1].ID != "" || results[1].Code != http.StatusBadRequest || results[1].Error == "" {
+		t.Errorf("expected second secret to fail but got %+v", results[1])
+	}
+
+	if results[2].Index != 2 || results[2].ID == "" {
+		t.Errorf("expected third secret to be generated but got %+v", results[2])
+	}
+
+	if len(b.secrets) != 2 {
+		t.Errorf("expected 2 secrets to be stored but got %d", len(b.secrets))
+	}
+
+	if _, err := AddSecrets(context.Background(), b, nil); err == nil {
+		t.Errorf("expected empty batch to fail")
+	}
+
+	if _, err := AddSecrets(context.Background(), b, make([]BatchItem, MaxBatchSize+1)); err == nil {
+		t.Errorf("expected batch over the limit to fail")
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/hooksie1/gophemeral/metrics"
+)
+
+// Classification is a type of credential detected in a secret.
+type Classification string
+
+const (
+	ClassPrivateKey   Classification = "private_key"
+	ClassAWSAccessKey Classification = "aws_access_key"
+	ClassGitHubToken  Classification = "github_token"
+	ClassJWT          Classification = "jwt"
+	ClassHighEntropy  Classification = "high_entropy"
+
+	// minEntropyLength is the shortest word checked for high entropy.
+	minEntropyLength = 20
+	// minEntropy is the Shannon entropy in bits per character above which a word is
+	// considered random.
+	minEntropy = 3.5
+)
+
+var classifications = metrics.NewCounter("gophemeral_secret_classifications_total", "Secrets created by detected credential type", "type")
+
+var classifiers = []struct {
+	class Classification
+	re    *regexp.Regexp
+}{
+	{ClassPrivateKey, regexp.MustCompile(`-----BEGIN ([A-Z0-9]+ )*PRIVATE KEY( BLOCK)?-----`)},
+	{ClassAWSAccessKey, regexp.MustCompile(`\b(AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`)},
+	{ClassGitHubToken, regexp.MustCompile(`\b((ghp|gho|ghu|ghs|ghr)_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{ClassJWT, regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)},
+}
+
+// Classify returns the sorted credential types detected in the text and field values
+// of the secret. Values that match a known type aren't also checked for high entropy.
+func Classify(s Secret) []Classification {
+	found := map[Classification]bool{}
+	for _, v := range contents(s) {
+		known := false
+		for _, c := range classifiers {
+			if c.re.MatchString(v) {
+				found[c.class] = true
+				known = true
+			}
+		}
+
+		if !known && highEntropy(v) {
+			found[ClassHighEntropy] = true
+		}
+	}
+
+	classes := make([]Classification, 0, len(found))
+	for k := range found {
+		classes = append(classes, k)
+	}
+	sort.Slice(classes, func(i, j int) bool { return classes[i] < classes[j] })
+
+	return classes
+}
+
+// highEntropy returns true if any word in the value is long, mixes letters with digits
+// or symbols and looks random
+func highEntropy(value string) bool {
+	for _, word := range strings.Fields(value) {
+		if len(word) < minEntropyLength || !mixed(word) {
+			continue
+		}
+
+		if entropy(word) >= minEntropy {
+			return true
+		}
+	}
+
+	return false
+}
+
+func mixed(word string) bool {
+	var letters, others bool
+	for _, r := range word {
+		if unicode.IsLetter(r) {
+			letters = true
+		} else {
+			others = true
+		}
+	}
+
+	return letters && others
+}
+
+// entropy returns the Shannon entropy of the string in bits per character
+func entropy(s string) float64 {
+	counts := map[rune]float64{}
+	var total float64
+	for _, r := range s {
+		counts[r]++
+		total++
+	}
+
+	var e float64
+	for _, c := range counts {
+		p := c / total
+		e -= p * math.Log2(p)
+	}
+
+	return e
+}
+
+func hasClassification(classes []Classification, c Classification) bool {
+	for _, v := range classes {
+		if v == c {
+			return true
+		}
+	}
+
+	return false
+}
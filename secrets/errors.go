@@ -16,8 +16,35 @@ limitations under the License.
 
 package secrets
 
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ErrorCode is a stable machine readable reason for an error.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest ErrorCode = "invalid_request"
+	CodeUnauthorized   ErrorCode = "unauthorized"
+	CodeBadPassword    ErrorCode = "bad_password"
+	CodeForbidden      ErrorCode = "forbidden"
+	CodeNotFound       ErrorCode = "not_found"
+	CodeConflict       ErrorCode = "conflict"
+	CodeRateLimited    ErrorCode = "rate_limited"
+	CodeUnavailable    ErrorCode = "unavailable"
+	CodeInternal       ErrorCode = "internal"
+
+	// ErrorCodeHeader holds the ErrorCode of a failed NATS request.
+	ErrorCodeHeader = "Gophemeral-Error-Code"
+)
+
+// RecordError is the error model shared by every transport. Status is the HTTP status
+// of the error, NATS responses use it as the service error code.
 type RecordError struct {
 	Status      int
+	ErrCode     ErrorCode
 	Description string
 }
 
@@ -29,13 +56,87 @@ func (r RecordError) Code() int {
 	return r.Status
 }
 
+// ErrorCode returns the machine readable code of the error.
+func (r RecordError) ErrorCode() ErrorCode {
+	if r.ErrCode == "" {
+		return codeForStatus(r.Status)
+	}
+
+	return r.ErrCode
+}
+
 func (r RecordError) Body() string {
 	return r.Description
 }
 
+// JSON returns the error envelope encoded as JSON.
+func (r RecordError) JSON() []byte {
+	data, _ := json.Marshal(ErrorEnvelope{Error: ErrorBody{
+		Code:    r.ErrorCode(),
+		Message: r.Description,
+		Status:  r.Status,
+	}})
+	return data
+}
+
+// ErrorEnvelope is the body of every error response.
+type ErrorEnvelope struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody describes an error. Code is stable and meant for programs, Message is
+// meant for people.
+type ErrorBody struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	Status  int       `json:"status"`
+}
+
+// NewSecretError returns an error with the code matching the HTTP status.
 func NewSecretError(status int, description string) RecordError {
+	return NewError(codeForStatus(status), status, description)
+}
+
+// NewError returns an error with an explicit code.
+func NewError(code ErrorCode, status int, description string) RecordError {
 	return RecordError{
 		Status:      status,
+		ErrCode:     code,
 		Description: description,
 	}
 }
+
+// ErrorFrom returns the RecordError wrapped in err. Any other error is an internal
+// error and its details are not returned so they don't leak to clients.
+func ErrorFrom(err error) (RecordError, bool) {
+	var re RecordError
+	if errors.As(err, &re) {
+		return re, true
+	}
+
+	return NewError(CodeInternal, http.StatusInternalServerError, "internal server error"), false
+}
+
+func codeForStatus(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusRequestEntityTooLarge:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	default:
+		if status >= 400 && status < 500 {
+			return CodeInvalidRequest
+		}
+		return CodeInternal
+	}
+}
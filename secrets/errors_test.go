@@ -0,0 +1,83 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestErrorFrom(t *testing.T) {
+	tt := []struct {
+		name   string
+		err    error
+		status int
+		code   ErrorCode
+		known  bool
+	}{
+		{name: "record error", err: NewSecretError(http.StatusNotFound, "secret not found"), status: 404, code: CodeNotFound, known: true},
+		{name: "wrapped", err: fmt.Errorf("get: %w", NewSecretError(http.StatusForbidden, "denied")), status: 403, code: CodeForbidden, known: true},
+		{name: "explicit code", err: NewError(CodeBadPassword, http.StatusUnauthorized, "bad password"), status: 401, code: CodeBadPassword, known: true},
+		{name: "unprocessable", err: NewSecretError(http.StatusUnprocessableEntity, "mismatch"), status: 422, code: CodeInvalidRequest, known: true},
+		{name: "unknown", err: fmt.Errorf("boom"), status: 500, code: CodeInternal},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			rerr, ok := ErrorFrom(v.err)
+			if ok != v.known {
+				t.Errorf("expected known %t but got %t", v.known, ok)
+			}
+			if rerr.Code() != v.status {
+				t.Errorf("expected status %d but got %d", v.status, rerr.Code())
+			}
+			if rerr.ErrorCode() != v.code {
+				t.Errorf("expected code %s but got %s", v.code, rerr.ErrorCode())
+			}
+		})
+	}
+}
+
+func TestErrorJSON(t *testing.T) {
+	rerr := NewSecretError(http.StatusBadRequest, `field "name" is "invalid"`)
+
+	var env ErrorEnvelope
+	if err := json.Unmarshal(rerr.JSON(), &env); err != nil {
+		t.Fatalf("expected valid json but got %v", err)
+	}
+
+	if env.Error.Message != rerr.Description || env.Error.Code != CodeInvalidRequest || env.Error.Status != 400 {
+		t.Errorf("unexpected envelope %+v", env)
+	}
+}
+
+func TestBadPasswordCode(t *testing.T) {
+	m := newMemoryBackend()
+	rec, err := AddSecret(context.Background(), m, Secret{Text: "test", Views: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = GetSecret(context.Background(), Secret{ID: rec.ID, Password: "wrong"}, m)
+	rerr, _ := ErrorFrom(err)
+	if rerr.ErrorCode() != CodeBadPassword {
+		t.Errorf("expected %s but got %s", CodeBadPassword, rerr.ErrorCode())
+	}
+}
@@ -0,0 +1,267 @@
+/*
+Copyright © 2024 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CoverWhale/logr"
+	"github.com/hooksie1/gophemeral/metrics"
+	"github.com/nats-io/nats.go"
+)
+
+var secretEvents = metrics.NewCounter("gophemeral_secret_events_total", "Secret lifecycle events by type", "type")
+
+type EventType string
+
+const (
+	EventCreated EventType = "created"
+	EventViewed  EventType = "viewed"
+	EventBurned  EventType = "burned"
+	EventExpired EventType = "expired"
+	EventFailed  EventType = "failed"
+	// EventLocked is sent when a secret is locked after MaxFailedAttempts wrong passwords.
+	EventLocked EventType = "locked"
+	// EventRateLimited is sent when a request is rejected for going over a rate limit.
+	EventRateLimited EventType = "rate_limited"
+	// EventCanary is sent when a canary secret is accessed.
+	EventCanary EventType = "canary"
+
+	// DefaultEventPrefix is the subject prefix lifecycle events are published under.
+	DefaultEventPrefix = "gophemeral.events"
+	// DefaultEventStream is the name of the JetStream stream holding lifecycle events.
+	DefaultEventStream = "GOPHEMERAL_EVENTS"
+)
+
+// Event is a secret lifecycle event. Events only hold metadata, the secret text and
+// password are never part of an event.
+type Event struct {
+	ID     string    `json:"id"`
+	Type   EventType `json:"type"`
+	Views  int       `json:"views"`
+	Time   time.Time `json:"timestamp"`
+	Client Client    `json:"client"`
+
+	// webhook is the creator's webhook for the secret, it is never published
+	webhook webhookTarget
+}
+
+// Client holds information about who sent a request. Headers are only included in
+// canary events.
+type Client struct {
+	IP          string            `json:"ip,omitempty"`
+	UserAgent   string            `json:"user_agent,omitempty"`
+	Subject     string            `json:"subject,omitempty"`
+	NATSUser    string            `json:"nats_user,omitempty"`
+	NATSAccount string            `json:"nats_account,omitempty"`
+	Tenant      string            `json:"tenant,omitempty"`
+	RequestID   string            `json:"request_id,omitempty"`
+	APIKey      string            `json:"api_key,omitempty"`
+	Creator     string            `json:"creator,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// NATSRequestInfoHeader is added by the NATS server to requests for services that are
+// imported by another account with sharing enabled.
+const NATSRequestInfoHeader = "Nats-Request-Info"
+
+// NATSIdentity returns the user (or nkey) and account of the connection that sent a
+// NATS request, read from the Nats-Request-Info header. Both are empty if the server
+// didn't add the header.
+func NATSIdentity(h map[string][]string) (user, account string) {
+	v := h[NATSRequestInfoHeader]
+	if len(v) == 0 {
+		return "", ""
+	}
+
+	var info struct {
+		Account string `json:"acc"`
+		User    string `json:"user"`
+	}
+	if err := json.Unmarshal([]byte(v[0]), &info); err != nil {
+		return "", ""
+	}
+
+	return info.User, info.Account
+}
+
+// sensitiveHeaders are never recorded as client information
+var sensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"x-password":          true,
+	"x-passphrase":        true,
+	"idempotency-key":     true,
+}
+
+// ClientHeaders returns a copy of the headers with credentials removed. Multiple values
+// are joined with a comma.
+func ClientHeaders(h map[string][]string) map[string]string {
+	headers := make(map[string]string, len(h))
+	for k, v := range h {
+		if sensitiveHeaders[strings.ToLower(k)] {
+			continue
+		}
+		headers[k] = strings.Join(v, ",")
+	}
+
+	return headers
+}
+
+type clientKey struct{}
+
+// WithClient returns a copy of the context holding the client information.
+func WithClient(ctx context.Context, c Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// ClientFromContext returns the client information stored in the context.
+func ClientFromContext(ctx context.Context) Client {
+	c, _ := ctx.Value(clientKey{}).(Client)
+	return c
+}
+
+// Notifier is notified of secret lifecycle events. Notifiers handle their own errors
+// so a failed notification never fails a request.
+type Notifier interface {
+	Notify(context.Context, Event)
+}
+
+// Notifiers sends events to each of the notifiers in order.
+type Notifiers []Notifier
+
+func (n Notifiers) Notify(ctx context.Context, e Event) {
+	for _, v := range n {
+		v.Notify(ctx, e)
+	}
+}
+
+// SealWebhookSecret encrypts a webhook secret with the first notifier that can.
+func (n Notifiers) SealWebhookSecret(secret string) (string, error) {
+	for _, v := range n {
+		if s, ok := v.(webhookSealer); ok {
+			return s.SealWebhookSecret(secret)
+		}
+	}
+
+	return "", nil
+}
+
+// EventCounts returns how many events of each type were sent since the service started.
+func EventCounts() map[EventType]float64 {
+	counts := map[EventType]float64{}
+	for _, v := range secretEvents.Samples() {
+		counts[EventType(v.LabelValues[0])] = v.Value
+	}
+
+	return counts
+}
+
+// notify counts the event and sends it for the secret if the backend is a notifier
+func notify(ctx context.Context, b any, s Secret, t EventType) {
+	secretEvents.Inc(string(t))
+
+	n, ok := b.(Notifier)
+	if !ok {
+		return
+	}
+
+	client := ClientFromContext(ctx)
+	if t != EventCanary {
+		client.Headers = nil
+	}
+	if client.RequestID == "" {
+		client.RequestID = RequestIDFromContext(ctx)
+	}
+
+	n.Notify(ctx, Event{
+		ID:      s.ID,
+		Type:    t,
+		Views:   s.Views,
+		Time:    time.Now().UTC(),
+		Client:  client,
+		webhook: webhookTarget{URL: s.Webhook, Key: s.WebhookSecret},
+	})
+}
+
+// EventPublisher publishes lifecycle events to JetStream on prefix.<type> subjects.
+type EventPublisher struct {
+	js     nats.JetStreamContext
+	prefix string
+	logger *logr.Logger
+}
+
+func NewEventPublisher(js nats.JetStreamContext, prefix string, logger *logr.Logger) *EventPublisher {
+	return &EventPublisher{
+		js:     js,
+		prefix: prefix,
+		logger: logger,
+	}
+}
+
+// CreateEventStream creates the stream for lifecycle events or updates it if it already exists.
+func CreateEventStream(js nats.JetStreamContext, name, prefix string) error {
+	config := &nats.StreamConfig{
+		Name:        name,
+		Description: "gophemeral secret lifecycle events",
+		Subjects:    []string{fmt.Sprintf("%s.>", prefix)},
+		Storage:     nats.FileStorage,
+	}
+
+	_, err := js.StreamInfo(name)
+	if err != nil && errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(config)
+		return err
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = js.UpdateStream(config)
+	return err
+}
+
+func (e *EventPublisher) Notify(ctx context.Context, event Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		e.logger.Errorf("error encoding event: %v", err)
+		return
+	}
+
+	subject := fmt.Sprintf("%s.%s", e.prefix, event.Type)
+	msg := requestMsg(WithRequestID(ctx, event.Client.RequestID), subject, data)
+	if _, err := e.js.PublishMsgAsync(msg); err != nil {
+		e.logger.Errorf("error publishing event to %s: %v", subject, err)
+	}
+}
+
+// Flush waits for all pending events to be acknowledged or for the context to be done.
+func (e *EventPublisher) Flush(ctx context.Context) error {
+	select {
+	case <-e.js.PublishAsyncComplete():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright © 2024 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+const (
+	lowerChars  = "abcdefghijklmnopqrstuvwxyz"
+	upperChars  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitChars  = "0123456789"
+	symbolChars = "!#$%&*+-=?@^_~"
+
+	// generateSeparators are the characters words can be separated with
+	generateSeparators = "-_.:+ "
+
+	// DefaultGenerateLength is the length of a generated value when no length is set.
+	DefaultGenerateLength = 24
+	// MaxGenerateLength is the longest value that can be generated.
+	MaxGenerateLength = 128
+	// MaxGenerateWords is the most words a generated passphrase can have.
+	MaxGenerateWords = 20
+)
+
+//go:embed words.txt
+var wordFile string
+
+var wordList = strings.Fields(wordFile)
+
+// GeneratePolicy describes how a random secret value is generated. If Words is set
+// a diceware style passphrase is generated, otherwise a string of Length characters is
+// generated from the selected character classes. If no classes are selected all of
+// them are used. Words are separated by Separator, one of generateSeparators, or - by
+// default.
+type GeneratePolicy struct {
+	Length    int    `json:"length,omitempty"`
+	Lower     bool   `json:"lower,omitempty"`
+	Upper     bool   `json:"upper,omitempty"`
+	Digits    bool   `json:"digits,omitempty"`
+	Symbols   bool   `json:"symbols,omitempty"`
+	Words     int    `json:"words,omitempty"`
+	Separator string `json:"separator,omitempty"`
+}
+
+// Generate creates a random value from the policy using crypto/rand.
+func Generate(p GeneratePolicy) (string, error) {
+	if p.Separator != "" && (len(p.Separator) != 1 || !strings.Contains(generateSeparators, p.Separator)) {
+		return "", NewSecretError(http.StatusBadRequest, fmt.Sprintf("separator must be one of %q", generateSeparators))
+	}
+
+	if p.Words > 0 {
+		return generateWords(p)
+	}
+
+	return generateChars(p)
+}
+
+// GenerateSecret generates a value for the secret based on the policy and stores it.
+// The generated value is never returned, only the ID and password are.
+func GenerateSecret(ctx context.Context, w Writer, s Secret, p GeneratePolicy) (Secret, error) {
+	value, err := Generate(p)
+	if err != nil {
+		return Secret{}, err
+	}
+
+	s.Text = value
+
+	return AddSecret(ctx, w, s)
+}
+
+func generateWords(p GeneratePolicy) (string, error) {
+	if p.Words > MaxGenerateWords {
+		return "", NewSecretError(http.StatusBadRequest, fmt.Sprintf("cannot generate more than %d words", MaxGenerateWords))
+	}
+
+	sep := p.Separator
+	if sep == "" {
+		sep = "-"
+	}
+
+	words := make([]string, p.Words)
+	for i := range words {
+		n, err := randomInt(len(wordList))
+		if err != nil {
+			return "", err
+		}
+		words[i] = wordList[n]
+	}
+
+	return strings.Join(words, sep), nil
+}
+
+func generateChars(p GeneratePolicy) (string, error) {
+	length := p.Length
+	if length == 0 {
+		length = DefaultGenerateLength
+	}
+
+	if length < 0 || length > MaxGenerateLength {
+		return "", NewSecretError(http.StatusBadRequest, fmt.Sprintf("generated length must be between 1 and %d", MaxGenerateLength))
+	}
+
+	var classes []string
+	if p.Lower {
+		classes = append(classes, lowerChars)
+	}
+	if p.Upper {
+		classes = append(classes, upperChars)
+	}
+	if p.Digits {
+		classes = append(classes, digitChars)
+	}
+	if p.Symbols {
+		classes = append(classes, symbolChars)
+	}
+	if len(classes) == 0 {
+		classes = []string{lowerChars, upperChars, digitChars, symbolChars}
+	}
+
+	if length < len(classes) {
+		return "", NewSecretError(http.StatusBadRequest, fmt.Sprintf("generated length must be at least %d for the selected character classes", len(classes)))
+	}
+
+	charset := strings.Join(classes, "")
+
+	// retry until every selected class is present so the value always satisfies the policy
+	for {
+		value := make([]byte, length)
+		for i := range value {
+			n, err := randomInt(len(charset))
+			if err != nil {
+				return "", err
+			}
+			value[i] = charset[n]
+		}
+
+		if containsAll(string(value), classes) {
+			return string(value), nil
+		}
+	}
+}
+
+func containsAll(value string, classes []string) bool {
+	for _, v := range classes {
+		if !strings.ContainsAny(value, v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// randomInt returns a uniform random int in [0, max)
+func randomInt(max int) (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0, fmt.Errorf("randomInt: error getting random data: %w", err)
+	}
+
+	return int(n.Int64()), nil
+}
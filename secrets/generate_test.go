@@ -0,0 +1,130 @@
+/*
+Copyright © 2024 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	tt := []struct {
+		name    string
+		policy  GeneratePolicy
+		charset string
+		length  int
+		err     bool
+	}{
+		{name: "default", policy: GeneratePolicy{}, charset: lowerChars + upperChars + digitChars + symbolChars, length: DefaultGenerateLength},
+		{name: "digits", policy: GeneratePolicy{Length: 6, Digits: true}, charset: digitChars, length: 6},
+		{name: "too long", policy: GeneratePolicy{Length: MaxGenerateLength + 1}, err: true},
+		{name: "too short", policy: GeneratePolicy{Length: 2, Lower: true, Upper: true, Digits: true}, err: true},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			value, err := Generate(v.policy)
+			if (err != nil) != v.err {
+				t.Fatalf("expected error %t but got %v", v.err, err)
+			}
+
+			if v.err {
+				return
+			}
+
+			if len(value) != v.length {
+				t.Errorf("expected length %d but got %d", v.length, len(value))
+			}
+
+			if strings.Trim(value, v.charset) != "" {
+				t.Errorf("value %s contains characters outside of %s", value, v.charset)
+			}
+		})
+	}
+}
+
+func TestGenerateWords(t *testing.T) {
+	value, err := Generate(GeneratePolicy{Words: 5})
+	if err != nil {
+		t.Fatalf("error generating words: %v", err)
+	}
+
+	if len(strings.Split(value, "-")) != 5 {
+		t.Errorf("expected 5 words but got %s", value)
+	}
+}
+
+func TestGenerateSeparator(t *testing.T) {
+	tt := []struct {
+		separator string
+		valid     bool
+	}{
+		{separator: "", valid: true},
+		{separator: "_", valid: true},
+		{separator: " ", valid: true},
+		{separator: ".", valid: true},
+		{separator: "a"},
+		{separator: "7"},
+		{separator: "--"},
+		{separator: "<"},
+		{separator: "\n"},
+		{separator: "é"},
+	}
+
+	for _, v := range tt {
+		value, err := Generate(GeneratePolicy{Words: 3, Separator: v.separator})
+		if !v.valid {
+			rerr, ok := ErrorFrom(err)
+			if !ok || rerr.Code() != http.StatusBadRequest {
+				t.Errorf("expected separator %q to be rejected with a 400 but got %v", v.separator, err)
+			}
+			continue
+		}
+
+		sep := v.separator
+		if sep == "" {
+			sep = "-"
+		}
+		if err != nil || len(strings.Split(value, sep)) != 3 {
+			t.Errorf("expected 3 words separated by %q but got %q: %v", sep, value, err)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	b := newMemoryBackend()
+
+	resp, err := GenerateSecret(context.Background(), b, Secret{Views: 1}, GeneratePolicy{Length: 32})
+	if err != nil {
+		t.Fatalf("error generating secret: %v", err)
+	}
+
+	if resp.Text != "" {
+		t.Errorf("generated value should not be returned")
+	}
+
+	secret, err := GetSecret(context.Background(), Secret{ID: resp.ID, Password: resp.Password}, b)
+	if err != nil {
+		t.Fatalf("error getting secret: %v", err)
+	}
+
+	if len(secret.Text) != 32 {
+		t.Errorf("expected generated value of length 32 but got %d", len(secret.Text))
+	}
+}
@@ -0,0 +1,213 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/CoverWhale/logr"
+	"github.com/hooksie1/gophemeral/metrics"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	DecisionAllow  = "allow"
+	DecisionDeny   = "deny"
+	DecisionModify = "modify"
+
+	// DefaultPolicyHookTimeout is how long the hook waits for a decision.
+	DefaultPolicyHookTimeout = 2 * time.Second
+)
+
+var hookDecisions = metrics.NewCounter("gophemeral_policy_hook_decisions_total", "Policy hook decisions by result", "decision")
+
+// Validators runs each validator in order and returns the first error.
+type Validators []Validator
+
+func (v Validators) Validate(ctx context.Context, s *Secret) error {
+	for _, validator := range v {
+		if err := validator.Validate(ctx, s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PolicyRequest is sent to the external policy service. It only holds metadata about
+// the secret, never its content or password.
+type PolicyRequest struct {
+	Size            int              `json:"size"`
+	Fields          int              `json:"fields"`
+	Views           int              `json:"views"`
+	TTL             int              `json:"ttl"`
+	Classifications []Classification `json:"classifications,omitempty"`
+	Passphrase      bool             `json:"passphrase"`
+	Recipients      []string         `json:"recipients,omitempty"`
+	Canary          bool             `json:"canary,omitempty"`
+	Webhook         bool             `json:"webhook,omitempty"`
+	Profile         string           `json:"profile,omitempty"`
+	Tenant          string           `json:"tenant,omitempty"`
+	Client          Client           `json:"client"`
+}
+
+// PolicyDecision is the reply of the external policy service. A modify decision
+// replaces the views and TTL of the secret with any that are set.
+type PolicyDecision struct {
+	Decision string `json:"decision"`
+	Reason   string `json:"reason,omitempty"`
+	Views    int    `json:"views,omitempty"`
+	TTL      int    `json:"ttl,omitempty"`
+}
+
+// PolicyHookConfig configures the external policy hook. If FailOpen is set secrets are
+// allowed when the policy service doesn't answer, otherwise they are rejected. Secrets
+// the hook modified are validated again with Limits so a decision can't raise the
+// views or TTL above the profile's limits.
+type PolicyHookConfig struct {
+	Subject  string
+	Timeout  time.Duration
+	FailOpen bool
+	Limits   Validator
+}
+
+// requester sends a NATS request and waits for the reply
+type requester interface {
+	RequestMsgWithContext(context.Context, *nats.Msg) (*nats.Msg, error)
+}
+
+// PolicyHook asks an external policy service over NATS request/reply to allow, deny
+// or modify every new secret.
+type PolicyHook struct {
+	nc     requester
+	config PolicyHookConfig
+	logger *logr.Logger
+}
+
+func NewPolicyHook(nc *nats.Conn, c PolicyHookConfig, logger *logr.Logger) *PolicyHook {
+	if c.Timeout == 0 {
+		c.Timeout = DefaultPolicyHookTimeout
+	}
+
+	return &PolicyHook{
+		nc:     nc,
+		config: c,
+		logger: logger,
+	}
+}
+
+// NewPolicyRequest returns the metadata of the secret sent to the policy service.
+func NewPolicyRequest(ctx context.Context, s Secret) PolicyRequest {
+	client := ClientFromContext(ctx)
+	client.Headers = nil
+
+	size := 0
+	for _, v := range contents(s) {
+		size += len(v)
+	}
+
+	ttl := s.TTL
+	if ttl == 0 && !s.Canary {
+		ttl = int(DefaultTTL.Seconds())
+	}
+
+	return PolicyRequest{
+		Size:            size,
+		Fields:          len(s.Fields),
+		Views:           s.Views,
+		TTL:             ttl,
+		Classifications: s.Classifications,
+		Passphrase:      s.Passphrase != "",
+		Recipients:      s.Recipients,
+		Canary:          s.Canary,
+		Webhook:         s.Webhook != "",
+		Profile:         ProfileFromContext(ctx),
+		Tenant:          client.Tenant,
+		Client:          client,
+	}
+}
+
+func (p *PolicyHook) Validate(ctx context.Context, s *Secret) error {
+	data, err := json.Marshal(NewPolicyRequest(ctx, *s))
+	if err != nil {
+		return err
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+
+	msg, err := p.nc.RequestMsgWithContext(reqCtx, requestMsg(reqCtx, p.config.Subject, data))
+	if err != nil {
+		return p.unavailable(err)
+	}
+
+	var d PolicyDecision
+	if err := json.Unmarshal(msg.Data, &d); err != nil {
+		return p.unavailable(fmt.Errorf("bad decision: %w", err))
+	}
+
+	switch d.Decision {
+	case DecisionAllow:
+	case DecisionDeny:
+		hookDecisions.Inc(DecisionDeny)
+		reason := d.Reason
+		if reason == "" {
+			reason = "secret denied by policy"
+		}
+		return NewSecretError(http.StatusForbidden, reason)
+	case DecisionModify:
+		if d.Views < 0 || d.TTL < 0 {
+			return p.unavailable(fmt.Errorf("bad modify decision views %d ttl %d", d.Views, d.TTL))
+		}
+		if d.Views > 0 {
+			s.Views = d.Views
+		}
+		if d.TTL > 0 {
+			s.TTL = d.TTL
+		}
+		if p.config.Limits != nil {
+			if err := p.config.Limits.Validate(ctx, s); err != nil {
+				hookDecisions.Inc("modify_rejected")
+				return err
+			}
+		}
+	default:
+		return p.unavailable(fmt.Errorf("unknown decision %q", d.Decision))
+	}
+
+	hookDecisions.Inc(d.Decision)
+
+	return nil
+}
+
+// unavailable applies the fail open or fail closed setting when no valid decision
+// was received
+func (p *PolicyHook) unavailable(err error) error {
+	if p.config.FailOpen {
+		hookDecisions.Inc("fail_open")
+		p.logger.Errorf("policy hook failed, allowing secret: %v", err)
+		return nil
+	}
+
+	hookDecisions.Inc("fail_closed")
+	p.logger.Errorf("policy hook failed, rejecting secret: %v", err)
+	return NewSecretError(http.StatusServiceUnavailable, "policy service unavailable")
+}
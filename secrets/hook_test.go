@@ -0,0 +1,181 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CoverWhale/logr"
+	"github.com/nats-io/nats.go"
+)
+
+func TestPolicyRequest(t *testing.T) {
+	ctx := WithClient(context.Background(), Client{
+		IP:      "203.0.113.9",
+		Tenant:  "acme",
+		Headers: map[string]string{"Referer": "https://wiki.example.com"},
+	})
+
+	s := Secret{
+		Text:            "hunter2",
+		Password:        "supersecretpassword",
+		Passphrase:      "correct horse",
+		Views:           2,
+		Fields:          []Field{{Key: "user", Value: "admin"}},
+		Classifications: []Classification{ClassHighEntropy},
+	}
+
+	req := NewPolicyRequest(ctx, s)
+	if req.Size != len("hunter2")+len("admin") || req.Fields != 1 || req.Views != 2 || !req.Passphrase {
+		t.Errorf("unexpected policy request %+v", req)
+	}
+
+	if req.TTL != int(DefaultTTL.Seconds()) {
+		t.Errorf("expected default ttl but got %d", req.TTL)
+	}
+
+	if req.Tenant != "acme" || req.Client.IP != "203.0.113.9" || req.Client.Headers != nil {
+		t.Errorf("unexpected client in policy request %+v", req.Client)
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("error encoding policy request: %v", err)
+	}
+
+	for _, v := range []string{"hunter2", "admin", "supersecretpassword", "correct horse"} {
+		if strings.Contains(string(data), v) {
+			t.Errorf("policy request should not contain %s", v)
+		}
+	}
+}
+
+func TestValidators(t *testing.T) {
+	calls := 0
+	v := Validators{
+		DefaultValidator(5),
+		validatorFunc(func(ctx context.Context, s *Secret) error {
+			calls++
+			return nil
+		}),
+	}
+
+	if err := v.Validate(context.Background(), &Secret{Text: "toolong"}); err == nil {
+		t.Errorf("expected first validator to reject secret")
+	}
+
+	if calls != 0 {
+		t.Errorf("expected validators to stop at the first error")
+	}
+
+	if err := v.Validate(context.Background(), &Secret{Text: "ok"}); err != nil || calls != 1 {
+		t.Errorf("expected both validators to run but got %v", err)
+	}
+}
+
+type validatorFunc func(context.Context, *Secret) error
+
+func (v validatorFunc) Validate(ctx context.Context, s *Secret) error {
+	return v(ctx, s)
+}
+
+// policyService answers policy hook requests with a fixed reply or error
+type policyService struct {
+	reply    string
+	err      error
+	requests []PolicyRequest
+}
+
+func (p *policyService) RequestMsgWithContext(ctx context.Context, msg *nats.Msg) (*nats.Msg, error) {
+	var req PolicyRequest
+	json.Unmarshal(msg.Data, &req)
+	p.requests = append(p.requests, req)
+
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	return &nats.Msg{Data: []byte(p.reply)}, nil
+}
+
+func TestPolicyHookValidate(t *testing.T) {
+	limits, err := NewPolicyEngine(PolicyConfig{Profiles: map[string]Policy{
+		DefaultPolicy: {MaxViews: 5, MaxTTL: time.Hour},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name     string
+		reply    string
+		err      error
+		failOpen bool
+		code     int
+		views    int
+		ttl      int
+	}{
+		{name: "allow", reply: `{"decision":"allow"}`, views: 2, ttl: 60},
+		{name: "deny", reply: `{"decision":"deny","reason":"no secrets on fridays"}`, code: http.StatusForbidden},
+		{name: "modify", reply: `{"decision":"modify","views":1,"ttl":30}`, views: 1, ttl: 30},
+		{name: "modify views above profile", reply: `{"decision":"modify","views":10}`, code: http.StatusBadRequest},
+		{name: "modify ttl above profile", reply: `{"decision":"modify","ttl":86400}`, code: http.StatusBadRequest},
+		{name: "negative modify", reply: `{"decision":"modify","views":-1}`, code: http.StatusServiceUnavailable},
+		{name: "unknown decision", reply: `{"decision":"maybe"}`, code: http.StatusServiceUnavailable},
+		{name: "bad reply", reply: `not json`, code: http.StatusServiceUnavailable},
+		{name: "unavailable", err: nats.ErrTimeout, code: http.StatusServiceUnavailable},
+		{name: "fail open", err: nats.ErrNoResponders, failOpen: true, views: 2, ttl: 60},
+		{name: "fail closed", err: nats.ErrNoResponders, code: http.StatusServiceUnavailable},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			service := &policyService{reply: v.reply, err: v.err}
+			hook := NewPolicyHook(nil, PolicyHookConfig{Subject: "policy", FailOpen: v.failOpen, Limits: limits}, logr.NewLogger())
+			hook.nc = service
+
+			s := Secret{Text: "hunter2", Views: 2, TTL: 60}
+			err := hook.Validate(context.Background(), &s)
+
+			if v.code != 0 {
+				var re RecordError
+				if !errors.As(err, &re) || re.Code() != v.code {
+					t.Fatalf("expected %d but got %v", v.code, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if s.Views != v.views || s.TTL != v.ttl {
+				t.Errorf("expected views %d ttl %d but got %d %d", v.views, v.ttl, s.Views, s.TTL)
+			}
+
+			if len(service.requests) != 1 || service.requests[0].Size != len("hunter2") {
+				t.Errorf("unexpected policy requests %+v", service.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// IdempotencyHeader is the REST and NATS header holding the idempotency key.
+	IdempotencyHeader = "Idempotency-Key"
+	// DefaultIdempotencyBucket is the KV bucket idempotency records are stored in.
+	DefaultIdempotencyBucket = "idempotency"
+	// DefaultIdempotencyWindow is how long a key returns the original response.
+	DefaultIdempotencyWindow = 24 * time.Hour
+	// MaxIdempotencyKeyLength is the longest idempotency key accepted.
+	MaxIdempotencyKeyLength = 255
+	// IdempotencyLease is how long a request holds its key before a retry can take it over.
+	IdempotencyLease = 2 * time.Minute
+)
+
+var (
+	ErrIdempotencyExists   = errors.New("idempotency key already exists")
+	ErrIdempotencyNotFound = errors.New("idempotency key not found")
+	// ErrIdempotencyNotStored is returned with the secret when it was created but the
+	// response couldn't be stored for retries.
+	ErrIdempotencyNotStored = errors.New("idempotency response not stored")
+
+	errIdempotencyInProgress = NewSecretError(http.StatusConflict, "a request with this idempotency key is in progress")
+)
+
+// IdempotencyRecord holds the result of a creation. Request is an HMAC of the request
+// and Response is the ID and password encrypted with the idempotency key, so neither
+// can be read without the key. A record without a response is still in progress, once
+// it is older than IdempotencyLease a retry takes it over.
+type IdempotencyRecord struct {
+	Request  string    `json:"request"`
+	Response string    `json:"response,omitempty"`
+	Started  time.Time `json:"started,omitempty"`
+	// Revision is set by Get and is used to update the record.
+	Revision uint64 `json:"-"`
+}
+
+// IdempotencyStore stores idempotency records. Records should be removed once the
+// idempotency window has passed.
+type IdempotencyStore interface {
+	// Create stores the record, it returns ErrIdempotencyExists if the key is already stored.
+	Create(key string, r IdempotencyRecord) error
+	// Get returns ErrIdempotencyNotFound if the key isn't stored.
+	Get(key string) (IdempotencyRecord, error)
+	Put(key string, r IdempotencyRecord) error
+	// Update replaces the record if it is still at the revision, otherwise it returns
+	// ErrIdempotencyExists.
+	Update(key string, r IdempotencyRecord, revision uint64) error
+	Delete(key string) error
+}
+
+// WithIdempotency stores the responses of secret creations with an idempotency key.
+func WithIdempotency(store IdempotencyStore) BackendOption {
+	return func(b *NATS) {
+		b.idempotency = store
+	}
+}
+
+// IdempotencyStore returns the idempotency store of the backend if there is one.
+func (n *NATS) IdempotencyStore() IdempotencyStore {
+	return n.idempotency
+}
+
+type idempotent interface {
+	IdempotencyStore() IdempotencyStore
+}
+
+type idempotencyResponse struct {
+	ID       string `json:"id"`
+	Password string `json:"password"`
+}
+
+// CreateOnce runs create once for each idempotency key. Repeating a key with the same
+// request returns the ID and password of the first secret instead of creating another.
+// The key is scoped to the client's tenant. If the backend has no idempotency store or
+// the key is empty create is always run. If the secret is created but its response
+// can't be stored the secret is returned with ErrIdempotencyNotStored.
+func CreateOnce(ctx context.Context, b any, key string, request []byte, create func(context.Context) (Secret, error)) (Secret, error) {
+	resp, err := once(ctx, b, key, request, func(ctx context.Context) (idempotencyResponse, error) {
+		secret, err := create(ctx)
+		return idempotencyResponse{ID: secret.ID, Password: secret.Password}, err
+	})
+
+	return Secret{ID: resp.ID, Password: resp.Password}, err
+}
+
+// AddSecretsOnce creates the batch once for each idempotency key like CreateOnce.
+// Repeating a key with the same request returns the results of the first batch.
+func AddSecretsOnce(ctx context.Context, w Writer, key string, request []byte, items []BatchItem) ([]BatchResult, error) {
+	return once(ctx, w, key, request, func(ctx context.Context) ([]BatchResult, error) {
+		return AddSecrets(ctx, w, items)
+	})
+}
+
+// once runs create once for each idempotency key and stores its response encrypted
+// with the key.
+func once[T any](ctx context.Context, b any, key string, request []byte, create func(context.Context) (T, error)) (T, error) {
+	var empty T
+
+	i, ok := b.(idempotent)
+	if !ok || i.IdempotencyStore() == nil || key == "" {
+		return create(ctx)
+	}
+	store := i.IdempotencyStore()
+
+	if len(key) > MaxIdempotencyKeyLength {
+		return empty, NewSecretError(http.StatusBadRequest, fmt.Sprintf("idempotency key cannot be longer than %d", MaxIdempotencyKeyLength))
+	}
+
+	id := idempotencyID(ClientFromContext(ctx).Tenant, key)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(request)
+	fingerprint := hex.EncodeToString(mac.Sum(nil))
+	record := IdempotencyRecord{Request: fingerprint, Started: time.Now().UTC()}
+
+	switch err := store.Create(id, record); {
+	case errors.Is(err, ErrIdempotencyExists):
+		existing, err := store.Get(id)
+		if err != nil {
+			return empty, err
+		}
+
+		if !abandoned(existing, fingerprint) {
+			var resp T
+			err := replay(existing, key, fingerprint, &resp)
+			return resp, err
+		}
+
+		// the request holding the key didn't finish within the lease
+		err = store.Update(id, record, existing.Revision)
+		if errors.Is(err, ErrIdempotencyExists) {
+			return empty, errIdempotencyInProgress
+		}
+		if err != nil {
+			return empty, err
+		}
+	case err != nil:
+		return empty, err
+	}
+
+	resp, err := create(ctx)
+	if err != nil {
+		// the create error is kept so the client still gets the reason it failed
+		if deleteErr := store.Delete(id); deleteErr != nil {
+			return empty, fmt.Errorf("%w (error releasing idempotency key: %v)", err, deleteErr)
+		}
+		return empty, err
+	}
+
+	if err := storeResponse(store, id, key, fingerprint, resp); err != nil {
+		return resp, fmt.Errorf("%w: %v", ErrIdempotencyNotStored, err)
+	}
+
+	return resp, nil
+}
+
+// storeResponse encrypts the response and stores it in the record
+func storeResponse(store IdempotencyStore, id, key, fingerprint string, resp any) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := encrypt(data, key)
+	if err != nil {
+		return err
+	}
+
+	return store.Put(id, IdempotencyRecord{Request: fingerprint, Response: toBase64(encrypted)})
+}
+
+// abandoned reports whether the record is for the same request and is still in progress
+// after its lease ran out.
+func abandoned(r IdempotencyRecord, fingerprint string) bool {
+	return r.Response == "" &&
+		hmac.Equal([]byte(r.Request), []byte(fingerprint)) &&
+		time.Since(r.Started) > IdempotencyLease
+}
+
+// replay decodes the stored response of the record into resp
+func replay(record IdempotencyRecord, key, fingerprint string, resp any) error {
+	if !hmac.Equal([]byte(record.Request), []byte(fingerprint)) {
+		return NewSecretError(http.StatusUnprocessableEntity, "idempotency key was used with a different request")
+	}
+
+	if record.Response == "" {
+		return errIdempotencyInProgress
+	}
+
+	encrypted, err := fromBase64(record.Response)
+	if err != nil {
+		return err
+	}
+
+	data, err := decrypt(encrypted, key)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, resp)
+}
+
+// idempotencyID hashes the key so it can't be used to decrypt the stored response
+func idempotencyID(tenant, key string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s", tenant, key)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// NATSIdempotencyStore stores idempotency records in a KV bucket. The bucket TTL is
+// the idempotency window.
+type NATSIdempotencyStore struct {
+	kv nats.KeyValue
+}
+
+// NewNATSIdempotencyStore returns a store for the bucket, creating the bucket with the
+// window as its TTL if needed. If the bucket exists with a different TTL it is updated
+// to the window.
+func NewNATSIdempotencyStore(js nats.JetStreamContext, bucket string, window time.Duration) (*NATSIdempotencyStore, error) {
+	kv, err := js.KeyValue(bucket)
+	if err != nil && errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      bucket,
+			Description: "gophemeral idempotency keys",
+			TTL:         window,
+			Storage:     nats.FileStorage,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := kv.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	if status.TTL() != window {
+		if err := setBucketTTL(js, bucket, window); err != nil {
+			return nil, fmt.Errorf("error changing the TTL of %s from %s to %s: %w", bucket, status.TTL(), window, err)
+		}
+	}
+
+	return &NATSIdempotencyStore{kv: kv}, nil
+}
+
+// setBucketTTL changes the max age of the stream backing the KV bucket. The duplicate
+// window can't be longer than the max age so it is lowered with it like CreateKeyValue does.
+func setBucketTTL(js nats.JetStreamContext, bucket string, ttl time.Duration) error {
+	info, err := js.StreamInfo(kvStream(bucket))
+	if err != nil {
+		return err
+	}
+
+	config := info.Config
+	config.MaxAge = ttl
+	config.Duplicates = 2 * time.Minute
+	if ttl > 0 && ttl < config.Duplicates {
+		config.Duplicates = ttl
+	}
+
+	_, err = js.UpdateStream(&config)
+	return err
+}
+
+// kvStream returns the name of the stream nats.go creates for a KV bucket
+func kvStream(bucket string) string {
+	return "KV_" + bucket
+}
+
+func (n *NATSIdempotencyStore) Create(key string, r IdempotencyRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = n.kv.Create(key, data)
+	if err != nil && errors.Is(err, nats.ErrKeyExists) {
+		return ErrIdempotencyExists
+	}
+
+	return err
+}
+
+func (n *NATSIdempotencyStore) Get(key string) (IdempotencyRecord, error) {
+	var r IdempotencyRecord
+	entry, err := n.kv.Get(key)
+	if err != nil && errors.Is(err, nats.ErrKeyNotFound) {
+		return r, ErrIdempotencyNotFound
+	}
+	if err != nil {
+		return r, err
+	}
+
+	err = json.Unmarshal(entry.Value(), &r)
+	r.Revision = entry.Revision()
+	return r, err
+}
+
+func (n *NATSIdempotencyStore) Put(key string, r IdempotencyRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = n.kv.Put(key, data)
+	return err
+}
+
+func (n *NATSIdempotencyStore) Update(key string, r IdempotencyRecord, revision uint64) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = n.kv.Update(key, data, revision)
+	if err != nil && errors.Is(err, nats.ErrKeyExists) {
+		return ErrIdempotencyExists
+	}
+
+	return err
+}
+
+func (n *NATSIdempotencyStore) Delete(key string) error {
+	return n.kv.Delete(key)
+}
@@ -0,0 +1,265 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memoryIdempotencyStore struct {
+	records   map[string]IdempotencyRecord
+	putErr    error
+	deleteErr error
+}
+
+func (m *memoryIdempotencyStore) Create(key string, r IdempotencyRecord) error {
+	if _, ok := m.records[key]; ok {
+		return ErrIdempotencyExists
+	}
+	r.Revision = 1
+	m.records[key] = r
+	return nil
+}
+
+func (m *memoryIdempotencyStore) Get(key string) (IdempotencyRecord, error) {
+	r, ok := m.records[key]
+	if !ok {
+		return r, ErrIdempotencyNotFound
+	}
+	return r, nil
+}
+
+func (m *memoryIdempotencyStore) Put(key string, r IdempotencyRecord) error {
+	if m.putErr != nil {
+		return m.putErr
+	}
+	r.Revision = m.records[key].Revision + 1
+	m.records[key] = r
+	return nil
+}
+
+func (m *memoryIdempotencyStore) Update(key string, r IdempotencyRecord, revision uint64) error {
+	if m.records[key].Revision != revision {
+		return ErrIdempotencyExists
+	}
+	r.Revision = revision + 1
+	m.records[key] = r
+	return nil
+}
+
+func (m *memoryIdempotencyStore) Delete(key string) error {
+	if m.deleteErr != nil {
+		return m.deleteErr
+	}
+	delete(m.records, key)
+	return nil
+}
+
+type idempotentBackend struct {
+	*memoryBackend
+	store *memoryIdempotencyStore
+}
+
+func (i idempotentBackend) IdempotencyStore() IdempotencyStore {
+	return i.store
+}
+
+func TestCreateOnce(t *testing.T) {
+	b := idempotentBackend{
+		memoryBackend: newMemoryBackend(),
+		store:         &memoryIdempotencyStore{records: map[string]IdempotencyRecord{}},
+	}
+	ctx := context.Background()
+	request := []byte(`{"text":"hunter2","views":1}`)
+
+	calls := 0
+	create := func(ctx context.Context) (Secret, error) {
+		calls++
+		return AddSecret(ctx, b, Secret{Text: "hunter2", Views: 1})
+	}
+
+	first, err := CreateOnce(ctx, b, "retry-me", request, create)
+	if err != nil {
+		t.Fatalf("error creating secret: %v", err)
+	}
+
+	second, err := CreateOnce(ctx, b, "retry-me", request, create)
+	if err != nil {
+		t.Fatalf("error repeating request: %v", err)
+	}
+
+	if calls != 1 || len(b.secrets) != 1 {
+		t.Errorf("expected one secret to be created but got %d", len(b.secrets))
+	}
+
+	if first.ID != second.ID || first.Password != second.Password {
+		t.Errorf("expected the original response but got %+v", second)
+	}
+
+	for _, v := range b.store.records {
+		if strings.Contains(v.Response, first.Password) || strings.Contains(v.Response, first.ID) {
+			t.Errorf("expected the stored response to be encrypted")
+		}
+	}
+
+	_, err = CreateOnce(ctx, b, "retry-me", []byte(`{"text":"other","views":1}`), create)
+	var re RecordError
+	if !errors.As(err, &re) || re.Code() != http.StatusUnprocessableEntity {
+		t.Errorf("expected reused key with another request to fail but got %v", err)
+	}
+
+	if _, err := CreateOnce(WithClient(ctx, Client{Tenant: "acme"}), b, "retry-me", request, create); err != nil || calls != 2 {
+		t.Errorf("expected keys to be scoped to the tenant but got %v", err)
+	}
+
+	if _, err := CreateOnce(ctx, b, "", request, create); err != nil || calls != 3 {
+		t.Errorf("expected no key to always create but got %v", err)
+	}
+}
+
+func TestCreateOnceFailure(t *testing.T) {
+	b := idempotentBackend{
+		memoryBackend: newMemoryBackend(),
+		store:         &memoryIdempotencyStore{records: map[string]IdempotencyRecord{}},
+	}
+
+	_, err := CreateOnce(context.Background(), b, "bad", nil, func(ctx context.Context) (Secret, error) {
+		return AddSecret(ctx, b, Secret{Text: "hunter2"})
+	})
+	if err == nil {
+		t.Fatalf("expected error creating secret without views")
+	}
+
+	if len(b.store.records) != 0 {
+		t.Errorf("expected failed request to release the idempotency key")
+	}
+}
+
+func TestCreateOnceFailureNotReleased(t *testing.T) {
+	b := idempotentBackend{
+		memoryBackend: newMemoryBackend(),
+		store:         &memoryIdempotencyStore{records: map[string]IdempotencyRecord{}, deleteErr: errors.New("kv unavailable")},
+	}
+
+	_, err := CreateOnce(context.Background(), b, "bad", nil, func(ctx context.Context) (Secret, error) {
+		return AddSecret(ctx, b, Secret{Text: "hunter2"})
+	})
+
+	rerr, ok := ErrorFrom(err)
+	if !ok || rerr.Code() != http.StatusBadRequest {
+		t.Errorf("expected the create error to be returned but got %v", err)
+	}
+
+	if err == nil || !strings.Contains(err.Error(), "kv unavailable") {
+		t.Errorf("expected the delete error to be wrapped but got %v", err)
+	}
+}
+
+func TestCreateOnceResponseNotStored(t *testing.T) {
+	b := idempotentBackend{
+		memoryBackend: newMemoryBackend(),
+		store:         &memoryIdempotencyStore{records: map[string]IdempotencyRecord{}, putErr: errors.New("kv unavailable")},
+	}
+
+	secret, err := CreateOnce(context.Background(), b, "key", nil, func(ctx context.Context) (Secret, error) {
+		return AddSecret(ctx, b, Secret{Text: "hunter2", Views: 1})
+	})
+	if !errors.Is(err, ErrIdempotencyNotStored) {
+		t.Fatalf("expected ErrIdempotencyNotStored but got %v", err)
+	}
+
+	if secret.ID == "" || secret.Password == "" || len(b.secrets) != 1 {
+		t.Errorf("expected the created secret to be returned but got %+v", secret)
+	}
+}
+
+func TestCreateOnceLease(t *testing.T) {
+	b := idempotentBackend{
+		memoryBackend: newMemoryBackend(),
+		store:         &memoryIdempotencyStore{records: map[string]IdempotencyRecord{}},
+	}
+	ctx := context.Background()
+	request := []byte(`{"text":"hunter2","views":1}`)
+	create := func(ctx context.Context) (Secret, error) {
+		return AddSecret(ctx, b, Secret{Text: "hunter2", Views: 1})
+	}
+
+	// a request that crashed after taking the key
+	b.store.putErr = errors.New("crashed")
+	if _, err := CreateOnce(ctx, b, "retry-me", request, create); !errors.Is(err, ErrIdempotencyNotStored) {
+		t.Fatalf("expected response not to be stored but got %v", err)
+	}
+	b.store.putErr = nil
+
+	_, err := CreateOnce(ctx, b, "retry-me", request, create)
+	var re RecordError
+	if !errors.As(err, &re) || re.Code() != http.StatusConflict {
+		t.Fatalf("expected key to be in progress within the lease but got %v", err)
+	}
+
+	id := idempotencyID("", "retry-me")
+	record := b.store.records[id]
+	record.Started = time.Now().Add(-2 * IdempotencyLease)
+	b.store.records[id] = record
+
+	secret, err := CreateOnce(ctx, b, "retry-me", request, create)
+	if err != nil {
+		t.Fatalf("expected retry to take over the expired lease but got %v", err)
+	}
+
+	replayed, err := CreateOnce(ctx, b, "retry-me", request, create)
+	if err != nil || replayed.ID != secret.ID {
+		t.Errorf("expected the response of the retry to be stored but got %+v: %v", replayed, err)
+	}
+
+	if err := b.store.Update(id, IdempotencyRecord{}, record.Revision); !errors.Is(err, ErrIdempotencyExists) {
+		t.Errorf("expected update at an old revision to fail but got %v", err)
+	}
+}
+
+func TestAddSecretsOnce(t *testing.T) {
+	b := idempotentBackend{
+		memoryBackend: newMemoryBackend(),
+		store:         &memoryIdempotencyStore{records: map[string]IdempotencyRecord{}},
+	}
+	ctx := context.Background()
+	request := []byte(`{"secrets":[{"text":"a","views":1},{"text":"b"}]}`)
+	items := []BatchItem{{Secret: Secret{Text: "a", Views: 1}}, {Secret: Secret{Text: "b"}}}
+
+	first, err := AddSecretsOnce(ctx, b, "chunk-1", request, items)
+	if err != nil {
+		t.Fatalf("error creating batch: %v", err)
+	}
+
+	second, err := AddSecretsOnce(ctx, b, "chunk-1", request, items)
+	if err != nil {
+		t.Fatalf("error repeating batch: %v", err)
+	}
+
+	if len(b.secrets) != 1 {
+		t.Errorf("expected the batch to be created once but got %d secrets", len(b.secrets))
+	}
+
+	if len(second) != 2 || second[0].ID != first[0].ID || second[0].Password != first[0].Password || second[1].Code != http.StatusBadRequest {
+		t.Errorf("expected the original results but got %+v", second)
+	}
+}
@@ -0,0 +1,251 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	IDSchemeKSUID  = "ksuid"
+	IDSchemeRandom = "random"
+	IDSchemeBase58 = "base58"
+	IDSchemeWords  = "words"
+
+	// MinIDEntropy is the fewest random bits an ID can have. IDs are the only thing
+	// protecting the metadata of a secret so they must be too many to enumerate.
+	MinIDEntropy = 64
+	// DefaultBase58Length is the length of base58 IDs when no length is set, the
+	// shortest with MinIDEntropy bits.
+	DefaultBase58Length = 11
+	// DefaultIDWords is the number of words in word IDs when no length is set, the
+	// fewest with MinIDEntropy bits.
+	DefaultIDWords = 7
+
+	base58Chars = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+	// maxIDAttempts is how many IDs are generated before giving up on finding an unused one
+	maxIDAttempts = 5
+)
+
+// IDGenerator creates IDs for new secrets.
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+// IDGeneratorFunc is a function that creates IDs.
+type IDGeneratorFunc func() (string, error)
+
+func (f IDGeneratorFunc) NewID() (string, error) {
+	return f()
+}
+
+// KSUIDs creates time ordered KSUIDs. They were the only IDs before schemes were added.
+func KSUIDs() IDGenerator {
+	return IDGeneratorFunc(func() (string, error) {
+		return ksuid.New().String(), nil
+	})
+}
+
+// RandomIDs creates opaque IDs from 128 random bits.
+func RandomIDs() IDGenerator {
+	return IDGeneratorFunc(func() (string, error) {
+		return generateString(16), nil
+	})
+}
+
+// Base58IDs creates short IDs of length characters that avoid look-alike characters.
+func Base58IDs(length int) IDGenerator {
+	return IDGeneratorFunc(func() (string, error) {
+		id := make([]byte, length)
+		for i := range id {
+			n, err := randomInt(len(base58Chars))
+			if err != nil {
+				return "", err
+			}
+			id[i] = base58Chars[n]
+		}
+		return string(id), nil
+	})
+}
+
+// WordIDs creates IDs of lower case words joined with dashes that are easy to read out.
+func WordIDs(words int) IDGenerator {
+	return IDGeneratorFunc(func() (string, error) {
+		return generateWords(GeneratePolicy{Words: words})
+	})
+}
+
+// NewIDGenerator returns the generator for the scheme. Length is the number of
+// characters of base58 IDs or words of word IDs, zero uses the default.
+func NewIDGenerator(scheme string, length int) (IDGenerator, error) {
+	if length < 0 {
+		return nil, fmt.Errorf("id length cannot be negative")
+	}
+
+	switch scheme {
+	case IDSchemeKSUID, "":
+		return KSUIDs(), nil
+	case IDSchemeRandom:
+		return RandomIDs(), nil
+	case IDSchemeBase58:
+		if length == 0 {
+			length = DefaultBase58Length
+		}
+		if min := minIDLength(len(base58Chars)); length < min {
+			return nil, fmt.Errorf("base58 ids need at least %d characters for %d bits of entropy", min, MinIDEntropy)
+		}
+		return Base58IDs(length), nil
+	case IDSchemeWords:
+		if length == 0 {
+			length = DefaultIDWords
+		}
+		if min := minIDLength(len(wordList)); length < min {
+			return nil, fmt.Errorf("word ids need at least %d words for %d bits of entropy", min, MinIDEntropy)
+		}
+		if length > MaxGenerateWords {
+			return nil, fmt.Errorf("word ids cannot have more than %d words", MaxGenerateWords)
+		}
+		return WordIDs(length), nil
+	default:
+		return nil, fmt.Errorf("unknown id scheme %s", scheme)
+	}
+}
+
+// minIDLength returns the fewest symbols from an alphabet of the size that give an ID
+// MinIDEntropy bits
+func minIDLength(alphabet int) int {
+	return int(math.Ceil(MinIDEntropy / math.Log2(float64(alphabet))))
+}
+
+// WithIDGenerator sets how the IDs of new secrets are created. KSUIDs are used by default.
+func WithIDGenerator(g IDGenerator) BackendOption {
+	return func(b *NATS) {
+		b.ids = g
+	}
+}
+
+// IDGenerator returns the ID generator of the backend.
+func (n *NATS) IDGenerator() IDGenerator {
+	return n.ids
+}
+
+type idGenerating interface {
+	IDGenerator() IDGenerator
+}
+
+// ErrIDExists is returned by Create when a secret with the ID already exists.
+var ErrIDExists = errors.New("id already exists")
+
+// secretCreator is a Writer that only writes a secret if its ID is unused. New secrets
+// are written with Create so two creates that get the same ID can't overwrite each other.
+type secretCreator interface {
+	Create(s Secret) error
+}
+
+// idGenerator returns the backend's generator, KSUIDs if it doesn't have one
+func idGenerator(w Writer) IDGenerator {
+	if g, ok := w.(idGenerating); ok && g.IDGenerator() != nil {
+		return g.IDGenerator()
+	}
+
+	return KSUIDs()
+}
+
+// newID creates an ID with the backend's generator. Backends that create secrets
+// atomically check the ID when it's written. Otherwise, if the backend can be read, the
+// ID is checked to be unused and a new one is generated on a collision.
+func newID(w Writer) (string, error) {
+	gen := idGenerator(w)
+
+	r, ok := w.(Reader)
+	if _, creates := w.(secretCreator); creates {
+		ok = false
+	}
+
+	for attempt := 0; attempt < maxIDAttempts; attempt++ {
+		id, err := gen.NewID()
+		if err != nil {
+			return "", err
+		}
+
+		if !ok {
+			return id, nil
+		}
+
+		_, err = r.Read(id)
+		if isNotFound(err) {
+			return id, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return "", errNoUnusedID
+}
+
+var errNoUnusedID = NewSecretError(http.StatusServiceUnavailable, "could not generate an unused id")
+
+// storeNewSecret writes a new secret. If the backend creates secrets atomically and the
+// ID was taken in the meantime the secret is stored under a new ID.
+func storeNewSecret(ctx context.Context, w Writer, s *Secret) error {
+	c, ok := w.(secretCreator)
+	if !ok {
+		return writeSecret(ctx, w, *s)
+	}
+
+	for attempt := 0; attempt < maxIDAttempts; attempt++ {
+		if attempt > 0 {
+			id, err := idGenerator(w).NewID()
+			if err != nil {
+				return err
+			}
+			s.ID = id
+		}
+
+		err := createSecret(ctx, c, *s)
+		if !errors.Is(err, ErrIDExists) {
+			return err
+		}
+	}
+
+	return errNoUnusedID
+}
+
+// NormalizeID cleans up an ID that was typed in. Word IDs read out over the phone can
+// be entered with spaces and in any case.
+func NormalizeID(id string) string {
+	id = strings.TrimSpace(id)
+	if !strings.ContainsAny(id, " \t") {
+		return id
+	}
+
+	return strings.ToLower(strings.Join(strings.Fields(id), "-"))
+}
+
+func isNotFound(err error) bool {
+	var re RecordError
+	return errors.Is(err, errSecretNotFound) || (errors.As(err, &re) && re.Code() == http.StatusNotFound)
+}
@@ -0,0 +1,132 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"testing"
+)
+
+func TestIDSchemes(t *testing.T) {
+	tt := []struct {
+		scheme string
+		length int
+		re     string
+	}{
+		{scheme: IDSchemeKSUID, re: `^[0-9A-Za-z]{27}$`},
+		{scheme: IDSchemeRandom, re: `^[0-9A-Za-z_-]{22}$`},
+		{scheme: IDSchemeBase58, re: `^[1-9A-HJ-NP-Za-km-z]{11}$`},
+		{scheme: IDSchemeBase58, length: 16, re: `^[1-9A-HJ-NP-Za-km-z]{16}$`},
+		{scheme: IDSchemeWords, re: `^[a-z]+(-[a-z]+){6}$`},
+		{scheme: IDSchemeWords, length: 8, re: `^[a-z]+(-[a-z]+){7}$`},
+	}
+
+	for _, v := range tt {
+		t.Run(v.scheme, func(t *testing.T) {
+			gen, err := NewIDGenerator(v.scheme, v.length)
+			if err != nil {
+				t.Fatalf("error creating generator: %v", err)
+			}
+
+			id, err := gen.NewID()
+			if err != nil {
+				t.Fatalf("error creating id: %v", err)
+			}
+
+			if !regexp.MustCompile(v.re).MatchString(id) {
+				t.Errorf("id %s does not match %s", id, v.re)
+			}
+		})
+	}
+
+	if _, err := NewIDGenerator("uuid", 0); err == nil {
+		t.Errorf("expected error for unknown scheme")
+	}
+}
+
+func TestIDEntropy(t *testing.T) {
+	for _, v := range []struct {
+		scheme string
+		length int
+	}{
+		{scheme: IDSchemeBase58, length: 1},
+		{scheme: IDSchemeBase58, length: DefaultBase58Length - 1},
+		{scheme: IDSchemeWords, length: 4},
+		{scheme: IDSchemeWords, length: DefaultIDWords - 1},
+	} {
+		if _, err := NewIDGenerator(v.scheme, v.length); err == nil {
+			t.Errorf("expected %s ids of length %d to be rejected", v.scheme, v.length)
+		}
+	}
+
+	if bits := float64(DefaultIDWords) * math.Log2(float64(len(wordList))); bits < MinIDEntropy {
+		t.Errorf("expected default word ids to have %d bits but they have %.1f", MinIDEntropy, bits)
+	}
+}
+
+type fixedIDBackend struct {
+	*memoryBackend
+	ids []string
+}
+
+func (f *fixedIDBackend) IDGenerator() IDGenerator {
+	return IDGeneratorFunc(func() (string, error) {
+		id := f.ids[0]
+		if len(f.ids) > 1 {
+			f.ids = f.ids[1:]
+		}
+		return id, nil
+	})
+}
+
+func TestIDCollision(t *testing.T) {
+	b := &fixedIDBackend{memoryBackend: newMemoryBackend(), ids: []string{"taken", "taken", "free"}}
+	b.secrets["taken"] = Secret{ID: "taken", Views: 1}
+
+	resp, err := AddSecret(context.Background(), b, Secret{Text: "hunter2", Views: 1})
+	if err != nil {
+		t.Fatalf("error adding secret: %v", err)
+	}
+
+	if resp.ID != "free" {
+		t.Errorf("expected collisions to be retried but got %s", resp.ID)
+	}
+
+	if _, err := AddSecret(context.Background(), b, Secret{Text: "hunter2", Views: 1}); err == nil {
+		t.Errorf("expected error when every id is taken")
+	}
+}
+
+func TestWordIDLookup(t *testing.T) {
+	b := &fixedIDBackend{memoryBackend: newMemoryBackend(), ids: []string{"apple-river-stone-cloud"}}
+
+	resp, err := AddSecret(context.Background(), b, Secret{Text: "hunter2", Views: 1})
+	if err != nil {
+		t.Fatalf("error adding secret: %v", err)
+	}
+
+	secret, err := GetSecret(context.Background(), Secret{ID: " Apple River  Stone cloud ", Password: resp.Password}, b)
+	if err != nil {
+		t.Fatalf("error getting secret by spoken id: %v", err)
+	}
+
+	if secret.Text != "hunter2" {
+		t.Errorf("expected hunter2 but got %s", secret.Text)
+	}
+}
@@ -0,0 +1,518 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultJWKSCacheTTL is how long keys fetched from a JWKS URL are used before
+	// they are fetched again.
+	DefaultJWKSCacheTTL = time.Hour
+	// DefaultCreatorClaim is the claim used as the creator of secrets.
+	DefaultCreatorClaim = "email"
+
+	// jwksMinRefresh limits refetching the key set for unknown key IDs
+	jwksMinRefresh = time.Minute
+	// jwtLeeway allows for clock skew checking exp and nbf
+	jwtLeeway = time.Minute
+)
+
+var errInvalidToken = errors.New("invalid token")
+
+// KeySet holds the public keys of a JSON Web Key Set. Keys loaded from a URL are cached
+// and refetched when they are older than the TTL or a token uses an unknown key.
+type KeySet struct {
+	load func() ([]byte, error)
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu       sync.Mutex
+	keys     map[string]crypto.PublicKey
+	fetched  time.Time
+	fetching chan struct{}
+}
+
+// NewKeySetFile returns the key set in the JWKS file. The file is only read once.
+func NewKeySetFile(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s: %w", path, err)
+	}
+
+	return &KeySet{keys: keys, now: time.Now}, nil
+}
+
+// NewKeySetURL returns the key set served at url, fetched on first use.
+func NewKeySetURL(url string, ttl time.Duration) *KeySet {
+	if ttl <= 0 {
+		ttl = DefaultJWKSCacheTTL
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	return &KeySet{
+		ttl: ttl,
+		now: time.Now,
+		load: func() ([]byte, error) {
+			resp, err := client.Get(url)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("unexpected status %d fetching %s", resp.StatusCode, url)
+			}
+
+			return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		},
+	}
+}
+
+// Key returns the key with the ID. If the set has a single key it is used for tokens
+// without a key ID.
+func (k *KeySet) Key(kid string) (crypto.PublicKey, error) {
+	var err error
+	if k.load != nil {
+		err = k.refresh(kid)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.keys == nil {
+		if err == nil {
+			err = errors.New("no keys fetched")
+		}
+		return nil, fmt.Errorf("error loading json web keys: %w", err)
+	}
+
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+
+	if kid == "" && len(k.keys) == 1 {
+		for _, v := range k.keys {
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// refresh fetches the keys when they are stale or the key ID is unknown. The lock isn't
+// held during the fetch and only one fetch runs at a time, other lookups keep using the
+// previous keys. On errors the previous keys are kept.
+func (k *KeySet) refresh(kid string) error {
+	k.mu.Lock()
+	now := k.now()
+	_, known := k.keys[kid]
+	stale := k.keys == nil || now.Sub(k.fetched) > k.ttl
+	if !stale && (known || now.Sub(k.fetched) <= jwksMinRefresh) {
+		k.mu.Unlock()
+		return nil
+	}
+
+	if fetching := k.fetching; fetching != nil {
+		empty := k.keys == nil
+		k.mu.Unlock()
+		if empty {
+			<-fetching
+		}
+		return nil
+	}
+
+	done := make(chan struct{})
+	k.fetching = done
+	k.mu.Unlock()
+
+	keys, err := k.fetch()
+
+	k.mu.Lock()
+	k.fetched = now
+	if err == nil {
+		k.keys = keys
+	}
+	k.fetching = nil
+	k.mu.Unlock()
+	close(done)
+
+	return err
+}
+
+// fetch loads and parses the keys.
+func (k *KeySet) fetch() (map[string]crypto.PublicKey, error) {
+	data, err := k.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseJWKS(data)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS returns the RSA, EC and Ed25519 signing keys of the JWKS document by key ID.
+// Other keys are skipped.
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, v := range set.Keys {
+		if v.Use != "" && v.Use != "sig" {
+			continue
+		}
+
+		key, err := v.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", v.Kid, err)
+		}
+		if key != nil {
+			keys[v.Kid] = key
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing keys found")
+	}
+
+	return keys, nil
+}
+
+func (j jwk) publicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := decodeBigInt(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(j.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", j.Crv)
+		}
+		x, err := decodeBigInt(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", j.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, nil
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// JWTConfig configures the JWT validator. Issuer and Audience are required.
+type JWTConfig struct {
+	Issuer   string
+	Audience string
+	// CreatorClaim is the claim used as the creator, DefaultCreatorClaim if empty.
+	// The subject is used if the token doesn't have the claim.
+	CreatorClaim string
+	// TenantClaim is the claim used as the tenant, tokens have no tenant if empty.
+	TenantClaim string
+}
+
+// JWTValidator validates signed JWTs from an identity provider.
+type JWTValidator struct {
+	config JWTConfig
+	keys   *KeySet
+	now    func() time.Time
+}
+
+// NewJWTValidator returns a validator for tokens signed by the keys.
+func NewJWTValidator(c JWTConfig, keys *KeySet) (*JWTValidator, error) {
+	if c.Issuer == "" || c.Audience == "" {
+		return nil, fmt.Errorf("jwt issuer and audience are required")
+	}
+
+	if c.CreatorClaim == "" {
+		c.CreatorClaim = DefaultCreatorClaim
+	}
+
+	return &JWTValidator{config: c, keys: keys, now: time.Now}, nil
+}
+
+// Validate checks the signature, issuer, audience and expiry of the token and returns
+// the identity from its claims.
+func (j *JWTValidator) Validate(token string) (Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, errInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Identity{}, errInvalidToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, errInvalidToken
+	}
+
+	key, err := j.keys.Key(header.Kid)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return Identity{}, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Identity{}, errInvalidToken
+	}
+
+	if err := j.checkClaims(claims); err != nil {
+		return Identity{}, err
+	}
+
+	sub, _ := claims["sub"].(string)
+	creator, _ := claims[j.config.CreatorClaim].(string)
+	if creator == "" {
+		creator = sub
+	}
+	if creator == "" {
+		return Identity{}, fmt.Errorf("token has no %s or sub claim", j.config.CreatorClaim)
+	}
+
+	id := Identity{Creator: creator}
+	if j.config.TenantClaim != "" {
+		id.Tenant, _ = claims[j.config.TenantClaim].(string)
+	}
+
+	return id, nil
+}
+
+func (j *JWTValidator) checkClaims(claims map[string]any) error {
+	if iss, _ := claims["iss"].(string); iss != j.config.Issuer {
+		return fmt.Errorf("token issuer is not %s", j.config.Issuer)
+	}
+
+	var audiences []string
+	switch v := claims["aud"].(type) {
+	case string:
+		audiences = []string{v}
+	case []any:
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+	if !slices.Contains(audiences, j.config.Audience) {
+		return fmt.Errorf("token audience is not %s", j.config.Audience)
+	}
+
+	now := j.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return fmt.Errorf("token is expired")
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("token is not valid yet")
+	}
+
+	return nil
+}
+
+func decodeSegment(s string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// verifySignature checks the JWS signature. The algorithm has to match the key type so
+// a token can't pick a weaker algorithm than the key was issued for.
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+	default:
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			if rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil {
+				return nil
+			}
+		case "PS":
+			if rsa.VerifyPSS(k, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil {
+				return nil
+			}
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[:2] == "ES" && len(sig) == 2*size && k.Curve.Params().BitSize == ecdsaBits(hash) {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			if ecdsa.Verify(k, digest, r, s) {
+				return nil
+			}
+		}
+	case ed25519.PublicKey:
+		if alg == "EdDSA" && ed25519.Verify(k, signed, sig) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid token signature")
+}
+
+// ecdsaBits returns the curve size that goes with the hash of an ES algorithm
+func ecdsaBits(h crypto.Hash) int {
+	switch h {
+	case crypto.SHA256:
+		return 256
+	case crypto.SHA384:
+		return 384
+	default:
+		return 521
+	}
+}
+
+// Identity is the user a token was issued to.
+type Identity struct {
+	Creator string `json:"creator"`
+	Tenant  string `json:"tenant,omitempty"`
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of the context holding the identity. The identity is
+// recorded as the creator of secrets and its tenant is added to the client information.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	ctx = context.WithValue(ctx, identityKey{}, id)
+
+	client := ClientFromContext(ctx)
+	client.Creator = id.Creator
+	if id.Tenant != "" {
+		client.Tenant = id.Tenant
+	}
+
+	return WithClient(ctx, client)
+}
+
+// IdentityFromContext returns the identity the request was authenticated with.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
@@ -17,15 +17,33 @@ limitations under the License.
 package secrets
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/hooksie1/gophemeral/metrics"
 	"github.com/nats-io/nats.go"
 )
 
-type ValidateFunc func(s Secret) error
+var backendDuration = metrics.NewHistogram("gophemeral_backend_duration_seconds", "Latency of backend operations", nil, "operation")
+
+// observe records the duration of a backend operation started at start
+func observe(operation string, start time.Time) {
+	backendDuration.Observe(time.Since(start).Seconds(), operation)
+}
+
+const (
+	// MaxFields is the maximum number of fields a structured secret can have.
+	MaxFields = 20
+	// MaxFieldKeyLength is the maximum length of a field key.
+	MaxFieldKeyLength = 64
+
+	// maxModifyAttempts is how many times a conflicting update is retried
+	maxModifyAttempts = 10
+)
 
 type NATS struct {
 	urls      string
@@ -34,10 +52,23 @@ type NATS struct {
 	opts      []nats.Option
 	js        nats.JetStreamContext
 	kv        nats.KeyValue
-	validator ValidateFunc
+	validator Validator
+	notifiers Notifiers
+
+	idempotency IdempotencyStore
+	ids         IDGenerator
+}
+
+type BackendOption func(*NATS)
+
+// WithNotifier adds a notifier that receives the lifecycle events of every secret.
+func WithNotifier(n Notifier) BackendOption {
+	return func(b *NATS) {
+		b.notifiers = append(b.notifiers, n)
+	}
 }
 
-func NewNatsBackend(nc *nats.Conn, v ValidateFunc) (*NATS, error) {
+func NewNatsBackend(nc *nats.Conn, v Validator, opts ...BackendOption) (*NATS, error) {
 	js, err := nc.JetStream()
 	if err != nil {
 		return nil, err
@@ -48,30 +79,53 @@ func NewNatsBackend(nc *nats.Conn, v ValidateFunc) (*NATS, error) {
 		return nil, err
 	}
 
-	return &NATS{
+	b := &NATS{
 		conn:      nc,
 		bucket:    "secrets",
 		js:        js,
 		kv:        kv,
 		validator: v,
-	}, nil
+	}
+
+	for _, v := range opts {
+		v(b)
+	}
+
+	return b, nil
 }
 
-func DefaultValidator(length int) ValidateFunc {
-	return func(s Secret) error {
-		if len(s.Text) > length {
-			return NewSecretError(http.StatusBadRequest, fmt.Sprintf("secret length cannot be greater than %d", length))
+// validateFields checks the number of fields and their keys. Value lengths are limited
+// by the policy.
+func validateFields(fields []Field) error {
+	if len(fields) > MaxFields {
+		return NewSecretError(http.StatusBadRequest, fmt.Sprintf("secret cannot have more than %d fields", MaxFields))
+	}
+
+	keys := make(map[string]bool, len(fields))
+	for _, v := range fields {
+		if v.Key == "" {
+			return NewSecretError(http.StatusBadRequest, "field key cannot be empty")
+		}
+
+		if len(v.Key) > MaxFieldKeyLength {
+			return NewSecretError(http.StatusBadRequest, fmt.Sprintf("field key cannot be longer than %d", MaxFieldKeyLength))
 		}
 
-		return nil
+		if keys[v.Key] {
+			return NewSecretError(http.StatusBadRequest, fmt.Sprintf("duplicate field %s", v.Key))
+		}
+		keys[v.Key] = true
 	}
+
+	return nil
 }
 
-func (n *NATS) Validate(s Secret) error {
-	return n.validator(s)
+func (n *NATS) Validate(ctx context.Context, s *Secret) error {
+	return n.validator.Validate(ctx, s)
 }
 
 func (n *NATS) Write(s Secret) error {
+	defer observe("write", time.Now())
 	data, err := json.Marshal(s)
 	if err != nil {
 		return NewSecretError(400, err.Error())
@@ -84,10 +138,70 @@ func (n *NATS) Write(s Secret) error {
 	return nil
 }
 
+// Create writes a new secret. It returns ErrIDExists if the ID is already used.
+func (n *NATS) Create(s Secret) error {
+	defer observe("create", time.Now())
+	data, err := json.Marshal(s)
+	if err != nil {
+		return NewSecretError(400, err.Error())
+	}
+
+	_, err = n.kv.Create(s.ID, data)
+	if err != nil && errors.Is(err, nats.ErrKeyExists) {
+		return ErrIDExists
+	}
+
+	return err
+}
+
+// Modify changes the secret with a revision checked update or delete, retrying with the
+// new value when the secret was changed concurrently.
+func (n *NATS) Modify(id string, fn func(*Secret) (bool, error)) (Secret, error) {
+	defer observe("modify", time.Now())
+
+	for attempt := 0; attempt < maxModifyAttempts; attempt++ {
+		entry, err := n.kv.Get(id)
+		if err != nil && (errors.Is(err, nats.ErrKeyNotFound) || errors.Is(err, nats.ErrInvalidKey)) {
+			return Secret{}, NewSecretError(404, errSecretNotFound.Error())
+		}
+		if err != nil {
+			return Secret{}, err
+		}
+
+		var s Secret
+		if err := json.Unmarshal(entry.Value(), &s); err != nil {
+			return Secret{}, err
+		}
+
+		remove, err := fn(&s)
+		if err != nil {
+			return s, err
+		}
+
+		if remove {
+			err = n.kv.Delete(id, nats.LastRevision(entry.Revision()))
+		} else {
+			data, merr := json.Marshal(s)
+			if merr != nil {
+				return s, merr
+			}
+			_, err = n.kv.Update(id, data, entry.Revision())
+		}
+
+		// the key exists error is returned when the revision doesn't match
+		if err == nil || !errors.Is(err, nats.ErrKeyExists) {
+			return s, err
+		}
+	}
+
+	return Secret{}, NewSecretError(http.StatusServiceUnavailable, "secret is busy, try again")
+}
+
 func (n *NATS) Read(id string) (Secret, error) {
+	defer observe("read", time.Now())
 	var secret Secret
 	v, err := n.kv.Get(id)
-	if err != nil && errors.Is(err, nats.ErrKeyNotFound) {
+	if err != nil && (errors.Is(err, nats.ErrKeyNotFound) || errors.Is(err, nats.ErrInvalidKey)) {
 		return secret, NewSecretError(404, errSecretNotFound.Error())
 	}
 
@@ -104,5 +218,25 @@ func (n *NATS) Read(id string) (Secret, error) {
 }
 
 func (n *NATS) Delete(id string) error {
+	defer observe("delete", time.Now())
 	return n.kv.Delete(id)
 }
+
+func (n *NATS) Keys() ([]string, error) {
+	defer observe("keys", time.Now())
+	keys, err := n.kv.Keys()
+	if err != nil && errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
+
+	return keys, err
+}
+
+func (n *NATS) Notify(ctx context.Context, e Event) {
+	n.notifiers.Notify(ctx, e)
+}
+
+// SealWebhookSecret encrypts a creator webhook secret with the first notifier that can.
+func (n *NATS) SealWebhookSecret(secret string) (string, error) {
+	return n.notifiers.SealWebhookSecret(secret)
+}
@@ -0,0 +1,394 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultPolicy is the name of the profile used when a request has no profile or tenant.
+	DefaultPolicy = "default"
+
+	// ActionDeny rejects secrets with a classification.
+	ActionDeny = "deny"
+	// ActionSingleView limits secrets with a classification to one view.
+	ActionSingleView = "single_view"
+)
+
+// Validator checks that a secret can be stored for the request in the context.
+type Validator interface {
+	Validate(context.Context, *Secret) error
+}
+
+// Rule is a single check a secret must pass before it is stored. Rules see the secret
+// before it is encrypted and can change it, for example to lower the views.
+type Rule func(s *Secret) error
+
+// Rules checks each rule in order and returns the first error.
+type Rules []Rule
+
+func (r Rules) Check(s *Secret) error {
+	for _, v := range r {
+		if err := v(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Policy is a named set of limits for secrets. Zero values are not enforced. Length limits
+// apply to the text and to each field value of a structured secret.
+type Policy struct {
+	MaxRunes          int           `mapstructure:"max_runes"`
+	MaxBytes          int           `mapstructure:"max_bytes"`
+	MinViews          int           `mapstructure:"min_views"`
+	MaxViews          int           `mapstructure:"max_views"`
+	MaxTTL            time.Duration `mapstructure:"max_ttl"`
+	RequirePassphrase bool          `mapstructure:"require_passphrase"`
+	// AllowedRecipients are path.Match patterns such as *@example.com. If set every
+	// secret needs at least one recipient and all of them must match a pattern.
+	AllowedRecipients []string `mapstructure:"allowed_recipients"`
+	// DenyPatterns are regular expressions that reject a secret if its content matches.
+	DenyPatterns []string `mapstructure:"deny_patterns"`
+	// Classifications sets the action taken when a type of credential is detected.
+	Classifications map[Classification]string `mapstructure:"classifications"`
+}
+
+// Rules returns the rules that enforce the policy.
+func (p Policy) Rules() (Rules, error) {
+	var rules Rules
+	if len(p.Classifications) > 0 {
+		rule, err := ClassificationActions(p.Classifications)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	if p.MaxRunes > 0 {
+		rules = append(rules, MaxRunes(p.MaxRunes))
+	}
+
+	if p.MaxBytes > 0 {
+		rules = append(rules, MaxBytes(p.MaxBytes))
+	}
+
+	if p.MinViews > 0 || p.MaxViews > 0 {
+		rules = append(rules, ViewRange(p.MinViews, p.MaxViews))
+	}
+
+	if p.MaxTTL > 0 {
+		rules = append(rules, MaxTTL(p.MaxTTL))
+	}
+
+	if p.RequirePassphrase {
+		rules = append(rules, RequirePassphrase())
+	}
+
+	if len(p.AllowedRecipients) > 0 {
+		rule, err := AllowedRecipients(p.AllowedRecipients)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	if len(p.DenyPatterns) > 0 {
+		rule, err := DenyPatterns(p.DenyPatterns)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// PolicyConfig holds the policy profiles. Tenants maps a tenant to the profile its
+// secrets are checked against. MaxRunes is the length limit of profiles that don't set
+// max_runes or max_bytes.
+type PolicyConfig struct {
+	Default  string            `mapstructure:"default"`
+	Profiles map[string]Policy `mapstructure:"profiles"`
+	Tenants  map[string]string `mapstructure:"tenants"`
+	MaxRunes int               `mapstructure:"max_runes"`
+}
+
+// PolicyEngine validates secrets against the profile selected for the request. The
+// profile set with WithProfile is used first, then the profile of the client's tenant
+// and then the default profile.
+type PolicyEngine struct {
+	def      string
+	profiles map[string]Rules
+	tenants  map[string]string
+}
+
+func NewPolicyEngine(c PolicyConfig) (*PolicyEngine, error) {
+	if c.Default == "" {
+		c.Default = DefaultPolicy
+	}
+
+	if _, ok := c.Profiles[c.Default]; !ok {
+		return nil, fmt.Errorf("default policy %s does not exist", c.Default)
+	}
+
+	for tenant, profile := range c.Tenants {
+		if _, ok := c.Profiles[profile]; !ok {
+			return nil, fmt.Errorf("policy %s for tenant %s does not exist", profile, tenant)
+		}
+	}
+
+	profiles := make(map[string]Rules, len(c.Profiles))
+	for name, policy := range c.Profiles {
+		if policy.MaxRunes == 0 && policy.MaxBytes == 0 {
+			policy.MaxRunes = c.MaxRunes
+		}
+
+		rules, err := policy.Rules()
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: %w", name, err)
+		}
+		profiles[name] = rules
+	}
+
+	return &PolicyEngine{
+		def:      c.Default,
+		profiles: profiles,
+		tenants:  c.Tenants,
+	}, nil
+}
+
+// DefaultValidator returns a policy engine with a single profile that limits the
+// secret to length characters.
+func DefaultValidator(length int) *PolicyEngine {
+	return &PolicyEngine{
+		def:      DefaultPolicy,
+		profiles: map[string]Rules{DefaultPolicy: {MaxRunes(length)}},
+	}
+}
+
+// Profile returns the name of the profile used for the request.
+func (p *PolicyEngine) Profile(ctx context.Context) string {
+	if name := ProfileFromContext(ctx); name != "" {
+		return name
+	}
+
+	if name, ok := p.tenants[ClientFromContext(ctx).Tenant]; ok {
+		return name
+	}
+
+	return p.def
+}
+
+// Validate checks the structure of the secret and then the rules of the profile.
+func (p *PolicyEngine) Validate(ctx context.Context, s *Secret) error {
+	if s.Webhook != "" {
+		if err := ValidateWebhook(s.Webhook); err != nil {
+			return err
+		}
+	}
+
+	if err := validateFields(s.Fields); err != nil {
+		return err
+	}
+
+	name := p.Profile(ctx)
+	rules, ok := p.profiles[name]
+	if !ok {
+		return fmt.Errorf("policy %s does not exist", name)
+	}
+
+	return rules.Check(s)
+}
+
+type profileKey struct{}
+
+// WithProfile returns a copy of the context that selects the policy profile.
+func WithProfile(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, profileKey{}, name)
+}
+
+// ProfileFromContext returns the policy profile selected in the context.
+func ProfileFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(profileKey{}).(string)
+	return name
+}
+
+// contents returns the text and every field value of the secret
+func contents(s Secret) []string {
+	values := []string{s.Text}
+	for _, v := range s.Fields {
+		values = append(values, v.Value)
+	}
+
+	return values
+}
+
+// MaxRunes limits the number of characters in the text and field values.
+func MaxRunes(n int) Rule {
+	return func(s *Secret) error {
+		for _, v := range contents(*s) {
+			if utf8.RuneCountInString(v) > n {
+				return NewSecretError(http.StatusBadRequest, fmt.Sprintf("secret length cannot be greater than %d characters", n))
+			}
+		}
+		return nil
+	}
+}
+
+// MaxBytes limits the size in bytes of the text and field values.
+func MaxBytes(n int) Rule {
+	return func(s *Secret) error {
+		for _, v := range contents(*s) {
+			if len(v) > n {
+				return NewSecretError(http.StatusBadRequest, fmt.Sprintf("secret size cannot be greater than %d bytes", n))
+			}
+		}
+		return nil
+	}
+}
+
+// ViewRange limits the number of views. A zero min or max is not checked.
+func ViewRange(min, max int) Rule {
+	return func(s *Secret) error {
+		if min > 0 && s.Views < min {
+			return NewSecretError(http.StatusBadRequest, fmt.Sprintf("views cannot be less than %d", min))
+		}
+		if max > 0 && s.Views > max {
+			return NewSecretError(http.StatusBadRequest, fmt.Sprintf("views cannot be greater than %d", max))
+		}
+		return nil
+	}
+}
+
+// MaxTTL limits how long a secret lives. Secrets without a TTL use DefaultTTL, canaries
+// without a TTL aren't limited.
+func MaxTTL(d time.Duration) Rule {
+	return func(s *Secret) error {
+		ttl := time.Duration(s.TTL) * time.Second
+		if ttl == 0 && s.Canary {
+			return nil
+		}
+		if ttl == 0 {
+			ttl = DefaultTTL
+		}
+		if ttl > d {
+			return NewSecretError(http.StatusBadRequest, fmt.Sprintf("ttl cannot be longer than %s", d))
+		}
+		return nil
+	}
+}
+
+// RequirePassphrase requires the creator to set a passphrase.
+func RequirePassphrase() Rule {
+	return func(s *Secret) error {
+		if s.Passphrase == "" {
+			return NewSecretError(http.StatusBadRequest, "a passphrase is required")
+		}
+		return nil
+	}
+}
+
+// AllowedRecipients requires at least one recipient and every recipient to match
+// one of the patterns. Matching is case insensitive.
+func AllowedRecipients(patterns []string) (Rule, error) {
+	lower := make([]string, len(patterns))
+	for i, v := range patterns {
+		lower[i] = strings.ToLower(v)
+		if _, err := path.Match(lower[i], ""); err != nil {
+			return nil, fmt.Errorf("bad recipient pattern %s: %w", v, err)
+		}
+	}
+
+	return func(s *Secret) error {
+		if len(s.Recipients) == 0 {
+			return NewSecretError(http.StatusBadRequest, "a recipient is required")
+		}
+
+		for _, r := range s.Recipients {
+			if !matchAny(lower, strings.ToLower(r)) {
+				return NewSecretError(http.StatusBadRequest, fmt.Sprintf("recipient %s is not allowed", r))
+			}
+		}
+		return nil
+	}, nil
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, v := range patterns {
+		if ok, _ := path.Match(v, s); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClassificationActions denies or limits the views of secrets based on their
+// classifications. The actions run before the other rules of a policy.
+func ClassificationActions(actions map[Classification]string) (Rule, error) {
+	for k, v := range actions {
+		if v != ActionDeny && v != ActionSingleView {
+			return nil, fmt.Errorf("unknown action %s for %s", v, k)
+		}
+	}
+
+	return func(s *Secret) error {
+		for _, v := range s.Classifications {
+			switch actions[v] {
+			case ActionDeny:
+				return NewSecretError(http.StatusBadRequest, fmt.Sprintf("secrets containing a %s are not allowed", strings.ReplaceAll(string(v), "_", " ")))
+			case ActionSingleView:
+				s.Views = 1
+			}
+		}
+		return nil
+	}, nil
+}
+
+// DenyPatterns rejects secrets whose text or field values match any of the regular
+// expressions. The content is never part of the error.
+func DenyPatterns(patterns []string) (Rule, error) {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, v := range patterns {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, fmt.Errorf("bad deny pattern %s: %w", v, err)
+		}
+		res[i] = re
+	}
+
+	return func(s *Secret) error {
+		for _, v := range contents(*s) {
+			for _, re := range res {
+				if re.MatchString(v) {
+					return NewSecretError(http.StatusBadRequest, "secret content is not allowed by policy")
+				}
+			}
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,203 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces sensitive values in logs.
+const Redacted = "[REDACTED]"
+
+// redactedKeys are keys whose values never reach a log sink: passwords, passphrases,
+// secret text and the headers carrying them.
+const redactedKeys = `password|passphrase|x-password|x-passphrase|text|webhook_secret|authorization`
+
+var redactions = []struct {
+	pattern *regexp.Regexp
+	repl    string
+}{
+	// "password": "value" in JSON
+	{regexp.MustCompile(`(?i)("(?:` + redactedKeys + `)"\s*:\s*)"(?:[^"\\]|\\.)*"`), `${1}"` + Redacted + `"`},
+	// X-Password: value in dumped headers
+	{regexp.MustCompile(`(?i)(\b(?:x-password|x-passphrase|authorization):\s*)[^\r\n]+`), `${1}` + Redacted},
+}
+
+var (
+	// password="value" and password=value in key value logs and query strings
+	kvKey = regexp.MustCompile(`(?i)\b(?:` + redactedKeys + `)=`)
+	// the next key of a key value log ends an unquoted value, so values with spaces
+	// are redacted completely
+	kvNext   = regexp.MustCompile(`\s+[A-Za-z_][\w.-]*=`)
+	kvQuoted = regexp.MustCompile(`^"(?:[^"\\]|\\.)*"`)
+
+	// Password:value in printed structs
+	structKey = regexp.MustCompile(`(?:^|[\s{&])(?:Password|Passphrase|Text|WebhookSecret):`)
+	// structNext matches the next field of a printed secret. Values can hold spaces
+	// and colons so only known field names end them.
+	structNext = regexp.MustCompile(` (?:` + strings.Join(knownFields(Secret{}, Field{}, Metadata{}, Event{}, Client{}), "|") + `):`)
+)
+
+// knownFields returns the field names of the structs
+func knownFields(v ...any) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, s := range v {
+		t := reflect.TypeOf(s)
+		for i := 0; i < t.NumField(); i++ {
+			if name := t.Field(i).Name; t.Field(i).IsExported() && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
+
+// Redact replaces the values of sensitive keys in a log line.
+func Redact(s string) string {
+	for _, v := range redactions {
+		s = v.pattern.ReplaceAllString(s, v.repl)
+	}
+
+	s = redactValues(s, kvKey, kvValueEnd)
+	return redactValues(s, structKey, structValueEnd)
+}
+
+// redactValues replaces the value after every match of key. end returns the length of
+// the value at the start of its argument.
+func redactValues(s string, key *regexp.Regexp, end func(string) int) string {
+	var b strings.Builder
+	for {
+		loc := key.FindStringIndex(s)
+		if loc == nil {
+			b.WriteString(s)
+			return b.String()
+		}
+
+		b.WriteString(s[:loc[1]])
+		b.WriteString(Redacted)
+		s = s[loc[1]:]
+		s = s[end(s):]
+	}
+}
+
+// kvValueEnd ends a value at its closing quote, the next parameter of a query string,
+// the next key or the end of the line.
+func kvValueEnd(s string) int {
+	if loc := kvQuoted.FindStringIndex(s); loc != nil {
+		return loc[1]
+	}
+
+	n := lineEnd(s)
+	if i := strings.IndexAny(s[:n], "&\""); i >= 0 {
+		n = i
+	}
+	if loc := kvNext.FindStringIndex(s[:n]); loc != nil {
+		n = loc[0]
+	}
+
+	return n
+}
+
+// structValueEnd ends a value at the next known field. The last field ends at the
+// last closing brace on the line, which may redact more than the value but never less.
+func structValueEnd(s string) int {
+	n := lineEnd(s)
+	if loc := structNext.FindStringIndex(s[:n]); loc != nil {
+		return loc[0]
+	}
+
+	if i := strings.LastIndexAny(s[:n], "}]"); i >= 0 {
+		return i
+	}
+
+	return n
+}
+
+func lineEnd(s string) int {
+	if i := strings.IndexAny(s, "\r\n"); i >= 0 {
+		return i
+	}
+
+	return len(s)
+}
+
+// String prints the secret with its sensitive values redacted, so a secret passed to
+// a logger with %v or %+v never leaks.
+func (s Secret) String() string {
+	type secret Secret
+	return fmt.Sprintf("%+v", secret(s.redacted()))
+}
+
+// GoString prints the secret with its sensitive values redacted for %#v.
+func (s Secret) GoString() string {
+	type secret Secret
+	return fmt.Sprintf("%#v", secret(s.redacted()))
+}
+
+// redacted returns a copy of the secret without the values that must not be logged
+func (s Secret) redacted() Secret {
+	for _, v := range []*string{&s.Text, &s.Password, &s.Passphrase, &s.WebhookSecret} {
+		if *v != "" {
+			*v = Redacted
+		}
+	}
+
+	if s.Fields != nil {
+		fields := make([]Field, len(s.Fields))
+		for i, v := range s.Fields {
+			fields[i] = Field{Key: v.Key, Value: Redacted}
+		}
+		s.Fields = fields
+	}
+
+	return s
+}
+
+// RedactingWriter redacts every write before passing it on. The log package writes
+// each entry with a single call so entries are never split between writes.
+type RedactingWriter struct {
+	w io.Writer
+}
+
+// NewRedactingWriter returns a writer that redacts sensitive values before writing to w.
+func NewRedactingWriter(w io.Writer) *RedactingWriter {
+	return &RedactingWriter{w: w}
+}
+
+func (r *RedactingWriter) Write(p []byte) (int, error) {
+	if _, err := r.w.Write([]byte(Redact(string(p)))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close closes the underlying writer if it's an io.Closer.
+func (r *RedactingWriter) Close() error {
+	if c, ok := r.w.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tt := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{name: "json", input: `{"id":"abc","password":"hunter2","text":"my \"secret\""}`, expected: `{"id":"abc","password":"[REDACTED]","text":"[REDACTED]"}`},
+		{name: "query", input: `path=/api/secret?id=abc&password=hunter2 status=200`, expected: `path=/api/secret?id=abc&password=[REDACTED] status=200`},
+		{name: "key value", input: `level=INFO passphrase="two words" id=abc`, expected: `level=INFO passphrase=[REDACTED] id=abc`},
+		{name: "header", input: "X-Password: hunter2\r\nAccept: */*", expected: "X-Password: [REDACTED]\r\nAccept: */*"},
+		{name: "struct", input: `{ID:abc Text:hunter2 Password:p4ss Views:1}`, expected: `{ID:abc Text:[REDACTED] Password:[REDACTED] Views:1}`},
+		{name: "query with spaces", input: `path=/hx?text=correct horse battery&views=1`, expected: `path=/hx?text=[REDACTED]&views=1`},
+		{name: "key value with spaces", input: `level=INFO text=correct horse battery id=abc`, expected: `level=INFO text=[REDACTED] id=abc`},
+		{name: "key value at end", input: `level=INFO password=correct horse battery`, expected: `level=INFO password=[REDACTED]`},
+		{name: "key value next sensitive", input: `text=a b password=c d`, expected: `text=[REDACTED] password=[REDACTED]`},
+		{name: "struct with spaces", input: `{ID:abc Text:correct horse battery Password:p4ss word Views:1}`, expected: `{ID:abc Text:[REDACTED] Password:[REDACTED] Views:1}`},
+		{name: "struct last field", input: `{ID:abc Text:correct horse: battery}`, expected: `{ID:abc Text:[REDACTED]}`},
+		{name: "untouched", input: `msg="bad password" plaintext=ok`, expected: `msg="bad password" plaintext=ok`},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			if got := Redact(v.input); got != v.expected {
+				t.Errorf("expected %s but got %s", v.expected, got)
+			}
+		})
+	}
+}
+
+func TestRedactSecretStruct(t *testing.T) {
+	s := Secret{ID: "abc", Text: "correct horse battery", Password: "two words", Passphrase: "a b c", Views: 2}
+	for _, format := range []string{"%+v", "%v", "%#v"} {
+		got := Redact(fmt.Sprintf(format, s))
+		for _, v := range []string{"correct", "horse", "battery", "two words", "a b c"} {
+			if strings.Contains(got, v) {
+				t.Errorf("expected %q to be redacted from %s", v, got)
+			}
+		}
+	}
+}
+
+func TestRedactingWriter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(NewRedactingWriter(&buf), "", 0)
+	logger.Printf("request %s", `{"password":"hunter2"}`)
+
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Errorf("expected password to be redacted but got %s", buf.String())
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	if id := RequestID("abc-123"); id != "abc-123" {
+		t.Errorf("expected client request ID to be kept but got %s", id)
+	}
+
+	for _, v := range []string{"", "has space", `quote"`, "new\nline", strings.Repeat("a", MaxRequestIDLength+1)} {
+		if id := RequestID(v); id == v || id == "" {
+			t.Errorf("expected a new request ID for %q but got %q", v, id)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	// RequestIDHeader carries the request ID over HTTP and NATS.
+	RequestIDHeader = "X-Request-ID"
+	// MaxRequestIDLength is the longest request ID accepted from a client.
+	MaxRequestIDLength = 128
+)
+
+type requestIDKey struct{}
+
+// RequestID returns id if it is a usable request ID, otherwise a new one is created.
+// IDs sent by clients end up in logs so only printable ASCII without spaces or quotes
+// is accepted.
+func RequestID(id string) string {
+	if id == "" || len(id) > MaxRequestIDLength {
+		return ksuid.New().String()
+	}
+
+	for _, v := range id {
+		if v <= ' ' || v > '~' || v == '"' || v == '\\' {
+			return ksuid.New().String()
+		}
+	}
+
+	return id
+}
+
+// WithRequestID returns a copy of the context holding the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID stored in the context.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestMsg returns a NATS message carrying the request ID of the context
+func requestMsg(ctx context.Context, subject string, data []byte) *nats.Msg {
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	if id := RequestIDFromContext(ctx); id != "" {
+		msg.Header.Set(RequestIDHeader, id)
+	}
+
+	return msg
+}
@@ -17,27 +17,110 @@ limitations under the License.
 package secrets
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
+	"unicode/utf8"
 
-	"github.com/segmentio/ksuid"
+	"github.com/hooksie1/gophemeral/tracing"
+)
+
+const (
+	// DefaultTTL is how long a secret lives when no TTL is set.
+	DefaultTTL = 7 * 24 * time.Hour
+	// MaxFailedAttempts is the number of wrong passwords after which a secret is locked.
+	MaxFailedAttempts = 5
+	// LockoutDuration is how long a secret can't be looked up after MaxFailedAttempts
+	// wrong passwords. Secrets are locked instead of destroyed so someone who only
+	// knows the link can't destroy the secret before the recipient opens it.
+	LockoutDuration = 15 * time.Minute
+	// MaxDescriptionLength is the maximum number of characters in a description.
+	MaxDescriptionLength = 200
 )
 
 var (
 	errSecretNotFound = fmt.Errorf("secret not found")
 	errBadAuth        = fmt.Errorf("bad password")
+	errLocked         = NewSecretError(http.StatusTooManyRequests, "too many wrong passwords, try again later")
 )
 
 type Secret struct {
-	ID       string `json:"id"`
-	Text     string `json:"text"`
-	Password string `json:"password"`
-	Views    int    `json:"views"`
+	ID         string    `json:"id"`
+	Text       string    `json:"text"`
+	Password   string    `json:"password"`
+	Views      int       `json:"views"`
+	Fields     []Field   `json:"fields,omitempty"`
+	Structured bool      `json:"structured,omitempty"`
+	TTL        int       `json:"ttl,omitempty"`
+	Created    time.Time `json:"created,omitempty"`
+	Expires    time.Time `json:"expires,omitempty"`
+	Attempts   int       `json:"attempts,omitempty"`
+
+	// LockedUntil is set after MaxFailedAttempts wrong passwords, no lookups are tried
+	// until then.
+	LockedUntil time.Time `json:"locked_until,omitempty"`
+
+	// Description is stored in plain text and shown before the secret is revealed.
+	Description string `json:"description,omitempty"`
+
+	// Passphrase is chosen by the creator and needed along with the password to decrypt
+	// the secret. It is never stored.
+	Passphrase    string   `json:"passphrase,omitempty"`
+	HasPassphrase bool     `json:"has_passphrase,omitempty"`
+	Recipients    []string `json:"recipients,omitempty"`
+
+	// Classifications are the types of credentials detected in the secret.
+	Classifications []Classification `json:"classifications,omitempty"`
+
+	Webhook       string `json:"webhook,omitempty"`
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+
+	// Canary secrets never run out of views, every lookup is served the decoy and
+	// sends a canary event.
+	Canary bool   `json:"canary,omitempty"`
+	Decoy  string `json:"decoy,omitempty"`
+
+	// Creator and Tenant identify who created the secret. They are set from the
+	// authenticated request, never from the request body.
+	Creator string `json:"creator,omitempty"`
+	Tenant  string `json:"tenant,omitempty"`
+}
+
+// Field is a single key/value pair of a structured secret. Fields keep the order
+// they were created in.
+type Field struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// payload is the plain text that is encrypted for structured secrets. The text and
+// all of the fields are encrypted together as one value.
+type payload struct {
+	Text   string  `json:"text,omitempty"`
+	Fields []Field `json:"fields,omitempty"`
+}
+
+// Expired returns true if the secret has an expiration that has passed.
+func (s Secret) Expired(now time.Time) bool {
+	return !s.Expires.IsZero() && now.After(s.Expires)
+}
+
+// Field returns the value of the field with the given key.
+func (s Secret) Field(key string) (string, bool) {
+	for _, v := range s.Fields {
+		if v.Key == key {
+			return v.Value, true
+		}
+	}
+
+	return "", false
 }
 
 // generateString takes an int and generates a random string based on the int size.
@@ -67,43 +150,130 @@ func boti(bs []byte) int {
 	return int(binary.BigEndian.Uint64(b[:]))
 }
 
-func AddSecret(w Writer, s Secret) (Secret, error) {
+// AddSecret encrypts the secret with a generated password and stores it. The TTL is in
+// seconds and defaults to DefaultTTL. Canaries without a TTL never expire.
+func AddSecret(ctx context.Context, w Writer, s Secret) (Secret, error) {
+	ctx, span := tracing.Start(ctx, "secrets.AddSecret", tracing.KindInternal)
+	defer span.End()
+
+	s, err := addSecret(ctx, w, s)
+	span.SetError(err)
+	span.SetAttribute("secret.id", s.ID)
+
+	return s, err
+}
+
+func addSecret(ctx context.Context, w Writer, s Secret) (Secret, error) {
 	pass := generateString(24)
-	s.ID = ksuid.New().String()
+	id, err := newID(w)
+	if err != nil {
+		return Secret{}, err
+	}
+	s.ID = id
+
+	client := ClientFromContext(ctx)
+	s.Creator = client.Creator
+	s.Tenant = client.Tenant
+
+	if s.Canary {
+		if err := setDecoy(&s); err != nil {
+			return Secret{}, err
+		}
+	} else {
+		s.Decoy = ""
+	}
 
 	if s.Views < 1 {
 		return Secret{}, NewSecretError(http.StatusBadRequest, "views must be greater than 0")
 	}
 
-	if err := w.Validate(s); err != nil {
+	if s.TTL < 0 {
+		return Secret{}, NewSecretError(http.StatusBadRequest, "ttl cannot be negative")
+	}
+
+	if err := validateDescription(s.Description); err != nil {
 		return Secret{}, err
 	}
 
-	encryptedText, err := encrypt([]byte(s.Text), pass)
+	s.Classifications = nil
+	if !s.Canary {
+		s.Classifications = Classify(s)
+	}
+
+	if err := w.Validate(ctx, &s); err != nil {
+		return Secret{}, err
+	}
+
+	s.WebhookSecret, err = sealWebhookSecret(w, s.WebhookSecret)
+	if err != nil {
+		return Secret{}, err
+	}
+
+	s.Created = time.Now().UTC()
+	s.Expires = time.Time{}
+	if s.TTL > 0 || !s.Canary {
+		ttl := DefaultTTL
+		if s.TTL > 0 {
+			ttl = time.Duration(s.TTL) * time.Second
+		}
+		s.Expires = s.Created.Add(ttl)
+	}
+	s.Attempts = 0
+	s.Structured = false
+
+	plaintext := []byte(s.Text)
+	if len(s.Fields) > 0 {
+		data, err := json.Marshal(payload{Text: s.Text, Fields: s.Fields})
+		if err != nil {
+			return Secret{}, fmt.Errorf("Write: %w", err)
+		}
+		plaintext = data
+		s.Structured = true
+	}
+
+	encryptedText, err := tracedEncrypt(ctx, plaintext, encryptionKey(pass, s.Passphrase))
 	if err != nil {
 		return Secret{}, fmt.Errorf("Write: %w", err)
 	}
 
 	s.Text = string(toBase64(encryptedText))
+	s.Fields = nil
+	s.HasPassphrase = s.Passphrase != ""
+	s.Passphrase = ""
 
-	if err := w.Write(s); err != nil {
+	if err := storeNewSecret(ctx, w, &s); err != nil {
 		return Secret{}, err
 	}
 
+	notify(ctx, w, s, EventCreated)
+	for _, v := range s.Classifications {
+		classifications.Inc(string(v))
+	}
+
 	// don't set password until here so it's not written in the DB
 	s.Password = pass
 	s.Text = ""
+	s.Structured = false
 
 	return s, nil
 }
 
-func GetSecret(s Secret, b Backend) (Secret, error) {
+// GetSecret decrypts the secret with the password and uses up one view. Expired secrets
+// are deleted and after MaxFailedAttempts wrong passwords the secret is locked for
+// LockoutDuration.
+func GetSecret(ctx context.Context, s Secret, b Backend) (Secret, error) {
+	ctx, span := tracing.Start(ctx, "secrets.GetSecret", tracing.KindInternal)
+	defer span.End()
+	span.SetAttribute("secret.id", NormalizeID(s.ID))
 
-	if err := checkLength(s.Password); err != nil {
-		return Secret{}, NewSecretError(http.StatusUnauthorized, errBadAuth.Error())
-	}
+	secret, err := getSecret(ctx, s, b)
+	span.SetError(err)
+
+	return secret, err
+}
 
-	secret, err := b.Read(s.ID)
+func getSecret(ctx context.Context, s Secret, b Backend) (Secret, error) {
+	secret, err := readSecret(ctx, b, NormalizeID(s.ID))
 	if err != nil && errors.Is(err, errSecretNotFound) {
 		return Secret{}, NewSecretError(http.StatusNotFound, errSecretNotFound.Error())
 	}
@@ -111,31 +281,251 @@ func GetSecret(s Secret, b Backend) (Secret, error) {
 		return Secret{}, err
 	}
 
+	if secret.Expired(time.Now()) {
+		if err := deleteSecret(ctx, b, secret.ID); err != nil {
+			return Secret{}, err
+		}
+		notify(ctx, b, secret, EventExpired)
+		return Secret{}, NewSecretError(http.StatusNotFound, errSecretNotFound.Error())
+	}
+
+	// canaries alert on any lookup, even without the right password
+	if secret.Canary {
+		notify(ctx, b, secret, EventCanary)
+		return Secret{
+			Views: secret.Views,
+			Text:  secret.Decoy,
+		}, nil
+	}
+
+	if secret.LockedUntil.After(time.Now()) {
+		return Secret{}, errLocked
+	}
+
+	if err := checkLength(s.Password); err != nil {
+		return Secret{}, NewError(CodeBadPassword, http.StatusUnauthorized, errBadAuth.Error())
+	}
+
 	decodedSecret, err := fromBase64(secret.Text)
 	if err != nil {
 		return Secret{}, fmt.Errorf("read: %w", err)
 	}
 
-	decryptedMessage, err := decrypt(decodedSecret, s.Password)
+	decryptedMessage, err := tracedDecrypt(ctx, decodedSecret, encryptionKey(s.Password, s.Passphrase))
 	if err != nil {
-		return Secret{}, fmt.Errorf("read: %w", err)
+		return Secret{}, failedAttempt(ctx, secret, b)
 	}
 
-	secret.Views = secret.Views - 1
-
-	if secret.Views < 1 {
-		if err := b.Delete(secret.ID); err != nil {
-			return Secret{}, err
+	var p payload
+	if secret.Structured {
+		if err := json.Unmarshal(decryptedMessage, &p); err != nil {
+			return Secret{}, fmt.Errorf("read: %w", err)
 		}
 	} else {
-		if err := b.Write(secret); err != nil {
-			return Secret{}, err
+		p.Text = string(decryptedMessage)
+	}
+
+	// the view is used with a revision checked update so concurrent lookups can't
+	// share the last view
+	secret, err = modifySecret(ctx, b, secret.ID, func(v *Secret) (bool, error) {
+		if v.Views < 1 {
+			return false, NewSecretError(http.StatusNotFound, errSecretNotFound.Error())
 		}
+		v.Views--
+		return v.Views < 1, nil
+	})
+	if err != nil {
+		return Secret{}, err
+	}
+
+	notify(ctx, b, secret, EventViewed)
+	if secret.Views < 1 {
+		notify(ctx, b, secret, EventBurned)
 	}
 
 	return Secret{
-		Views: secret.Views,
-		Text:  string(decryptedMessage),
+		Views:  secret.Views,
+		Text:   p.Text,
+		Fields: p.Fields,
 	}, nil
 
 }
+
+// Metadata is the information about a secret that can be shown without using a view.
+type Metadata struct {
+	ID              string           `json:"id"`
+	Description     string           `json:"description,omitempty"`
+	Passphrase      bool             `json:"passphrase"`
+	Classifications []Classification `json:"classifications,omitempty"`
+	Views           int              `json:"views"`
+	Creator         string           `json:"creator,omitempty"`
+	Tenant          string           `json:"tenant,omitempty"`
+	Created         time.Time        `json:"created"`
+	Expires         time.Time        `json:"expires"`
+}
+
+// GetMetadata returns the non-secret information of a secret without using a view.
+// The creator, tenant and classifications are only returned to the creator. Looking up
+// a canary sends a canary event.
+func GetMetadata(ctx context.Context, id string, r Reader) (Metadata, error) {
+	secret, err := readSecret(ctx, r, NormalizeID(id))
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	if secret.Expired(time.Now()) {
+		return Metadata{}, NewSecretError(http.StatusNotFound, errSecretNotFound.Error())
+	}
+
+	if secret.Canary {
+		notify(ctx, r, secret, EventCanary)
+	}
+
+	metadata := Metadata{
+		ID:          secret.ID,
+		Description: secret.Description,
+		Passphrase:  secret.HasPassphrase,
+		Views:       secret.Views,
+		Created:     secret.Created,
+		Expires:     secret.Expires,
+	}
+
+	// what kind of credential a secret holds tells an attacker which links are worth
+	// guessing passwords for
+	if createdBy(ctx, secret) {
+		metadata.Creator = secret.Creator
+		metadata.Tenant = secret.Tenant
+		metadata.Classifications = secret.Classifications
+	}
+
+	return metadata, nil
+}
+
+// createdBy reports whether the request was authenticated as the creator of the secret.
+func createdBy(ctx context.Context, s Secret) bool {
+	if _, ok := IdentityFromContext(ctx); !ok || s.Creator == "" {
+		return false
+	}
+
+	client := ClientFromContext(ctx)
+	return client.Creator == s.Creator && client.Tenant == s.Tenant
+}
+
+// validateDescription checks the description is valid UTF-8 without control characters
+// other than newlines and no longer than MaxDescriptionLength.
+func validateDescription(d string) error {
+	if !utf8.ValidString(d) {
+		return NewSecretError(http.StatusBadRequest, "description must be valid UTF-8")
+	}
+
+	if utf8.RuneCountInString(d) > MaxDescriptionLength {
+		return NewSecretError(http.StatusBadRequest, fmt.Sprintf("description cannot be longer than %d characters", MaxDescriptionLength))
+	}
+
+	for _, v := range d {
+		if (v < 0x20 && v != '\n') || v == 0x7f {
+			return NewSecretError(http.StatusBadRequest, "description cannot contain control characters")
+		}
+	}
+
+	return nil
+}
+
+// encryptionKey combines the generated password with the creator's passphrase
+func encryptionKey(password, passphrase string) string {
+	if passphrase == "" {
+		return password
+	}
+
+	return password + "." + passphrase
+}
+
+// setDecoy sets up a canary secret. The decoy is the text that was sent or a generated
+// value if there is none. Canaries always have at least one view.
+func setDecoy(s *Secret) error {
+	if s.Decoy == "" {
+		s.Decoy = s.Text
+	}
+
+	if s.Decoy == "" {
+		decoy, err := Generate(GeneratePolicy{})
+		if err != nil {
+			return err
+		}
+		s.Decoy = decoy
+	}
+
+	s.Text = s.Decoy
+	s.Fields = nil
+
+	if s.Views < 1 {
+		s.Views = 1
+	}
+
+	return nil
+}
+
+// failedAttempt records a wrong password for the secret and locks it for
+// LockoutDuration once the attempts reach MaxFailedAttempts. Attempts are counted with a
+// revision checked update so concurrent guesses are all counted.
+func failedAttempt(ctx context.Context, secret Secret, b Backend) error {
+	locked := false
+	secret, err := modifySecret(ctx, b, secret.ID, func(v *Secret) (bool, error) {
+		v.Attempts++
+		locked = v.Attempts >= MaxFailedAttempts
+		if locked {
+			v.Attempts = 0
+			v.LockedUntil = time.Now().UTC().Add(LockoutDuration)
+		}
+		return false, nil
+	})
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+
+	notify(ctx, b, secret, EventFailed)
+	if locked {
+		notify(ctx, b, secret, EventLocked)
+	}
+
+	return NewError(CodeBadPassword, http.StatusUnauthorized, errBadAuth.Error())
+}
+
+// secretModifier is a backend that changes secrets with revision checked updates.
+type secretModifier interface {
+	// Modify applies fn to the stored secret and writes the result, or deletes the
+	// secret if fn returns true. If the secret changed in the meantime fn is applied to
+	// the new value. It returns the secret fn was applied to.
+	Modify(id string, fn func(*Secret) (bool, error)) (Secret, error)
+}
+
+// modifySecret applies fn to the stored secret. Backends without revision checked
+// updates read and write the secret without a check.
+func modifySecret(ctx context.Context, b Backend, id string, fn func(*Secret) (bool, error)) (Secret, error) {
+	if m, ok := b.(secretModifier); ok {
+		_, span := tracing.Start(ctx, "backend.modify", tracing.KindClient)
+		defer span.End()
+
+		s, err := m.Modify(id, fn)
+		if err != nil && !isNotFound(err) {
+			span.SetError(err)
+		}
+		return s, err
+	}
+
+	s, err := readSecret(ctx, b, id)
+	if err != nil {
+		return s, err
+	}
+
+	remove, err := fn(&s)
+	if err != nil {
+		return s, err
+	}
+
+	if remove {
+		return s, deleteSecret(ctx, b, id)
+	}
+
+	return s, writeSecret(ctx, b, s)
+}
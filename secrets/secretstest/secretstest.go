@@ -0,0 +1,180 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package secretstest provides in-memory stores for testing packages that use secrets.
+package secretstest
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/hooksie1/gophemeral/secrets"
+)
+
+// Backend is a secrets.Backend held in memory. It records the events it's notified of.
+type Backend struct {
+	// Validator checks secrets before they are written, every secret is accepted if nil.
+	Validator secrets.Validator
+
+	mu      sync.Mutex
+	secrets map[string]secrets.Secret
+	events  []secrets.Event
+}
+
+// NewBackend returns an empty backend limiting secrets to 200 characters like the
+// service's default policy.
+func NewBackend() *Backend {
+	return &Backend{
+		Validator: secrets.DefaultValidator(200),
+		secrets:   map[string]secrets.Secret{},
+	}
+}
+
+func (b *Backend) Validate(ctx context.Context, s *secrets.Secret) error {
+	if b.Validator == nil {
+		return nil
+	}
+
+	return b.Validator.Validate(ctx, s)
+}
+
+func (b *Backend) Write(s secrets.Secret) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.secrets[s.ID] = s
+	return nil
+}
+
+func (b *Backend) Create(s secrets.Secret) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.secrets[s.ID]; ok {
+		return secrets.ErrIDExists
+	}
+	b.secrets[s.ID] = s
+	return nil
+}
+
+func (b *Backend) Read(id string) (secrets.Secret, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.secrets[id]
+	if !ok {
+		return s, secrets.NewSecretError(http.StatusNotFound, "secret not found")
+	}
+
+	return s, nil
+}
+
+func (b *Backend) Delete(id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.secrets, id)
+	return nil
+}
+
+func (b *Backend) Keys() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var keys []string
+	for k := range b.secrets {
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+func (b *Backend) Notify(ctx context.Context, e secrets.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, e)
+}
+
+// Events returns the events the backend was notified of in order.
+func (b *Backend) Events() []secrets.Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]secrets.Event(nil), b.events...)
+}
+
+// APIKeyStore is a secrets.APIKeyStore held in memory.
+type APIKeyStore struct {
+	mu    sync.Mutex
+	keys  map[string]secrets.APIKey
+	usage map[string]int
+}
+
+// NewAPIKeyStore returns an empty key store.
+func NewAPIKeyStore() *APIKeyStore {
+	return &APIKeyStore{keys: map[string]secrets.APIKey{}, usage: map[string]int{}}
+}
+
+func (m *APIKeyStore) Create(k secrets.APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.keys[k.ID]; ok {
+		return secrets.ErrAPIKeyExists
+	}
+	m.keys[k.ID] = k
+	return nil
+}
+
+func (m *APIKeyStore) Get(id string) (secrets.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.keys[id]
+	if !ok {
+		return k, secrets.ErrAPIKeyNotFound
+	}
+
+	return k, nil
+}
+
+func (m *APIKeyStore) List() ([]secrets.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []secrets.APIKey
+	for _, v := range m.keys {
+		keys = append(keys, v)
+	}
+
+	return keys, nil
+}
+
+func (m *APIKeyStore) Put(k secrets.APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[k.ID] = k
+	return nil
+}
+
+func (m *APIKeyStore) Use(id, day string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usage[id+"."+day]++
+	return m.usage[id+"."+day], nil
+}
+
+// Issue creates a key in the store and returns its token.
+func (m *APIKeyStore) Issue(k secrets.APIKey) (string, error) {
+	k, token, err := secrets.NewAPIKey(k)
+	if err != nil {
+		return "", err
+	}
+
+	return token, m.Create(k)
+}
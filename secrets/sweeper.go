@@ -0,0 +1,84 @@
+/*
+Copyright © 2024 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/CoverWhale/logr"
+)
+
+// Sweep deletes every expired secret in the backend and sends an expired event for each.
+func Sweep(ctx context.Context, b ListReaderDeleter) error {
+	keys, err := b.Keys()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, id := range keys {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		secret, err := readSecret(ctx, b, id)
+		var re RecordError
+		if errors.As(err, &re) && re.Code() == 404 {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if !secret.Expired(now) {
+			continue
+		}
+
+		if err := deleteSecret(ctx, b, id); err != nil {
+			return err
+		}
+
+		notify(ctx, b, secret, EventExpired)
+	}
+
+	return nil
+}
+
+// RunSweeper sweeps the backend on every interval until the context is done.
+// An interval of zero or less disables the sweeper.
+func RunSweeper(ctx context.Context, b ListReaderDeleter, interval time.Duration, logger *logr.Logger) {
+	if interval <= 0 {
+		logger.Infof("sweeper disabled by interval %s", interval)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := Sweep(ctx, b); err != nil && !errors.Is(err, context.Canceled) {
+				logger.Errorf("error sweeping expired secrets: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,356 @@
+/*
+Copyright © 2024 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CoverWhale/logr"
+	"github.com/hooksie1/gophemeral/metrics"
+)
+
+const (
+	SyslogFormatRFC5424 = "rfc5424"
+	SyslogFormatCEF     = "cef"
+
+	// DefaultSyslogQueueSize is how many messages wait to be sent before new ones are dropped.
+	DefaultSyslogQueueSize = 1024
+
+	// syslogFacility is the security/authorization facility
+	syslogFacility = 10
+
+	severityAlert   = 1
+	severityWarning = 4
+	severityNotice  = 5
+	severityInfo    = 6
+)
+
+var syslogDropped = metrics.NewCounter("gophemeral_syslog_dropped_total", "Syslog messages dropped because the queue was full")
+
+// DefaultSyslogEvents are the security relevant events exported when none are configured.
+var DefaultSyslogEvents = []EventType{EventCreated, EventViewed, EventBurned, EventFailed, EventLocked, EventRateLimited, EventCanary}
+
+var (
+	sdEscaper         = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+	cefHeaderEscaper  = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	cefExtEscaper     = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+	syslogNameEscaper = strings.NewReplacer(" ", "_")
+)
+
+// SyslogConfig configures the syslog exporter. Network is udp, tcp or tls.
+type SyslogConfig struct {
+	Network   string
+	Address   string
+	Format    string
+	AppName   string
+	Hostname  string
+	Version   string
+	Events    []EventType
+	TLSConfig *tls.Config
+	QueueSize int
+}
+
+// SyslogExporter sends lifecycle events to a syslog server as RFC 5424 structured data
+// or as ArcSight CEF. Only event metadata is sent. Messages are queued and sent in the
+// background, when the queue is full they are dropped.
+type SyslogExporter struct {
+	config  SyslogConfig
+	events  map[EventType]bool
+	logger  *logr.Logger
+	queue   chan string
+	pending sync.WaitGroup
+	quit    chan struct{}
+	stop    sync.Once
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func NewSyslogExporter(c SyslogConfig, logger *logr.Logger) (*SyslogExporter, error) {
+	switch c.Network {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("unsupported syslog network %s", c.Network)
+	}
+
+	if c.Format == "" {
+		c.Format = SyslogFormatRFC5424
+	}
+
+	if c.Format != SyslogFormatRFC5424 && c.Format != SyslogFormatCEF {
+		return nil, fmt.Errorf("unsupported syslog format %s", c.Format)
+	}
+
+	if c.AppName == "" {
+		c.AppName = "gophemeral"
+	}
+
+	if c.Hostname == "" {
+		c.Hostname, _ = os.Hostname()
+	}
+
+	if len(c.Events) == 0 {
+		c.Events = DefaultSyslogEvents
+	}
+
+	if c.QueueSize <= 0 {
+		c.QueueSize = DefaultSyslogQueueSize
+	}
+
+	events := make(map[EventType]bool, len(c.Events))
+	for _, v := range c.Events {
+		events[v] = true
+	}
+
+	s := &SyslogExporter{
+		config: c,
+		events: events,
+		logger: logger,
+		queue:  make(chan string, c.QueueSize),
+		quit:   make(chan struct{}),
+	}
+	go s.run()
+
+	return s, nil
+}
+
+// Notify queues the event. It never blocks, if the queue is full the event is dropped.
+func (s *SyslogExporter) Notify(ctx context.Context, e Event) {
+	if !s.events[e.Type] {
+		return
+	}
+
+	s.pending.Add(1)
+	select {
+	case s.queue <- s.Format(e):
+	default:
+		s.pending.Done()
+		syslogDropped.Inc()
+		s.logger.Errorf("syslog queue is full, dropped %s event for %s", e.Type, e.ID)
+	}
+}
+
+// run sends the queued messages until the exporter is closed
+func (s *SyslogExporter) run() {
+	for {
+		select {
+		case <-s.quit:
+			return
+		case msg := <-s.queue:
+			if err := s.send(msg); err != nil {
+				s.logger.Errorf("error sending event to syslog: %v", err)
+			}
+			s.pending.Done()
+		}
+	}
+}
+
+// Flush waits for the queued messages to be sent or for the context to be done.
+func (s *SyslogExporter) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Format returns the full syslog message for the event.
+func (s *SyslogExporter) Format(e Event) string {
+	sd := "-"
+	msg := fmt.Sprintf("secret %s %s", e.ID, e.Type)
+
+	if s.config.Format == SyslogFormatCEF {
+		msg = s.formatCEF(e)
+	} else {
+		sd = formatStructuredData(e)
+	}
+
+	pri := syslogFacility*8 + severity(e.Type)
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		pri,
+		e.Time.UTC().Format(time.RFC3339Nano),
+		nilValue(syslogNameEscaper.Replace(s.config.Hostname)),
+		syslogNameEscaper.Replace(s.config.AppName),
+		os.Getpid(),
+		e.Type,
+		sd,
+		msg,
+	)
+}
+
+func formatStructuredData(e Event) string {
+	params := []string{
+		fmt.Sprintf(`id="%s"`, sdEscaper.Replace(e.ID)),
+		fmt.Sprintf(`event="%s"`, sdEscaper.Replace(string(e.Type))),
+		fmt.Sprintf(`views="%d"`, e.Views),
+	}
+
+	if e.Client.IP != "" {
+		params = append(params, fmt.Sprintf(`ip="%s"`, sdEscaper.Replace(e.Client.IP)))
+	}
+
+	if e.Client.UserAgent != "" {
+		params = append(params, fmt.Sprintf(`user_agent="%s"`, sdEscaper.Replace(e.Client.UserAgent)))
+	}
+
+	if e.Client.Subject != "" {
+		params = append(params, fmt.Sprintf(`subject="%s"`, sdEscaper.Replace(e.Client.Subject)))
+	}
+
+	return fmt.Sprintf("[gophemeral@32473 %s]", strings.Join(params, " "))
+}
+
+func (s *SyslogExporter) formatCEF(e Event) string {
+	ext := []string{
+		fmt.Sprintf("rt=%d", e.Time.UnixMilli()),
+		"cs1Label=secretId",
+		fmt.Sprintf("cs1=%s", cefExtEscaper.Replace(e.ID)),
+		"cn1Label=views",
+		fmt.Sprintf("cn1=%d", e.Views),
+	}
+
+	if e.Client.IP != "" {
+		ext = append(ext, fmt.Sprintf("src=%s", cefExtEscaper.Replace(e.Client.IP)))
+	}
+
+	if e.Client.UserAgent != "" {
+		ext = append(ext, fmt.Sprintf("requestClientApplication=%s", cefExtEscaper.Replace(e.Client.UserAgent)))
+	}
+
+	if e.Client.Subject != "" {
+		ext = append(ext, "cs2Label=natsSubject", fmt.Sprintf("cs2=%s", cefExtEscaper.Replace(e.Client.Subject)))
+	}
+
+	return fmt.Sprintf("CEF:0|Gophemeral|gophemeral|%s|%s|secret %s|%d|%s",
+		cefHeaderEscaper.Replace(s.config.Version),
+		cefHeaderEscaper.Replace(string(e.Type)),
+		cefHeaderEscaper.Replace(string(e.Type)),
+		cefSeverity(e.Type),
+		strings.Join(ext, " "),
+	)
+}
+
+// send writes the message to the syslog server, reconnecting once if the write fails
+func (s *SyslogExporter) send(msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			s.conn, err = s.dial()
+			if err != nil {
+				return err
+			}
+		}
+
+		s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		_, err = s.conn.Write(s.frame(msg))
+		if err == nil {
+			return nil
+		}
+
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	return err
+}
+
+// frame uses octet counting for stream transports as described in RFC 6587
+func (s *SyslogExporter) frame(msg string) []byte {
+	if s.config.Network == "udp" {
+		return []byte(msg)
+	}
+
+	return []byte(fmt.Sprintf("%d %s", len(msg), msg))
+}
+
+func (s *SyslogExporter) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if s.config.Network == "tls" {
+		return tls.DialWithDialer(dialer, "tcp", s.config.Address, s.config.TLSConfig)
+	}
+
+	return dialer.Dial(s.config.Network, s.config.Address)
+}
+
+// Close stops sending queued messages and closes the connection to the syslog server.
+// Call Flush first to send the messages that are still queued.
+func (s *SyslogExporter) Close() error {
+	s.stop.Do(func() { close(s.quit) })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func severity(t EventType) int {
+	switch t {
+	case EventCanary:
+		return severityAlert
+	case EventFailed, EventLocked, EventRateLimited:
+		return severityWarning
+	case EventCreated, EventExpired:
+		return severityInfo
+	default:
+		return severityNotice
+	}
+}
+
+func cefSeverity(t EventType) int {
+	switch t {
+	case EventLocked, EventCanary:
+		return 8
+	case EventFailed, EventRateLimited:
+		return 6
+	case EventViewed, EventBurned:
+		return 3
+	default:
+		return 1
+	}
+}
+
+func nilValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
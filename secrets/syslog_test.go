@@ -0,0 +1,174 @@
+/*
+Copyright © 2024 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CoverWhale/logr"
+)
+
+var testEvent = Event{
+	ID:     "2aXsQBv3lT1dYlbiQZ2FDCUQMBU",
+	Type:   EventFailed,
+	Views:  1,
+	Time:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	Client: Client{IP: "192.0.2.1", UserAgent: `curl "8" ]`},
+}
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	exporter, err := NewSyslogExporter(SyslogConfig{Network: "udp", Address: conn.LocalAddr().String(), Hostname: "test"}, logr.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+
+	exporter.Notify(context.Background(), testEvent)
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("error reading syslog message: %v", err)
+	}
+
+	msg := string(buf[:n])
+	expected := `<84>1 2024-01-02T03:04:05Z test gophemeral `
+	if !strings.HasPrefix(msg, expected) {
+		t.Errorf("expected message to start with %s but got %s", expected, msg)
+	}
+
+	sd := `[gophemeral@32473 id="2aXsQBv3lT1dYlbiQZ2FDCUQMBU" event="failed" views="1" ip="192.0.2.1" user_agent="curl \"8\" \]"]`
+	if !strings.Contains(msg, sd) {
+		t.Errorf("expected structured data %s in %s", sd, msg)
+	}
+}
+
+func TestSyslogTCPCEF(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		length, _ := r.ReadString(' ')
+		n, _ := strconv.Atoi(strings.TrimSpace(length))
+		buf := make([]byte, n)
+		r.Read(buf)
+		received <- string(buf)
+	}()
+
+	exporter, err := NewSyslogExporter(SyslogConfig{Network: "tcp", Address: l.Addr().String(), Format: SyslogFormatCEF, Version: "1.0"}, logr.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+
+	exporter.Notify(context.Background(), testEvent)
+
+	select {
+	case msg := <-received:
+		expected := "CEF:0|Gophemeral|gophemeral|1.0|failed|secret failed|6|rt=1704164645000 cs1Label=secretId cs1=2aXsQBv3lT1dYlbiQZ2FDCUQMBU cn1Label=views cn1=1 src=192.0.2.1"
+		if !strings.Contains(msg, expected) {
+			t.Errorf("expected %s in %s", expected, msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for syslog message")
+	}
+}
+
+func TestSyslogEventFilter(t *testing.T) {
+	exporter, err := NewSyslogExporter(SyslogConfig{Network: "udp", Address: "127.0.0.1:1"}, logr.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exporter.events[EventExpired] {
+		t.Errorf("expired events should not be exported by default")
+	}
+}
+
+func TestSyslogQueueFull(t *testing.T) {
+	exporter := &SyslogExporter{
+		config: SyslogConfig{Network: "udp", Address: "127.0.0.1:1", Hostname: "test"},
+		events: map[EventType]bool{EventFailed: true},
+		logger: logr.NewLogger(),
+		queue:  make(chan string, 1),
+		quit:   make(chan struct{}),
+	}
+
+	dropped := syslogDropped.Value()
+	start := time.Now()
+	for range 3 {
+		exporter.Notify(context.Background(), testEvent)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Errorf("notify should not block on a full queue")
+	}
+
+	if syslogDropped.Value() != dropped+2 {
+		t.Errorf("expected 2 dropped messages but got %v", syslogDropped.Value()-dropped)
+	}
+}
+
+func TestSyslogFlush(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	exporter, err := NewSyslogExporter(SyslogConfig{Network: "udp", Address: conn.LocalAddr().String()}, logr.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+
+	exporter.Notify(context.Background(), testEvent)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := exporter.Flush(ctx); err != nil {
+		t.Fatalf("error flushing syslog queue: %v", err)
+	}
+
+	if len(exporter.queue) != 0 {
+		t.Errorf("expected queue to be empty after flushing")
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hooksie1/gophemeral/tracing"
+)
+
+// The backend interfaces don't take a context so backend calls and the encryption
+// steps are traced by these wrappers.
+
+func readSecret(ctx context.Context, r Reader, id string) (Secret, error) {
+	_, span := tracing.Start(ctx, "backend.read", tracing.KindClient)
+	defer span.End()
+
+	s, err := r.Read(id)
+	if err != nil && !isNotFound(err) {
+		span.SetError(err)
+	}
+
+	return s, err
+}
+
+func writeSecret(ctx context.Context, w Writer, s Secret) error {
+	_, span := tracing.Start(ctx, "backend.write", tracing.KindClient)
+	defer span.End()
+
+	err := w.Write(s)
+	span.SetError(err)
+
+	return err
+}
+
+func createSecret(ctx context.Context, c secretCreator, s Secret) error {
+	_, span := tracing.Start(ctx, "backend.create", tracing.KindClient)
+	defer span.End()
+
+	err := c.Create(s)
+	if !errors.Is(err, ErrIDExists) {
+		span.SetError(err)
+	}
+
+	return err
+}
+
+func deleteSecret(ctx context.Context, d Deleter, id string) error {
+	_, span := tracing.Start(ctx, "backend.delete", tracing.KindClient)
+	defer span.End()
+
+	err := d.Delete(id)
+	span.SetError(err)
+
+	return err
+}
+
+func tracedEncrypt(ctx context.Context, plaintext []byte, key string) ([]byte, error) {
+	_, span := tracing.Start(ctx, "secrets.encrypt", tracing.KindInternal)
+	defer span.End()
+
+	data, err := encrypt(plaintext, key)
+	span.SetError(err)
+
+	return data, err
+}
+
+func tracedDecrypt(ctx context.Context, ciphertext []byte, key string) ([]byte, error) {
+	_, span := tracing.Start(ctx, "secrets.decrypt", tracing.KindInternal)
+	defer span.End()
+
+	// a failed decrypt is a wrong password, not an error of the service
+	return decrypt(ciphertext, key)
+}
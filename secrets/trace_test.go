@@ -0,0 +1,74 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hooksie1/gophemeral/tracing"
+	"github.com/hooksie1/gophemeral/tracing/tracingtest"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := &tracingtest.Exporter{}
+	tracer := tracing.NewTracer(exporter, nil)
+	tracing.SetTracer(tracer)
+	defer tracing.SetTracer(nil)
+
+	parent, ok := tracing.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok {
+		t.Fatal("expected valid traceparent")
+	}
+	ctx := tracing.WithRemoteParent(context.Background(), parent)
+
+	b := newMemoryBackend()
+	resp, err := AddSecret(ctx, b, Secret{Text: "test", Views: 1})
+	if err != nil {
+		t.Fatalf("error adding secret: %v", err)
+	}
+
+	if _, err := GetSecret(ctx, Secret{ID: resp.ID, Password: resp.Password}, b); err != nil {
+		t.Fatalf("error getting secret: %v", err)
+	}
+
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[string]tracing.SpanData{}
+	for _, v := range exporter.Spans() {
+		if v.TraceID != parent.TraceID {
+			t.Errorf("expected span %s to be in trace %s but got %s", v.Name, parent.TraceID, v.TraceID)
+		}
+		names[v.Name] = v
+	}
+
+	for _, v := range []string{"secrets.AddSecret", "secrets.GetSecret", "secrets.encrypt", "secrets.decrypt", "backend.read", "backend.create", "backend.delete"} {
+		if _, ok := names[v]; !ok {
+			t.Errorf("expected a %s span", v)
+		}
+	}
+
+	if names["secrets.AddSecret"].ParentID != parent.SpanID {
+		t.Errorf("expected AddSecret to be a child of the remote parent")
+	}
+
+	if names["secrets.encrypt"].ParentID != names["secrets.AddSecret"].SpanID {
+		t.Errorf("expected encrypt to be a child of AddSecret")
+	}
+}
@@ -0,0 +1,473 @@
+/*
+Copyright © 2024 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/CoverWhale/logr"
+	"github.com/hooksie1/gophemeral/metrics"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	// SignatureHeader holds the hex encoded HMAC-SHA256 of "<timestamp>.<body>".
+	SignatureHeader = "X-Gophemeral-Signature"
+	// TimestampHeader holds the unix time the delivery was signed at.
+	TimestampHeader = "X-Gophemeral-Timestamp"
+	// EventHeader holds the event type of the delivery.
+	EventHeader = "X-Gophemeral-Event"
+	// DeliveryHeader holds a unique ID for the delivery. Retries keep the same ID.
+	DeliveryHeader = "X-Gophemeral-Delivery"
+)
+
+const (
+	// DefaultWebhookWorkers is how many deliveries are sent at the same time.
+	DefaultWebhookWorkers = 4
+	// DefaultWebhookQueueSize is how many deliveries wait to be sent before new ones are dropped.
+	DefaultWebhookQueueSize = 1024
+)
+
+var (
+	webhookDeliveries = metrics.NewCounter("gophemeral_webhook_deliveries_total", "Webhook delivery attempts by result", "result")
+	webhookDropped    = metrics.NewCounter("gophemeral_webhook_dropped_total", "Webhook deliveries dropped because the queue was full")
+)
+
+// DefaultWebhookEvents are the events sent to webhooks when none are configured.
+var DefaultWebhookEvents = []EventType{EventViewed, EventBurned, EventExpired, EventCanary}
+
+// maxWebhookRedirects is how many redirects a creator webhook may follow.
+const maxWebhookRedirects = 3
+
+// blockedWebhookPrefixes are the non global ranges that aren't covered by the netip helpers.
+var blockedWebhookPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+var errWebhookAddress = errors.New("webhook address is not public")
+
+type webhookTarget struct {
+	URL string
+	Key string
+
+	creator bool
+}
+
+// webhookDelivery is a queued event for one target
+type webhookDelivery struct {
+	target webhookTarget
+	event  Event
+	body   []byte
+}
+
+// WebhookConfig configures webhook deliveries. URL and Key are the server wide webhook,
+// Key is also used to sign creator webhooks that don't have their own secret and to
+// encrypt their secrets at rest. Creator webhooks are only delivered when AllowCreator is
+// set, and only to public addresses unless AllowPrivate is set.
+type WebhookConfig struct {
+	URL          string
+	Key          string
+	AllowCreator bool
+	AllowPrivate bool
+	Events       []EventType
+	MaxRetries   int
+	Backoff      time.Duration
+	Client       *http.Client
+	Workers      int
+	QueueSize    int
+}
+
+// WebhookNotifier sends signed JSON POSTs of lifecycle events. Deliveries are queued and
+// sent by a fixed number of workers, when the queue is full they are dropped.
+type WebhookNotifier struct {
+	config  WebhookConfig
+	creator *http.Client
+	events  map[EventType]bool
+	logger  *logr.Logger
+	queue   chan webhookDelivery
+	pending sync.WaitGroup
+
+	// ctx is cancelled when the notifier is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewWebhookNotifier(c WebhookConfig, logger *logr.Logger) *WebhookNotifier {
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	creator := c.Client
+	if !c.AllowPrivate {
+		creator = publicClient()
+	}
+
+	if c.Backoff == 0 {
+		c.Backoff = time.Second
+	}
+
+	if len(c.Events) == 0 {
+		c.Events = DefaultWebhookEvents
+	}
+
+	if c.Workers <= 0 {
+		c.Workers = DefaultWebhookWorkers
+	}
+
+	if c.QueueSize <= 0 {
+		c.QueueSize = DefaultWebhookQueueSize
+	}
+
+	events := make(map[EventType]bool, len(c.Events))
+	for _, v := range c.Events {
+		events[v] = true
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &WebhookNotifier{
+		config:  c,
+		creator: creator,
+		events:  events,
+		logger:  logger,
+		queue:   make(chan webhookDelivery, c.QueueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	for i := 0; i < c.Workers; i++ {
+		go w.run()
+	}
+
+	return w
+}
+
+// publicClient returns a client that only connects to public addresses. The address is
+// checked after it is resolved so redirects and DNS rebinding can't reach internal hosts.
+func publicClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: checkWebhookAddr,
+	}
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxWebhookRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxWebhookRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// checkWebhookAddr rejects connections to addresses that aren't publicly routable.
+func checkWebhookAddr(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+
+	if !publicAddr(addr) {
+		return fmt.Errorf("%w: %s", errWebhookAddress, addr)
+	}
+
+	return nil
+}
+
+// publicAddr reports whether the address is globally routable.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() || addr.IsMulticast() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() {
+		return false
+	}
+
+	for _, v := range blockedWebhookPrefixes {
+		if v.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// webhookSealer encrypts creator webhook secrets before they are stored.
+type webhookSealer interface {
+	SealWebhookSecret(string) (string, error)
+}
+
+// sealWebhookSecret encrypts the webhook secret if the backend can. Otherwise the secret
+// is dropped since there is no notifier that could use it.
+func sealWebhookSecret(w any, secret string) (string, error) {
+	if secret == "" {
+		return "", nil
+	}
+
+	s, ok := w.(webhookSealer)
+	if !ok {
+		return "", nil
+	}
+
+	return s.SealWebhookSecret(secret)
+}
+
+// sealingKey derives the key for creator webhook secrets from the server signing key.
+func (w *WebhookNotifier) sealingKey() string {
+	return "webhook-secret." + w.config.Key
+}
+
+// SealWebhookSecret encrypts a creator webhook secret so it isn't stored in plaintext.
+func (w *WebhookNotifier) SealWebhookSecret(secret string) (string, error) {
+	if w.config.Key == "" {
+		return "", NewSecretError(http.StatusBadRequest, "webhook secrets need a server webhook signing key")
+	}
+
+	sealed, err := encrypt([]byte(secret), w.sealingKey())
+	if err != nil {
+		return "", err
+	}
+
+	return toBase64(sealed), nil
+}
+
+// openWebhookSecret decrypts a creator webhook secret sealed by SealWebhookSecret.
+func (w *WebhookNotifier) openWebhookSecret(sealed string) (string, error) {
+	data, err := fromBase64(sealed)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := decrypt(data, w.sealingKey())
+	if err != nil {
+		return "", err
+	}
+
+	return string(secret), nil
+}
+
+// Sign returns the signature for a webhook body sent at the timestamp.
+func Sign(key string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks the signature of a webhook body in constant time.
+func VerifySignature(key string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(key, timestamp, body)), []byte(signature))
+}
+
+// ValidateWebhook checks that a webhook is an absolute http or https URL.
+func ValidateWebhook(webhook string) error {
+	u, err := url.Parse(webhook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return NewSecretError(http.StatusBadRequest, "webhook must be an http or https URL")
+	}
+
+	return nil
+}
+
+// Notify queues the event for each webhook. It never blocks, if the queue is full the
+// delivery is dropped. Creator webhooks don't receive the viewer's client information.
+func (w *WebhookNotifier) Notify(ctx context.Context, e Event) {
+	if !w.events[e.Type] {
+		return
+	}
+
+	var targets []webhookTarget
+	var err error
+	if w.config.URL != "" {
+		targets = append(targets, webhookTarget{URL: w.config.URL, Key: w.config.Key})
+	}
+
+	if e.webhook.URL != "" && w.config.AllowCreator {
+		key := w.config.Key
+		if e.webhook.Key != "" {
+			key, err = w.openWebhookSecret(e.webhook.Key)
+			if err != nil {
+				w.logger.Errorf("not delivering %s event for %s, error opening webhook secret: %v", e.Type, e.ID, err)
+				key = ""
+			}
+		}
+		targets = append(targets, webhookTarget{URL: e.webhook.URL, Key: key, creator: true})
+	}
+
+	for _, v := range targets {
+		if v.Key == "" {
+			w.logger.Errorf("not delivering %s event for %s, webhook has no signing key", e.Type, e.ID)
+			continue
+		}
+
+		event := e
+		if v.creator {
+			event.Client = Client{RequestID: e.Client.RequestID}
+		}
+
+		body, err := json.Marshal(event)
+		if err != nil {
+			w.logger.Errorf("error encoding webhook event: %v", err)
+			continue
+		}
+
+		w.pending.Add(1)
+		select {
+		case w.queue <- webhookDelivery{target: v, event: event, body: body}:
+		default:
+			w.pending.Done()
+			webhookDropped.Inc()
+			w.logger.Errorf("webhook queue is full, dropped %s event for %s", e.Type, e.ID)
+		}
+	}
+}
+
+// run delivers the queued events until the notifier is closed
+func (w *WebhookNotifier) run() {
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case d := <-w.queue:
+			w.deliver(d.target, d.event, d.body)
+			w.pending.Done()
+		}
+	}
+}
+
+// deliver posts the event to the target retrying with exponential backoff until the
+// notifier is closed
+func (w *WebhookNotifier) deliver(t webhookTarget, e Event, body []byte) {
+	id := ksuid.New().String()
+	backoff := w.config.Backoff
+
+	for attempt := 0; ; attempt++ {
+		err := w.post(t, e, id, body)
+		if err == nil {
+			webhookDeliveries.Inc("success")
+			return
+		}
+
+		if attempt >= w.config.MaxRetries {
+			webhookDeliveries.Inc("failure")
+			w.logger.Errorf("webhook delivery %s of %s event for %s failed after %d attempts: %v", id, e.Type, e.ID, attempt+1, err)
+			return
+		}
+
+		webhookDeliveries.Inc("retry")
+		w.logger.Debugf("webhook delivery %s failed, retrying in %s: %v", id, backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-w.ctx.Done():
+			timer.Stop()
+			webhookDeliveries.Inc("failure")
+			w.logger.Errorf("webhook delivery %s of %s event for %s stopped after %d attempts: %v", id, e.Type, e.ID, attempt+1, err)
+			return
+		}
+		backoff *= 2
+	}
+}
+
+func (w *WebhookNotifier) post(t webhookTarget, e Event, id string, body []byte) error {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(e.Type))
+	req.Header.Set(DeliveryHeader, id)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, "sha256="+Sign(t.Key, timestamp, body))
+	if e.Client.RequestID != "" {
+		req.Header.Set(RequestIDHeader, e.Client.RequestID)
+	}
+
+	client := w.config.Client
+	if t.creator {
+		client = w.creator
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Flush waits for the queued deliveries to finish or for the context to be done.
+func (w *WebhookNotifier) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		w.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops the workers, cancelling deliveries that are being sent or waiting to be
+// retried. Call Flush first to send the deliveries that are still queued.
+func (w *WebhookNotifier) Close() error {
+	w.cancel()
+	return nil
+}
@@ -0,0 +1,301 @@
+/*
+Copyright © 2024 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CoverWhale/logr"
+)
+
+type webhookReceiver struct {
+	mu       sync.Mutex
+	key      string
+	fail     int
+	requests int
+	events   []Event
+	bodies   []string
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.requests++
+
+	if wr.requests <= wr.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	timestamp, _ := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	signature := strings.TrimPrefix(r.Header.Get(SignatureHeader), "sha256=")
+	if !VerifySignature(wr.key, timestamp, body, signature) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var e Event
+	json.Unmarshal(body, &e)
+	wr.events = append(wr.events, e)
+	wr.bodies = append(wr.bodies, string(body))
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	receiver := &webhookReceiver{key: "creator-key", fail: 1}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	notifier := NewWebhookNotifier(WebhookConfig{
+		Key:          "server-key",
+		AllowCreator: true,
+		AllowPrivate: true,
+		MaxRetries:   3,
+		Backoff:      time.Millisecond,
+	}, logr.NewLogger())
+
+	b := newMemoryBackend()
+	b.sealer = notifier
+	ctx := context.Background()
+
+	resp, err := AddSecret(ctx, b, Secret{Text: "super secret", Views: 1, Webhook: server.URL, WebhookSecret: "creator-key"})
+	if err != nil {
+		t.Fatalf("error adding secret: %v", err)
+	}
+
+	if stored := b.secrets[resp.ID].WebhookSecret; stored == "" || strings.Contains(stored, "creator-key") {
+		t.Errorf("expected webhook secret to be encrypted at rest but got %q", stored)
+	}
+
+	if _, err := GetSecret(ctx, Secret{ID: resp.ID, Password: resp.Password}, b); err != nil {
+		t.Fatalf("error getting secret: %v", err)
+	}
+
+	for _, v := range b.events {
+		notifier.Notify(ctx, v)
+	}
+
+	if err := notifier.Flush(ctx); err != nil {
+		t.Fatalf("error flushing webhooks: %v", err)
+	}
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+
+	if len(receiver.events) != 2 {
+		t.Fatalf("expected viewed and burned events but got %+v", receiver.events)
+	}
+
+	for _, v := range receiver.bodies {
+		if strings.Contains(v, "super secret") || strings.Contains(v, resp.Password) || strings.Contains(v, "creator-key") {
+			t.Errorf("webhook body leaked secret data: %s", v)
+		}
+	}
+
+	if webhookDeliveries.Value("retry") < 1 {
+		t.Errorf("expected failed delivery to be retried")
+	}
+}
+
+func TestWebhookCreatorPayload(t *testing.T) {
+	server := &webhookReceiver{key: "server-key"}
+	serverHook := httptest.NewServer(server)
+	defer serverHook.Close()
+
+	creator := &webhookReceiver{key: "server-key"}
+	creatorHook := httptest.NewServer(creator)
+	defer creatorHook.Close()
+
+	notifier := NewWebhookNotifier(WebhookConfig{URL: serverHook.URL, Key: "server-key", AllowCreator: true, AllowPrivate: true}, logr.NewLogger())
+	defer notifier.Close()
+
+	client := Client{IP: "203.0.113.9", UserAgent: "curl/8.0", APIKey: "ci", RequestID: "req-1", Headers: map[string]string{"Referer": "https://wiki.example.com"}}
+	notifier.Notify(context.Background(), Event{ID: "test", Type: EventViewed, Client: client, webhook: webhookTarget{URL: creatorHook.URL}})
+	if err := notifier.Flush(context.Background()); err != nil {
+		t.Fatalf("error flushing webhooks: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	creator.mu.Lock()
+	defer creator.mu.Unlock()
+
+	if len(server.events) != 1 || len(creator.events) != 1 {
+		t.Fatalf("expected one delivery to each webhook but got %d and %d", len(server.events), len(creator.events))
+	}
+
+	if got := server.events[0].Client; got.IP != client.IP || got.APIKey != client.APIKey {
+		t.Errorf("expected the server webhook to get the client but got %+v", got)
+	}
+
+	if got := creator.events[0].Client; got.IP != "" || got.UserAgent != "" || got.APIKey != "" || got.Headers != nil || got.RequestID != "req-1" {
+		t.Errorf("expected the creator webhook to only get the request ID but got %+v", got)
+	}
+}
+
+func TestWebhookQueueFull(t *testing.T) {
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+	}))
+	defer server.Close()
+
+	notifier := NewWebhookNotifier(WebhookConfig{URL: server.URL, Key: "server-key", Workers: 1, QueueSize: 1}, logr.NewLogger())
+	defer notifier.Close()
+	dropped := webhookDropped.Value()
+
+	notifier.Notify(context.Background(), Event{ID: "first", Type: EventViewed})
+	<-received
+
+	notifier.Notify(context.Background(), Event{ID: "second", Type: EventViewed})
+	notifier.Notify(context.Background(), Event{ID: "third", Type: EventViewed})
+
+	if webhookDropped.Value() != dropped+1 {
+		t.Errorf("expected the delivery that didn't fit in the queue to be dropped")
+	}
+
+	close(release)
+	if err := notifier.Flush(context.Background()); err != nil {
+		t.Errorf("error flushing webhooks: %v", err)
+	}
+}
+
+func TestWebhookCloseStopsRetries(t *testing.T) {
+	receiver := &webhookReceiver{key: "server-key", fail: 100}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	notifier := NewWebhookNotifier(WebhookConfig{URL: server.URL, Key: "server-key", MaxRetries: 5, Backoff: time.Hour}, logr.NewLogger())
+	failures := webhookDeliveries.Value("failure")
+	retries := webhookDeliveries.Value("retry")
+
+	notifier.Notify(context.Background(), Event{ID: "test", Type: EventViewed})
+	for webhookDeliveries.Value("retry") == retries {
+		time.Sleep(time.Millisecond)
+	}
+
+	notifier.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := notifier.Flush(ctx); err != nil {
+		t.Fatalf("expected closing to stop the retry but got %v", err)
+	}
+
+	if webhookDeliveries.Value("failure") != failures+1 {
+		t.Errorf("expected the stopped delivery to be counted as a failure")
+	}
+}
+
+func TestWebhookDisabledForCreator(t *testing.T) {
+	receiver := &webhookReceiver{key: "server-key"}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	notifier := NewWebhookNotifier(WebhookConfig{Key: "server-key"}, logr.NewLogger())
+	notifier.Notify(context.Background(), Event{ID: "test", Type: EventViewed, webhook: webhookTarget{URL: server.URL}})
+	notifier.Flush(context.Background())
+
+	if receiver.requests != 0 {
+		t.Errorf("creator webhook should not be delivered when not allowed")
+	}
+}
+
+func TestWebhookBlocksPrivateAddresses(t *testing.T) {
+	receiver := &webhookReceiver{key: "server-key"}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	notifier := NewWebhookNotifier(WebhookConfig{Key: "server-key", AllowCreator: true}, logr.NewLogger())
+	failures := webhookDeliveries.Value("failure")
+
+	notifier.Notify(context.Background(), Event{ID: "test", Type: EventViewed, webhook: webhookTarget{URL: server.URL}})
+	notifier.Flush(context.Background())
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+
+	if receiver.requests != 0 {
+		t.Errorf("creator webhook to a loopback address should be blocked")
+	}
+
+	if webhookDeliveries.Value("failure") != failures+1 {
+		t.Errorf("expected blocked delivery to be counted as a failure")
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	tt := []struct {
+		addr   string
+		public bool
+	}{
+		{addr: "93.184.216.34", public: true},
+		{addr: "2606:4700::1111", public: true},
+		{addr: "127.0.0.1", public: false},
+		{addr: "10.1.2.3", public: false},
+		{addr: "172.16.0.1", public: false},
+		{addr: "192.168.1.1", public: false},
+		{addr: "169.254.169.254", public: false},
+		{addr: "100.64.0.1", public: false},
+		{addr: "0.0.0.0", public: false},
+		{addr: "::1", public: false},
+		{addr: "fd00::1", public: false},
+		{addr: "fe80::1", public: false},
+		{addr: "::ffff:127.0.0.1", public: false},
+	}
+
+	for _, v := range tt {
+		if got := publicAddr(netip.MustParseAddr(v.addr)); got != v.public {
+			t.Errorf("publicAddr(%s) = %t, expected %t", v.addr, got, v.public)
+		}
+	}
+}
+
+func TestSealWebhookSecret(t *testing.T) {
+	notifier := NewWebhookNotifier(WebhookConfig{Key: "server-key"}, logr.NewLogger())
+
+	sealed, err := notifier.SealWebhookSecret("creator-key")
+	if err != nil {
+		t.Fatalf("error sealing webhook secret: %v", err)
+	}
+
+	opened, err := notifier.openWebhookSecret(sealed)
+	if err != nil || opened != "creator-key" {
+		t.Errorf("expected creator-key but got %q: %v", opened, err)
+	}
+
+	other := NewWebhookNotifier(WebhookConfig{Key: "other-key"}, logr.NewLogger())
+	if _, err := other.openWebhookSecret(sealed); err == nil {
+		t.Errorf("expected opening with a different server key to fail")
+	}
+
+	if _, err := NewWebhookNotifier(WebhookConfig{}, logr.NewLogger()).SealWebhookSecret("creator-key"); err == nil {
+		t.Errorf("expected sealing without a server key to fail")
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright © 2024 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Inflight counts the micro requests being handled so shutdown can wait for them
+// to finish after the service stops accepting requests.
+type Inflight struct {
+	mu    sync.Mutex
+	count int
+	done  chan struct{}
+}
+
+// Track wraps the handler so its requests are counted.
+func (i *Inflight) Track(h micro.Handler) micro.HandlerFunc {
+	return func(r micro.Request) {
+		i.add(1)
+		defer i.add(-1)
+		h.Handle(r)
+	}
+}
+
+func (i *Inflight) add(n int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.count += n
+	if i.count == 0 && i.done != nil {
+		close(i.done)
+		i.done = nil
+	}
+}
+
+// Wait blocks until no requests are in flight or the context is done.
+func (i *Inflight) Wait(ctx context.Context) error {
+	i.mu.Lock()
+	if i.count == 0 {
+		i.mu.Unlock()
+		return nil
+	}
+
+	if i.done == nil {
+		i.done = make(chan struct{})
+	}
+	done := i.done
+	i.mu.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
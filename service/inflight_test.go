@@ -0,0 +1,95 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// blockingHandler returns a tracked handler that runs until release is closed
+func blockingHandler(i *Inflight, started chan<- struct{}, release <-chan struct{}) micro.HandlerFunc {
+	return i.Track(micro.HandlerFunc(func(micro.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+}
+
+func TestInflightWaitIdle(t *testing.T) {
+	var i Inflight
+	if err := i.Wait(context.Background()); err != nil {
+		t.Errorf("expected wait without requests to return: %v", err)
+	}
+}
+
+func TestInflightWaitDrains(t *testing.T) {
+	var i Inflight
+	started, release := make(chan struct{}), make(chan struct{})
+	h := blockingHandler(&i, started, release)
+
+	for n := 0; n < 2; n++ {
+		go h(nil)
+		<-started
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- i.Wait(context.Background())
+	}()
+
+	select {
+	case err := <-errs:
+		t.Fatalf("expected wait to block while requests are in flight but got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("expected wait to return once drained: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected wait to return once the requests finished")
+	}
+
+	// a drained counter can be waited on again
+	if err := i.Wait(context.Background()); err != nil {
+		t.Errorf("expected wait after draining to return: %v", err)
+	}
+}
+
+func TestInflightWaitTimeout(t *testing.T) {
+	var i Inflight
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+
+	go blockingHandler(&i, started, release)(nil)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := i.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected wait to time out but got %v", err)
+	}
+}
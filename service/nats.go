@@ -17,73 +17,197 @@ limitations under the License.
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
-	cwnats "github.com/CoverWhale/coverwhale-go/transports/nats"
 	"github.com/CoverWhale/logr"
 	"github.com/hooksie1/gophemeral/secrets"
+	"github.com/hooksie1/gophemeral/tracing"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/micro"
 )
 
-type Handler func(secrets.Backend, *logr.Logger, micro.Request) error
+type Handler func(context.Context, secrets.Backend, *logr.Logger, micro.Request) error
 
 type TextViews struct {
-	Text  string `json:"text"`
-	Views int    `json:"views"`
+	Text     string                  `json:"text"`
+	Views    int                     `json:"views"`
+	Fields   []secrets.Field         `json:"fields,omitempty"`
+	TTL      int                     `json:"ttl,omitempty"`
+	Generate *secrets.GeneratePolicy `json:"generate,omitempty"`
+
+	Description string   `json:"description,omitempty"`
+	Passphrase  string   `json:"passphrase,omitempty"`
+	Recipients  []string `json:"recipients,omitempty"`
+
+	Webhook       string `json:"webhook,omitempty"`
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+
+	Canary bool   `json:"canary,omitempty"`
+	Decoy  string `json:"decoy,omitempty"`
+}
+
+// Secret returns the secret to create from the request.
+func (t TextViews) Secret() secrets.Secret {
+	return secrets.Secret{
+		Text:   t.Text,
+		Views:  t.Views,
+		Fields: t.Fields,
+		TTL:    t.TTL,
+
+		Description: t.Description,
+		Passphrase:  t.Passphrase,
+		Recipients:  t.Recipients,
+
+		Webhook:       t.Webhook,
+		WebhookSecret: t.WebhookSecret,
+
+		Canary: t.Canary,
+		Decoy:  t.Decoy,
+	}
+}
+
+// Batch is a request to create many secrets.
+type Batch struct {
+	Secrets []TextViews `json:"secrets"`
+}
+
+// BatchResults holds the result of each secret in a batch in request order.
+type BatchResults struct {
+	Results []secrets.BatchResult `json:"results"`
 }
 
 type IDPassword struct {
-	ID       string `json:"id"`
-	Password string `json:"password"`
+	ID         string `json:"id"`
+	Password   string `json:"password"`
+	Passphrase string `json:"passphrase,omitempty"`
 }
 
-func StoreSecret(b secrets.Backend, logger *logr.Logger, r micro.Request) error {
+func StoreSecret(ctx context.Context, b secrets.Backend, logger *logr.Logger, r micro.Request) error {
 	var tv TextViews
 	if err := json.Unmarshal(r.Data(), &tv); err != nil {
-		return cwnats.NewClientError(err, 400)
+		return secrets.NewSecretError(http.StatusBadRequest, "invalid request body: "+err.Error())
 	}
 
-	s := secrets.Secret{
-		Text:  tv.Text,
-		Views: tv.Views,
+	s := tv.Secret()
+
+	key := r.Headers().Get(secrets.IdempotencyHeader)
+	secret, err := secrets.CreateOnce(ctx, b, key, r.Data(), func(ctx context.Context) (secrets.Secret, error) {
+		if tv.Generate != nil {
+			return secrets.GenerateSecret(ctx, b, s, *tv.Generate)
+		}
+		return secrets.AddSecret(ctx, b, s)
+	})
+	if errors.Is(err, secrets.ErrIdempotencyNotStored) {
+		logger.Errorf("created secret %s without saving its idempotency key: %v", secret.ID, err)
+		err = nil
+	}
+	if err != nil {
+		return err
 	}
 
-	secret, err := secrets.AddSecret(b, s)
+	r.RespondJSON(IDPassword{ID: secret.ID, Password: secret.Password}, requestIDHeader(ctx))
+
+	return nil
+}
+
+// StoreBatch creates each secret of the batch. Failed secrets are reported in their
+// result and don't fail the request. Batches with an idempotency key are only created once.
+func StoreBatch(ctx context.Context, b secrets.Backend, logger *logr.Logger, r micro.Request) error {
+	var batch Batch
+	if err := json.Unmarshal(r.Data(), &batch); err != nil {
+		return secrets.NewSecretError(http.StatusBadRequest, "invalid request body: "+err.Error())
+	}
+
+	items := make([]secrets.BatchItem, len(batch.Secrets))
+	for i, v := range batch.Secrets {
+		items[i] = secrets.BatchItem{Secret: v.Secret(), Generate: v.Generate}
+	}
+
+	key := r.Headers().Get(secrets.IdempotencyHeader)
+	results, err := secrets.AddSecretsOnce(ctx, b, key, r.Data(), items)
+	if errors.Is(err, secrets.ErrIdempotencyNotStored) {
+		logger.Errorf("created batch without saving its idempotency key: %v", err)
+		err = nil
+	}
 	if err != nil {
 		return err
 	}
 
-	r.RespondJSON(IDPassword{ID: secret.ID, Password: secret.Password})
+	for _, v := range results {
+		if v.Code == http.StatusInternalServerError {
+			logger.Errorf("error creating secret %d of batch: %v", v.Index, v.Err)
+		}
+	}
+
+	r.RespondJSON(BatchResults{Results: results}, requestIDHeader(ctx))
 
 	return nil
 }
 
-func GetSecret(b secrets.Backend, logger *logr.Logger, r micro.Request) error {
+func GetSecret(ctx context.Context, b secrets.Backend, logger *logr.Logger, r micro.Request) error {
 	var idp IDPassword
 	if err := json.Unmarshal(r.Data(), &idp); err != nil {
-		return cwnats.NewClientError(err, 400)
+		return secrets.NewSecretError(http.StatusBadRequest, "invalid request body: "+err.Error())
 	}
 
 	s := secrets.Secret{
-		ID:       idp.ID,
-		Password: idp.Password,
+		ID:         idp.ID,
+		Password:   idp.Password,
+		Passphrase: idp.Passphrase,
 	}
 
-	secret, err := secrets.GetSecret(s, b)
+	secret, err := secrets.GetSecret(ctx, s, b)
 	if err != nil {
 		return err
 	}
 
-	r.RespondJSON(TextViews{Text: secret.Text, Views: secret.Views})
+	r.RespondJSON(TextViews{Text: secret.Text, Views: secret.Views, Fields: secret.Fields}, requestIDHeader(ctx))
 
 	return nil
 }
 
+// InfoSecret returns the metadata of a secret without using a view.
+func InfoSecret(ctx context.Context, b secrets.Backend, logger *logr.Logger, r micro.Request) error {
+	var idp IDPassword
+	if err := json.Unmarshal(r.Data(), &idp); err != nil {
+		return secrets.NewSecretError(http.StatusBadRequest, "invalid request body: "+err.Error())
+	}
+
+	metadata, err := secrets.GetMetadata(ctx, idp.ID, b)
+	if err != nil {
+		return err
+	}
+
+	r.RespondJSON(metadata, requestIDHeader(ctx))
+
+	return nil
+}
+
+// requestContext returns a context with the request ID and the client information of
+// the micro request attached.
+func requestContext(r micro.Request, id string) context.Context {
+	ctx := secrets.WithRequestID(context.Background(), id)
+	user, account := secrets.NATSIdentity(r.Headers())
+	return secrets.WithClient(ctx, secrets.Client{
+		UserAgent:   r.Headers().Get("User-Agent"),
+		Subject:     r.Subject(),
+		NATSUser:    user,
+		NATSAccount: account,
+		RequestID:   id,
+		Headers:     secrets.ClientHeaders(r.Headers()),
+	})
+}
+
+// requestIDHeader returns the request ID of the context as a response header
+func requestIDHeader(ctx context.Context) micro.RespondOpt {
+	return micro.WithHeaders(micro.Headers{secrets.RequestIDHeader: []string{secrets.RequestIDFromContext(ctx)}})
+}
+
 func WatchForConfig(logger *logr.Logger, js nats.JetStreamContext) {
 	kv, err := js.KeyValue("configs")
 	if err != nil {
@@ -119,35 +243,59 @@ func WatchForConfig(logger *logr.Logger, js nats.JetStreamContext) {
 	time.Sleep(5 * time.Second)
 }
 
+// Authorize checks the API key in the Authorization header has the scope before calling
+// the handler. A nil authenticator allows every request.
+func Authorize(a *secrets.Authenticator, scope string, h Handler) Handler {
+	return func(ctx context.Context, b secrets.Backend, logger *logr.Logger, r micro.Request) error {
+		if a == nil {
+			return h(ctx, b, logger, r)
+		}
+
+		ctx, err := a.Authenticate(ctx, r.Headers().Get(secrets.AuthorizationHeader), scope)
+		if err != nil {
+			return err
+		}
+
+		return h(ctx, b, logger, r)
+	}
+}
+
 func SecretHandler(b secrets.Backend, logger *logr.Logger, h Handler) micro.HandlerFunc {
 	return func(r micro.Request) {
 		start := time.Now()
-		reqLogger := logger.WithContext(map[string]string{"subject": r.Subject()})
+		id := secrets.RequestID(r.Headers().Get(secrets.RequestIDHeader))
+		ctx := tracing.Extract(requestContext(r, id), r.Headers())
+		ctx, span := tracing.Start(ctx, r.Subject(), tracing.KindServer)
+		defer span.End()
+		span.SetAttribute("messaging.destination.name", r.Subject())
+		span.SetAttribute("request_id", id)
+
+		reqLogger := logger.WithContext(map[string]string{"subject": r.Subject(), "request_id": id})
 		defer func() {
 			reqLogger.Infof("duration %dms", time.Since(start).Milliseconds())
 		}()
 
-		err := h(b, reqLogger, r)
+		err := h(ctx, b, reqLogger, r)
 		if err == nil {
 			return
 		}
 
-		handleRequestError(reqLogger, err, r)
+		if re, ok := secrets.ErrorFrom(err); !ok || re.Code() >= http.StatusInternalServerError {
+			span.SetError(err)
+		}
+
+		handleRequestError(ctx, reqLogger, err, r)
 	}
 }
 
-func handleRequestError(logger *logr.Logger, err error, r micro.Request) {
-	var ce cwnats.ClientError
-	var re secrets.RecordError
-	if errors.As(err, &re) {
-		r.Error(strconv.Itoa(re.Code()), http.StatusText(re.Code()), []byte(re.Body()))
+func handleRequestError(ctx context.Context, logger *logr.Logger, err error, r micro.Request) {
+	re, ok := secrets.ErrorFrom(err)
+	if !ok {
+		logger.Error(err)
 	}
-	if errors.As(err, &ce) {
-		r.Error(ce.CodeString(), http.StatusText(ce.Code), ce.Body())
-		return
-	}
-
-	logger.Error(err)
 
-	r.Error("500", "internal server error", []byte(`{"error": "internal server error"}`))
+	headers := micro.WithHeaders(micro.Headers{secrets.ErrorCodeHeader: []string{string(re.ErrorCode())}})
+	if err := r.Error(strconv.Itoa(re.Code()), re.Body(), re.JSON(), headers, requestIDHeader(ctx)); err != nil {
+		logger.Error(err)
+	}
 }
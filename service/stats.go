@@ -0,0 +1,49 @@
+/*
+Copyright © 2024 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"github.com/hooksie1/gophemeral/secrets"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Stats are the business counters reported with the endpoint stats. The counters
+// are for the whole service so every endpoint reports the same values.
+type Stats struct {
+	Created        float64 `json:"secrets_created"`
+	Viewed         float64 `json:"secrets_viewed"`
+	Expired        float64 `json:"secrets_expired"`
+	Burned         float64 `json:"secrets_burned"`
+	Locked         float64 `json:"secrets_locked"`
+	FailedAttempts float64 `json:"failed_password_attempts"`
+	RateLimited    float64 `json:"rate_limited"`
+}
+
+// StatsHandler is the micro.StatsHandler of the service.
+func StatsHandler(*micro.Endpoint) any {
+	counts := secrets.EventCounts()
+
+	return Stats{
+		Created:        counts[secrets.EventCreated],
+		Viewed:         counts[secrets.EventViewed],
+		Expired:        counts[secrets.EventExpired],
+		Burned:         counts[secrets.EventBurned],
+		Locked:         counts[secrets.EventLocked],
+		FailedAttempts: counts[secrets.EventFailed],
+		RateLimited:    counts[secrets.EventRateLimited],
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hooksie1/gophemeral/secrets"
+	"github.com/hooksie1/gophemeral/secrets/secretstest"
+)
+
+func stats() Stats {
+	return StatsHandler(nil).(Stats)
+}
+
+func TestStatsHandler(t *testing.T) {
+	b := secretstest.NewBackend()
+	ctx := context.Background()
+	before := stats()
+
+	burned, err := secrets.AddSecret(ctx, b, secrets.Secret{Text: "test", Views: 1})
+	if err != nil {
+		t.Fatalf("error adding secret: %v", err)
+	}
+
+	locked, err := secrets.AddSecret(ctx, b, secrets.Secret{Text: "test", Views: 1})
+	if err != nil {
+		t.Fatalf("error adding secret: %v", err)
+	}
+
+	expired, err := secrets.AddSecret(ctx, b, secrets.Secret{Text: "test", Views: 1, TTL: 1})
+	if err != nil {
+		t.Fatalf("error adding secret: %v", err)
+	}
+
+	if _, err := secrets.GetSecret(ctx, secrets.Secret{ID: burned.ID, Password: burned.Password}, b); err != nil {
+		t.Fatalf("error getting secret: %v", err)
+	}
+
+	// another secret's password has the right format but is wrong for this one
+	for range secrets.MaxFailedAttempts {
+		secrets.GetSecret(ctx, secrets.Secret{ID: locked.ID, Password: expired.Password}, b)
+	}
+
+	s, err := b.Read(expired.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Expires = time.Now().Add(-time.Second)
+	b.Write(s)
+	if err := secrets.Sweep(ctx, b); err != nil {
+		t.Fatalf("error sweeping: %v", err)
+	}
+
+	store := secretstest.NewAPIKeyStore()
+	token, err := store.Issue(secrets.APIKey{Name: "ci", Scopes: []string{secrets.ScopeCreate}, RateLimit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := secrets.NewAuthenticator(store, false, b)
+	auth.Authenticate(ctx, "Bearer "+token, secrets.ScopeCreate)
+	auth.Authenticate(ctx, "Bearer "+token, secrets.ScopeCreate)
+
+	after := stats()
+	tt := []struct {
+		name     string
+		got      float64
+		expected float64
+	}{
+		{name: "created", got: after.Created - before.Created, expected: 3},
+		{name: "viewed", got: after.Viewed - before.Viewed, expected: 1},
+		{name: "burned", got: after.Burned - before.Burned, expected: 1},
+		{name: "failed", got: after.FailedAttempts - before.FailedAttempts, expected: float64(secrets.MaxFailedAttempts)},
+		{name: "locked", got: after.Locked - before.Locked, expected: 1},
+		{name: "expired", got: after.Expired - before.Expired, expected: 1},
+		{name: "rate limited", got: after.RateLimited - before.RateLimited, expected: 1},
+	}
+
+	for _, v := range tt {
+		if v.got != v.expected {
+			t.Errorf("expected %v %s secrets but got %v", v.expected, v.name, v.got)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Copyright © 2024 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spanJSON is how the writer exporter prints a span
+type spanJSON struct {
+	TraceID    string         `json:"trace_id"`
+	SpanID     string         `json:"span_id"`
+	ParentID   string         `json:"parent_span_id,omitempty"`
+	Name       string         `json:"name"`
+	Kind       Kind           `json:"kind"`
+	Start      time.Time      `json:"start"`
+	End        time.Time      `json:"end"`
+	Duration   float64        `json:"duration_ms"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// WriterExporter writes spans as JSON lines, for local debugging to stdout or a file.
+type WriterExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterExporter returns an exporter writing to w. If w is an io.Closer it is
+// closed on shutdown.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+func (e *WriterExporter) Export(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	enc := json.NewEncoder(e.w)
+	for _, v := range spans {
+		s := spanJSON{
+			TraceID:    v.TraceID.String(),
+			SpanID:     v.SpanID.String(),
+			Name:       v.Name,
+			Kind:       v.Kind,
+			Start:      v.Start.UTC(),
+			End:        v.End.UTC(),
+			Duration:   float64(v.End.Sub(v.Start).Microseconds()) / 1000,
+			Attributes: v.Attributes,
+			Error:      v.Err,
+		}
+		if v.ParentID != (SpanID{}) {
+			s.ParentID = v.ParentID.String()
+		}
+
+		if err := enc.Encode(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *WriterExporter) Shutdown(ctx context.Context) error {
+	if c, ok := e.w.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector with OTLP/HTTP and JSON encoding.
+type OTLPExporter struct {
+	url     string
+	service string
+	headers map[string]string
+	client  *http.Client
+}
+
+// NewOTLPExporter returns an exporter for the collector at endpoint, for example
+// http://localhost:4318. Spans are posted to /v1/traces unless the endpoint already
+// has that path.
+func NewOTLPExporter(endpoint, service string, headers map[string]string) *OTLPExporter {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+
+	return &OTLPExporter{
+		url:     url,
+		service: service,
+		headers: headers,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error exporting spans: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("error exporting spans: unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// request builds the OTLP ExportTraceServiceRequest in its JSON encoding
+func (e *OTLPExporter) request(spans []SpanData) map[string]any {
+	otlpSpans := make([]map[string]any, len(spans))
+	for i, v := range spans {
+		status := map[string]any{"code": 1}
+		if v.Err != "" {
+			status = map[string]any{"code": 2, "message": v.Err}
+		}
+
+		span := map[string]any{
+			"traceId":           v.TraceID.String(),
+			"spanId":            v.SpanID.String(),
+			"name":              v.Name,
+			"kind":              v.Kind,
+			"startTimeUnixNano": strconv.FormatInt(v.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(v.End.UnixNano(), 10),
+			"attributes":        otlpAttributes(v.Attributes),
+			"status":            status,
+		}
+		if v.ParentID != (SpanID{}) {
+			span["parentSpanId"] = v.ParentID.String()
+		}
+		otlpSpans[i] = span
+	}
+
+	return map[string]any{
+		"resourceSpans": []any{
+			map[string]any{
+				"resource": map[string]any{
+					"attributes": otlpAttributes(map[string]any{"service.name": e.service}),
+				},
+				"scopeSpans": []any{
+					map[string]any{
+						"scope": map[string]any{"name": e.service},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+func otlpAttributes(attrs map[string]any) []map[string]any {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	list := make([]map[string]any, len(keys))
+	for i, k := range keys {
+		var value map[string]any
+		switch v := attrs[k].(type) {
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		list[i] = map[string]any{"key": k, "value": value}
+	}
+
+	return list
+}
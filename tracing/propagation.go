@@ -0,0 +1,114 @@
+/*
+Copyright © 2024 John Hooks john@hooks.technology
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// TraceParentHeader is the W3C trace context header.
+const TraceParentHeader = "traceparent"
+
+// Getter reads headers. http.Header, nats.Header and micro.Headers implement it.
+type Getter interface {
+	Get(string) string
+}
+
+// Setter writes headers. http.Header and nats.Header implement it.
+type Setter interface {
+	Set(string, string)
+}
+
+// TraceParent formats the span context as a W3C traceparent header value.
+func (s SpanContext) TraceParent() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", s.TraceID, s.SpanID, flags)
+}
+
+// ParseTraceParent parses a W3C traceparent header value.
+func ParseTraceParent(v string) (SpanContext, bool) {
+	v = strings.TrimSpace(v)
+	// the fields are lowercase hex
+	if v != strings.ToLower(v) {
+		return SpanContext{}, false
+	}
+
+	parts := strings.Split(v, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, false
+	}
+
+	if _, err := hex.DecodeString(parts[0]); err != nil {
+		return SpanContext{}, false
+	}
+
+	// version 00 has exactly four fields, later versions may add more
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+
+	return sc, sc.IsValid()
+}
+
+// Inject writes the trace context of the current span to the headers.
+func Inject(ctx context.Context, h Setter) {
+	if sc := SpanContextFromContext(ctx); sc.IsValid() {
+		h.Set(TraceParentHeader, sc.TraceParent())
+	}
+}
+
+// Extract returns a copy of the context with the parent read from the headers.
+func Extract(ctx context.Context, h Getter) context.Context {
+	sc, ok := ParseTraceParent(h.Get(TraceParentHeader))
+	if !ok {
+		return ctx
+	}
+
+	return WithRemoteParent(ctx, sc)
+}
+
+// NewRoot returns a new sampled span context for callers that don't record spans
+// themselves but want the services they call to join one trace.
+func NewRoot() SpanContext {
+	return SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true}
+}
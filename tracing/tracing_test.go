@@ -0,0 +1,235 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hooksie1/gophemeral/tracing"
+	"github.com/hooksie1/gophemeral/tracing/tracingtest"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	tt := []struct {
+		name    string
+		value   string
+		valid   bool
+		sampled bool
+	}{
+		{name: "sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", valid: true, sampled: true},
+		{name: "not sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", valid: true},
+		{name: "whitespace", value: " 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01 ", valid: true, sampled: true},
+		{name: "future version", value: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", valid: true, sampled: true},
+		{name: "empty", value: ""},
+		{name: "too few fields", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7"},
+		{name: "version 00 with extra field", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{name: "version ff", value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "version not hex", value: "zz-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "long version", value: "000-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "uppercase", value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01"},
+		{name: "all zero trace", value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "all zero span", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{name: "short trace", value: "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01"},
+		{name: "span not hex", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902zz-01"},
+		{name: "flags not hex", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x"},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			sc, ok := tracing.ParseTraceParent(v.value)
+			if ok != v.valid {
+				t.Fatalf("expected valid %t but got %t", v.valid, ok)
+			}
+
+			if !ok {
+				return
+			}
+
+			if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+				t.Errorf("unexpected span context %s", sc.TraceParent())
+			}
+
+			if sc.Sampled != v.sampled {
+				t.Errorf("expected sampled %t but got %t", v.sampled, sc.Sampled)
+			}
+		})
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	parent, _ := tracing.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h := http.Header{}
+	tracing.Inject(tracing.WithRemoteParent(context.Background(), parent), h)
+
+	if h.Get(tracing.TraceParentHeader) != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("unexpected traceparent %q", h.Get(tracing.TraceParentHeader))
+	}
+
+	if sc := tracing.SpanContextFromContext(tracing.Extract(context.Background(), h)); sc != parent {
+		t.Errorf("expected extracted parent %s but got %s", parent.TraceParent(), sc.TraceParent())
+	}
+
+	empty := http.Header{}
+	tracing.Inject(context.Background(), empty)
+	if len(empty) != 0 {
+		t.Errorf("expected no headers without a span but got %v", empty)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	sampled, _ := tracing.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	unsampled, _ := tracing.ParseTraceParent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+
+	tt := []struct {
+		name     string
+		parent   tracing.SpanContext
+		exported bool
+	}{
+		{name: "root", exported: true},
+		{name: "sampled parent", parent: sampled, exported: true},
+		{name: "unsampled parent", parent: unsampled},
+	}
+
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			exporter := &tracingtest.Exporter{}
+			tracer := tracing.NewTracer(exporter, nil)
+
+			ctx, span := tracer.Start(tracing.WithRemoteParent(context.Background(), v.parent), "parent", tracing.KindServer)
+			_, child := tracer.Start(ctx, "child", tracing.KindInternal)
+			child.End()
+			span.End()
+
+			if err := tracer.Flush(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if span.Context().Sampled != v.exported || child.Context().Sampled != v.exported {
+				t.Errorf("expected sampled %t", v.exported)
+			}
+
+			if !v.exported {
+				if len(exporter.Spans()) != 0 {
+					t.Errorf("expected no spans to be exported but got %d", len(exporter.Spans()))
+				}
+				return
+			}
+
+			if len(exporter.Spans()) != 2 {
+				t.Fatalf("expected 2 spans but got %d", len(exporter.Spans()))
+			}
+
+			childData, parentData := exporter.Spans()[0], exporter.Spans()[1]
+			if v.parent.IsValid() && (parentData.TraceID != v.parent.TraceID || parentData.ParentID != v.parent.SpanID) {
+				t.Errorf("expected span to continue the remote trace")
+			}
+
+			if childData.TraceID != parentData.TraceID || childData.ParentID != parentData.SpanID {
+				t.Errorf("expected child to be in the parent's trace")
+			}
+		})
+	}
+}
+
+func TestOTLPExport(t *testing.T) {
+	var body map[string]any
+	var path, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer srv.Close()
+
+	parent, _ := tracing.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	start := time.Unix(1700000000, 5)
+	span := tracing.SpanData{
+		TraceID:  parent.TraceID,
+		SpanID:   tracing.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		ParentID: parent.SpanID,
+		Name:     "POST /api/v1/secrets",
+		Kind:     tracing.KindServer,
+		Start:    start,
+		End:      start.Add(time.Millisecond),
+		Attributes: map[string]any{
+			"http.status_code": 500,
+			"retry":            true,
+			"ratio":            0.5,
+			"route":            "/api/v1/secrets",
+		},
+		Err: "boom",
+	}
+
+	exporter := tracing.NewOTLPExporter(srv.URL+"/", "gophemeral", map[string]string{"Authorization": "Bearer token"})
+	if err := exporter.Export(context.Background(), []tracing.SpanData{span}); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/v1/traces" || auth != "Bearer token" {
+		t.Errorf("expected a post to /v1/traces with the headers but got %s %q", path, auth)
+	}
+
+	resource := body["resourceSpans"].([]any)[0].(map[string]any)
+	service := resource["resource"].(map[string]any)["attributes"].([]any)[0].(map[string]any)
+	if service["key"] != "service.name" || service["value"].(map[string]any)["stringValue"] != "gophemeral" {
+		t.Errorf("unexpected resource attributes %v", service)
+	}
+
+	got := resource["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)[0].(map[string]any)
+	expected := map[string]any{
+		"traceId":           "4bf92f3577b34da6a3ce929d0e0e4736",
+		"spanId":            "0102030405060708",
+		"parentSpanId":      "00f067aa0ba902b7",
+		"name":              "POST /api/v1/secrets",
+		"kind":              float64(tracing.KindServer),
+		"startTimeUnixNano": "1700000000000000005",
+		"endTimeUnixNano":   "1700000000001000005",
+	}
+	for k, v := range expected {
+		if got[k] != v {
+			t.Errorf("expected %s %v but got %v", k, v, got[k])
+		}
+	}
+
+	status := got["status"].(map[string]any)
+	if status["code"] != float64(2) || status["message"] != "boom" {
+		t.Errorf("expected an error status but got %v", status)
+	}
+
+	attrs, _ := json.Marshal(got["attributes"])
+	expectedAttrs := `[{"key":"http.status_code","value":{"intValue":"500"}},{"key":"ratio","value":{"doubleValue":0.5}},{"key":"retry","value":{"boolValue":true}},{"key":"route","value":{"stringValue":"/api/v1/secrets"}}]`
+	if string(attrs) != expectedAttrs {
+		t.Errorf("expected attributes %s but got %s", expectedAttrs, attrs)
+	}
+}
+
+func TestOTLPExportStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	exporter := tracing.NewOTLPExporter(srv.URL+"/v1/traces", "gophemeral", nil)
+	if err := exporter.Export(context.Background(), []tracing.SpanData{{Name: "test"}}); err == nil {
+		t.Errorf("expected an error for a failed export")
+	}
+}
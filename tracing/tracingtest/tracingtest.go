@@ -0,0 +1,49 @@
+/*
+Copyright © 2023 John Hooks
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracingtest provides an exporter that records spans for tests.
+package tracingtest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hooksie1/gophemeral/tracing"
+)
+
+// Exporter keeps every exported span in memory.
+type Exporter struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (e *Exporter) Export(ctx context.Context, spans []tracing.SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// Spans returns the exported spans in the order they were exported.
+func (e *Exporter) Spans() []tracing.SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]tracing.SpanData(nil), e.spans...)
+}